@@ -0,0 +1,179 @@
+package hdsfhir
+
+import (
+	"strings"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+type Address struct {
+	Street  []string `json:"street"`
+	City    string   `json:"city"`
+	State   string   `json:"state"`
+	Zip     string   `json:"zip"`
+	Country string   `json:"country"`
+	Use     string   `json:"use"`
+}
+
+func (a *Address) FHIRAddress() fhir.Address {
+	return fhir.Address{
+		Use:        convertAddressUse(a.Use),
+		Line:       a.Street,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.Zip,
+		Country:    a.Country,
+	}
+}
+
+// AddressFromFHIR returns the FHIR address as an HDS address.  It is the inverse of Address.FHIRAddress.
+func AddressFromFHIR(address fhir.Address) *Address {
+	return &Address{
+		Street:  address.Line,
+		City:    address.City,
+		State:   address.State,
+		Zip:     address.PostalCode,
+		Country: address.Country,
+		Use:     addressUseFromFHIR(address.Use),
+	}
+}
+
+// convertAddressUse maps the HL7 V3 address use to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-address-use.html
+// If the use cannot be reliably mapped, an empty code will be returned.
+func convertAddressUse(use string) string {
+	switch use {
+	case "H", "HP", "HV":
+		return "home"
+	case "WP", "DIR", "PUB":
+		return "work"
+	case "TMP":
+		return "temp"
+	case "OLD", "BAD":
+		return "old"
+	}
+	return ""
+}
+
+// addressUseFromFHIR maps the FHIR address use back to an HL7 V3 address use.  It is the inverse of
+// convertAddressUse, choosing the most general HL7 V3 code where several map to the same FHIR code.
+func addressUseFromFHIR(use string) string {
+	switch use {
+	case "home":
+		return "HP"
+	case "work":
+		return "WP"
+	case "temp":
+		return "TMP"
+	case "old":
+		return "OLD"
+	}
+	return ""
+}
+
+type Telecom struct {
+	Use       string `json:"use"`
+	Value     string `json:"value"`
+	Preferred bool   `json:"preferred"`
+}
+
+func (t *Telecom) FHIRContactPoint() fhir.ContactPoint {
+	contactPoint := fhir.ContactPoint{Use: convertTelecomUse(t.Use)}
+	switch {
+	case strings.HasPrefix(t.Value, "mailto:"):
+		contactPoint.System = "email"
+		contactPoint.Value = strings.TrimPrefix(t.Value, "mailto:")
+	case strings.HasPrefix(t.Value, "fax:"):
+		contactPoint.System = "fax"
+		contactPoint.Value = strings.TrimPrefix(t.Value, "fax:")
+	case strings.HasPrefix(t.Value, "tel:"):
+		contactPoint.System = "phone"
+		contactPoint.Value = strings.TrimPrefix(t.Value, "tel:")
+	case strings.HasPrefix(t.Value, "http:"), strings.HasPrefix(t.Value, "https:"):
+		contactPoint.System = "other"
+		contactPoint.Value = t.Value
+	case strings.Contains(t.Value, "@"):
+		contactPoint.System = "email"
+		contactPoint.Value = t.Value
+	default:
+		contactPoint.System = "phone"
+		contactPoint.Value = t.Value
+	}
+	if t.Preferred {
+		rank := uint32(1)
+		contactPoint.Rank = &rank
+	}
+	return contactPoint
+}
+
+// TelecomFromFHIR returns the FHIR contact point as an HDS telecom.  It is the inverse of Telecom.FHIRContactPoint.
+func TelecomFromFHIR(contactPoint fhir.ContactPoint) *Telecom {
+	telecom := &Telecom{Use: telecomUseFromFHIR(contactPoint.Use)}
+	switch contactPoint.System {
+	case "email":
+		telecom.Value = "mailto:" + contactPoint.Value
+	case "fax":
+		telecom.Value = "fax:" + contactPoint.Value
+	case "phone":
+		telecom.Value = "tel:" + contactPoint.Value
+	default:
+		telecom.Value = contactPoint.Value
+	}
+	telecom.Preferred = contactPoint.Rank != nil && *contactPoint.Rank == 1
+	return telecom
+}
+
+// convertTelecomUse maps the HL7 V3 telecom use to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-contact-point-use.html
+// If the use cannot be reliably mapped, an empty code will be returned.
+func convertTelecomUse(use string) string {
+	switch use {
+	case "H", "HP", "HV":
+		return "home"
+	case "WP", "DIR", "PUB":
+		return "work"
+	case "MC", "PG":
+		return "mobile"
+	case "TMP":
+		return "temp"
+	case "OLD", "BAD":
+		return "old"
+	}
+	return ""
+}
+
+// telecomUseFromFHIR maps the FHIR contact point use back to an HL7 V3 telecom use.  It is the inverse of
+// convertTelecomUse, choosing the most general HL7 V3 code where several map to the same FHIR code.
+func telecomUseFromFHIR(use string) string {
+	switch use {
+	case "home":
+		return "HP"
+	case "work":
+		return "WP"
+	case "mobile":
+		return "MC"
+	case "temp":
+		return "TMP"
+	case "old":
+		return "OLD"
+	}
+	return ""
+}
+
+// addressesFromFHIR returns the FHIR addresses as HDS addresses, or nil if there are none
+func addressesFromFHIR(addresses []fhir.Address) []*Address {
+	var hdsAddresses []*Address
+	for _, address := range addresses {
+		hdsAddresses = append(hdsAddresses, AddressFromFHIR(address))
+	}
+	return hdsAddresses
+}
+
+// telecomsFromFHIR returns the FHIR contact points as HDS telecoms, or nil if there are none
+func telecomsFromFHIR(contactPoints []fhir.ContactPoint) []*Telecom {
+	var telecoms []*Telecom
+	for _, contactPoint := range contactPoints {
+		telecoms = append(telecoms, TelecomFromFHIR(contactPoint))
+	}
+	return telecoms
+}
@@ -0,0 +1,207 @@
+package hdsfhir
+
+import (
+	"encoding/base64"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+type AdvanceDirective struct {
+	Entry
+	FreeText string `json:"free_text"`
+}
+
+func (a *AdvanceDirective) FHIRModels() []interface{} {
+	// A document reference can't express that the directive is refuted, so negated directives are always observations
+	if a.FreeText != "" && !a.NegationInd {
+		return a.convertDocumentReference()
+	}
+
+	return a.convertObservation()
+}
+
+func (a *AdvanceDirective) convertObservation() []interface{} {
+	fhirObservation := &fhir.Observation{}
+	fhirObservation.Id = a.GetTempID()
+	fhirObservation.Status = a.convertObservationStatus()
+	fhirObservation.Category = observationCategory("advance-directive", "Advance Directive")
+	fhirObservation.Code = a.Codes.FHIRCodeableConcept(a.Description)
+	fhirObservation.Subject = a.Patient.FHIRReference()
+	fhirObservation.Encounter = a.Patient.MatchingEncounterReference(a.Entry)
+	fhirObservation.EffectivePeriod = a.GetFHIRPeriod()
+	// Like verification status on conditions, the value indicates if the directive is in place or refuted
+	if a.NegationInd {
+		fhirObservation.ValueCodeableConcept = yesNoConcept("N", "No")
+	} else {
+		fhirObservation.ValueCodeableConcept = yesNoConcept("Y", "Yes")
+	}
+	fhirObservation.Comments = a.FreeText
+
+	return []interface{}{fhirObservation}
+}
+
+// FromFHIRObservation sets the advance directive from the FHIR observation.  It is the inverse of
+// convertObservation.
+func (a *AdvanceDirective) FromFHIRObservation(observation *fhir.Observation) {
+	a.SetTempID(observation.Id)
+	a.setFHIRCodes(observation.Code)
+	a.setFHIRPeriod(observation.EffectivePeriod)
+	a.StatusCode = observationStatusFromFHIR(observation.Status)
+	a.NegationInd = observation.ValueCodeableConcept != nil &&
+		observation.ValueCodeableConcept.MatchesCode("http://hl7.org/fhir/v2/0136", "N")
+	a.FreeText = observation.Comments
+}
+
+// convertObservationStatus maps the status to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-observation-status.html
+// If the status cannot be reliably mapped, "final" will be assumed.
+func (a *AdvanceDirective) convertObservationStatus() string {
+	var status string
+	statusConcept := a.StatusCode.FHIRCodeableConcept("")
+	switch {
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "cancelled"):
+		status = "cancelled"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "aborted"):
+		status = "cancelled"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "nullified"):
+		status = "entered-in-error"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "obsolete"):
+		status = "amended"
+	default:
+		status = "final"
+	}
+
+	return status
+}
+
+// observationStatusFromFHIR maps the FHIR observation status back to an ActStatus.  It is the inverse of
+// convertObservationStatus.  If the status cannot be mapped, nil is returned.
+func observationStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "cancelled":
+		return actStatus("cancelled")
+	case "entered-in-error":
+		return actStatus("nullified")
+	case "amended":
+		return actStatus("obsolete")
+	}
+	return nil
+}
+
+func (a *AdvanceDirective) convertDocumentReference() []interface{} {
+	fhirDocument := &fhir.DocumentReference{}
+	fhirDocument.Id = a.GetTempID()
+	fhirDocument.Subject = a.Patient.FHIRReference()
+	fhirDocument.Type = a.Codes.FHIRCodeableConcept(a.Description)
+	fhirDocument.Class = &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "http://loinc.org", Code: "42348-3", Display: "Advance directives"},
+		},
+		Text: "Advance directives",
+	}
+	fhirDocument.Status = a.convertDocumentReferenceStatus()
+	fhirDocument.Description = a.Description
+	if indexed := a.indexedTime(); indexed != nil {
+		fhirDocument.Indexed = indexed.FHIRDateTime()
+	}
+	fhirDocument.Content = []fhir.DocumentReferenceContentComponent{
+		{
+			Attachment: &fhir.Attachment{
+				ContentType: "text/plain",
+				Data:        base64.StdEncoding.EncodeToString([]byte(a.FreeText)),
+			},
+		},
+	}
+	fhirDocument.Context = &fhir.DocumentReferenceContextComponent{
+		Encounter: a.Patient.MatchingEncounterReference(a.Entry),
+		Period:    a.GetFHIRPeriod(),
+	}
+
+	return []interface{}{fhirDocument}
+}
+
+// indexedTime returns the time the document is indexed on: the entry's time, or else the start or end of its period.
+// The indexed time is required, but when HDS has no times at all, none is made up, since a document converted at
+// different times would never match itself; nil is returned, and Patient.Convert reports it.
+func (a *AdvanceDirective) indexedTime() *UnixTime {
+	switch {
+	case a.Time != nil:
+		return a.Time
+	case a.StartTime != nil:
+		return a.StartTime
+	}
+	return a.EndTime
+}
+
+// sameTime indicates if both times are set and equal
+func sameTime(t1, t2 *UnixTime) bool {
+	return t1 != nil && t2 != nil && *t1 == *t2
+}
+
+// FromFHIRDocumentReference sets the advance directive from the FHIR document reference.  It is the inverse of
+// convertDocumentReference.
+func (a *AdvanceDirective) FromFHIRDocumentReference(fhirDocument *fhir.DocumentReference) {
+	a.SetTempID(fhirDocument.Id)
+	a.setFHIRCodes(fhirDocument.Type)
+	if fhirDocument.Description != "" {
+		a.Description = fhirDocument.Description
+	}
+	a.StatusCode = documentReferenceStatusFromFHIR(fhirDocument.Status)
+	if fhirDocument.Context != nil {
+		a.setFHIRPeriod(fhirDocument.Context.Period)
+	}
+	// The indexed time is only the entry's own time when it wasn't taken from the period
+	if indexed := UnixTimeFromFHIR(fhirDocument.Indexed); indexed != nil && !sameTime(indexed, a.StartTime) &&
+		!sameTime(indexed, a.EndTime) {
+		a.Time = indexed
+	}
+	for _, content := range fhirDocument.Content {
+		if content.Attachment != nil && content.Attachment.ContentType == "text/plain" {
+			if data, err := base64.StdEncoding.DecodeString(content.Attachment.Data); err == nil {
+				a.FreeText = string(data)
+				break
+			}
+		}
+	}
+}
+
+// convertDocumentReferenceStatus maps the status to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-document-reference-status.html
+// If the status cannot be reliably mapped, "current" will be assumed.
+func (a *AdvanceDirective) convertDocumentReferenceStatus() string {
+	var status string
+	statusConcept := a.StatusCode.FHIRCodeableConcept("")
+	switch {
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "cancelled"):
+		status = "entered-in-error"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "nullified"):
+		status = "entered-in-error"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "obsolete"):
+		status = "superseded"
+	default:
+		status = "current"
+	}
+
+	return status
+}
+
+// documentReferenceStatusFromFHIR maps the FHIR document reference status back to an ActStatus.  It is the inverse
+// of convertDocumentReferenceStatus.  If the status cannot be mapped, nil is returned.
+func documentReferenceStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "entered-in-error":
+		return actStatus("nullified")
+	case "superseded":
+		return actStatus("obsolete")
+	}
+	return nil
+}
+
+func yesNoConcept(code, display string) *fhir.CodeableConcept {
+	return &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "http://hl7.org/fhir/v2/0136", Code: code, Display: display},
+		},
+		Text: display,
+	}
+}
@@ -0,0 +1,124 @@
+package hdsfhir
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type AdvanceDirectiveSuite struct {
+	Patient           *Patient
+	AdvanceDirectives map[string]*AdvanceDirective
+	Encounter         *Encounter
+}
+
+var _ = Suite(&AdvanceDirectiveSuite{})
+
+func (s *AdvanceDirectiveSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/advance_directives.json")
+	util.CheckErr(err)
+
+	s.AdvanceDirectives = make(map[string]*AdvanceDirective)
+	err = json.Unmarshal(data, &s.AdvanceDirectives)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	s.Encounter = &Encounter{Entry: Entry{StartTime: NewUnixTime(1320148800), EndTime: NewUnixTime(1320152400)}}
+	s.Patient.Encounters = []*Encounter{s.Encounter}
+	for _, directive := range s.AdvanceDirectives {
+		directive.Patient = s.Patient
+	}
+}
+
+func (s *AdvanceDirectiveSuite) TestCodedDirective(c *C) {
+	models := s.AdvanceDirectives["dnr"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Status, Equals, "final")
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "advance-directive"), Equals, true)
+	c.Assert(observation.Code.Text, Equals, "Diagnostic Study, Performed: Do Not Resuscitate")
+	c.Assert(observation.Code.MatchesCode("http://snomed.info/sct", "304253006"), Equals, true)
+	c.Assert(observation.ValueCodeableConcept.MatchesCode("http://hl7.org/fhir/v2/0136", "Y"), Equals, true)
+	c.Assert(observation.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(observation.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(observation.EffectivePeriod.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+}
+
+func (s *AdvanceDirectiveSuite) TestNegatedDirective(c *C) {
+	models := s.AdvanceDirectives["noLivingWill"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Code.MatchesCode("http://snomed.info/sct", "425392003"), Equals, true)
+	c.Assert(observation.ValueCodeableConcept.MatchesCode("http://hl7.org/fhir/v2/0136", "N"), Equals, true)
+	c.Assert(observation.Comments, Equals, "Patient declined to complete a living will")
+	c.Assert(observation.Encounter, IsNil)
+}
+
+func (s *AdvanceDirectiveSuite) TestFreeTextDirective(c *C) {
+	models := s.AdvanceDirectives["livingWill"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.DocumentReference{})
+
+	document := models[0].(*fhir.DocumentReference)
+	c.Assert(document.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(document.Type.MatchesCode("http://snomed.info/sct", "425392003"), Equals, true)
+	c.Assert(document.Class.MatchesCode("http://loinc.org", "42348-3"), Equals, true)
+	c.Assert(document.Status, Equals, "current")
+	c.Assert(document.Indexed, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+	c.Assert(document.Content, HasLen, 1)
+	c.Assert(document.Content[0].Attachment.ContentType, Equals, "text/plain")
+	text, err := base64.StdEncoding.DecodeString(document.Content[0].Attachment.Data)
+	util.CheckErr(err)
+	c.Assert(string(text), Equals, "No mechanical ventilation")
+	c.Assert(document.Context.Encounter, DeepEquals, s.Encounter.FHIRReference())
+}
+
+func (s *AdvanceDirectiveSuite) TestIndexedWithoutTimes(c *C) {
+	directive := &AdvanceDirective{Entry: Entry{Patient: s.Patient, EndTime: NewUnixTime(1320149800)}, FreeText: "DNR"}
+	document := directive.FHIRModels()[0].(*fhir.DocumentReference)
+	c.Assert(document.Indexed, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+
+	// Reading it back doesn't turn the end time into the entry's time
+	roundTrip := &AdvanceDirective{}
+	roundTrip.FromFHIRDocumentReference(document)
+	c.Assert(roundTrip.Time, IsNil)
+	c.Assert(roundTrip.EndTime, DeepEquals, NewUnixTime(1320149800))
+
+	// With no times at all, no indexed time is made up, and the conversion warns about it
+	directive.EndTime = nil
+	document = directive.FHIRModels()[0].(*fhir.DocumentReference)
+	c.Assert(document.Indexed, IsNil)
+	patient := &Patient{AdvanceDirectives: []*AdvanceDirective{directive}}
+	patient.linkEntries()
+	result, err := patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Diagnostics, HasLen, 2)
+	c.Assert(result.Diagnostics[1].Severity, Equals, SeverityWarning)
+	c.Assert(result.Diagnostics[1].Type, Equals, "incomplete")
+	c.Assert(result.Diagnostics[1].Location(), Equals, "advance_directives[0]")
+}
+
+func (s *AdvanceDirectiveSuite) TestConditionalUpdate(c *C) {
+	patient := &Patient{AdvanceDirectives: []*AdvanceDirective{
+		{Entry: Entry{Codes: CodeMap{"SNOMED-CT": []string{"425392003"}}, StartTime: NewUnixTime(1320149800)}, FreeText: "DNR"},
+		{Entry: Entry{Codes: CodeMap{"SNOMED-CT": []string{"425392003"}}}, FreeText: "DNR"},
+	}}
+	patient.linkEntries()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	patientRef := bundle.Entry[0].FullUrl
+	assertURL(c, bundle, 1, "DocumentReference?indexed=%s&patient=%s&type=http://snomed.info/sct|425392003", ld("2011-11-01T12:16:40"), patientRef)
+	// Without an indexed time, the document is matched on its entry identifier
+	identifier := findEntryIdentifier(bundle.Entry[2].Resource)
+	c.Assert(identifier, NotNil)
+	assertURL(c, bundle, 2, "DocumentReference?identifier=%s", url.QueryEscape(EntryIdentifierSystem+"|"+identifier.Value))
+}
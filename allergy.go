@@ -35,6 +35,26 @@ func (a *Allergy) FHIRModels() []interface{} {
 	return []interface{}{fhirAllergy}
 }
 
+// FromFHIR sets the allergy from the FHIR allergy intolerance.  It is the inverse of FHIRModels.
+func (a *Allergy) FromFHIR(fhirAllergy *fhir.AllergyIntolerance) {
+	a.SetTempID(fhirAllergy.Id)
+	a.StartTime = UnixTimeFromFHIR(fhirAllergy.Onset)
+	a.setFHIRCodes(fhirAllergy.Substance)
+	a.NegationInd = fhirAllergy.Status == "refuted"
+	a.StatusCode = allergyStatusFromFHIR(fhirAllergy.Status)
+	a.Severity = allergySeverityFromFHIR(fhirAllergy.Criticality, "")
+	if len(fhirAllergy.Reaction) > 0 {
+		reaction := fhirAllergy.Reaction[0]
+		if len(reaction.Manifestation) > 0 {
+			a.Reaction = CodeObjectFromFHIR(&reaction.Manifestation[0])
+		}
+		// The reaction severity is more specific than the criticality
+		if severity := allergySeverityFromFHIR("", reaction.Severity); severity != nil {
+			a.Severity = severity
+		}
+	}
+}
+
 // convertStatus maps the status to a code in the "required" FHIR value set:
 //   http://hl7.org/fhir/DSTU2/valueset-allergy-intolerance-status.html
 // If the status cannot be reliably mapped, active is assumed.
@@ -66,6 +86,20 @@ func (a *Allergy) convertStatus() string {
 	return status
 }
 
+// allergyStatusFromFHIR maps the FHIR status back to the SNOMED-CT code HDS uses.  It is the inverse of
+// convertStatus.  If the status cannot be mapped (including "refuted", which is a negation), nil is returned.
+func allergyStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "active":
+		return CodeMap{"SNOMED-CT": []string{"55561003"}}
+	case "inactive":
+		return CodeMap{"SNOMED-CT": []string{"73425007"}}
+	case "resolved":
+		return CodeMap{"SNOMED-CT": []string{"413322009"}}
+	}
+	return nil
+}
+
 // convertCriticality maps the severity to a CodeableConcept. FHIR has a "required" value set for
 // criticality:
 //   http://hl7.org/fhir/DSTU2/valueset-allergy-intolerance-criticality.html
@@ -126,3 +160,21 @@ func (a *Allergy) convertSeverity() string {
 
 	return ""
 }
+
+// allergySeverityFromFHIR maps the FHIR criticality or reaction severity back to the SNOMED-CT severity HDS uses.  It
+// is the inverse of convertCriticality and convertSeverity, choosing the plain mild, moderate, or severe code where
+// several map to the same FHIR code.  If neither can be mapped, nil is returned.
+func allergySeverityFromFHIR(criticality string, severity string) *CodeObject {
+	var code string
+	switch {
+	case criticality == "CRITL" || severity == "mild":
+		code = "255604002"
+	case criticality == "CRITU" || severity == "moderate":
+		code = "6736007"
+	case criticality == "CRITH" || severity == "severe":
+		code = "24484000"
+	default:
+		return nil
+	}
+	return &CodeObject{Code: code, CodeSystem: "SNOMED-CT"}
+}
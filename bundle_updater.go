@@ -10,91 +10,315 @@ import (
 	"github.com/intervention-engine/fhir/models"
 )
 
+// ConditionalStrategy determines how a resource in a transaction bundle is matched with an existing resource on the
+// server, so that importing the same patient again doesn't duplicate it
+type ConditionalStrategy string
+
+const (
+	// Unconditional always creates the resource (using POST)
+	Unconditional ConditionalStrategy = ""
+	// ConditionalUpdate updates the matching resource, or creates the resource if there is no match (using PUT with
+	// search parameters).  Servers reject the update if more than one resource matches.
+	ConditionalUpdate ConditionalStrategy = "update"
+	// ConditionalCreate creates the resource only if there is no matching resource (using POST with If-None-Exist),
+	// leaving any matching resource as it is.  This suits servers that don't allow updates, and resources that
+	// shouldn't change once they are created.
+	ConditionalCreate ConditionalStrategy = "create"
+)
+
+// BundleOptions configures the requests in a transaction bundle
+type BundleOptions struct {
+	// Conditional is the strategy used for resource types that aren't in ConditionalByType
+	Conditional ConditionalStrategy
+	// ConditionalByType overrides the strategy for the resource types it has (e.g., "Observation")
+	ConditionalByType map[string]ConditionalStrategy
+	// MatchRules configures the searches used to match resources.  If it is nil, DefaultMatchRules is used.
+	MatchRules MatchRuleSet
+}
+
+// conditionalStrategy returns the strategy for the resource type
+func (o BundleOptions) conditionalStrategy(resourceType string) ConditionalStrategy {
+	if strategy, ok := o.ConditionalByType[resourceType]; ok {
+		return strategy
+	}
+	return o.Conditional
+}
+
+// validate returns an error if any of the strategies is unknown
+func (o BundleOptions) validate() error {
+	if !o.Conditional.known() {
+		return fmt.Errorf("unknown conditional strategy %q", o.Conditional)
+	}
+	resourceTypes := make([]string, 0, len(o.ConditionalByType))
+	for resourceType := range o.ConditionalByType {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	for _, resourceType := range resourceTypes {
+		if strategy := o.ConditionalByType[resourceType]; !strategy.known() {
+			return fmt.Errorf("unknown conditional strategy %q for %s", strategy, resourceType)
+		}
+	}
+	return nil
+}
+
+// known indicates if the strategy is one of the ConditionalStrategy constants
+func (s ConditionalStrategy) known() bool {
+	return s == Unconditional || s == ConditionalUpdate || s == ConditionalCreate
+}
+
+var defaultMatchRules = DefaultMatchRules()
+
+// matchRule returns the match rule for the resource type
+func (o BundleOptions) matchRule(resourceType string) MatchRule {
+	if o.MatchRules == nil {
+		return defaultMatchRules[resourceType]
+	}
+	return o.MatchRules[resourceType]
+}
+
 // ConvertToConditionalUpdates converts a bundle containing POST requests to a bundle with PUT requests using
 // conditional updates.  For patient resources, the update is based on the Medical Record Number.  For all other
-// resources it is based on reasonable indicators of sameness (such as equal dates and codes).
+// resources it is based on reasonable indicators of sameness (such as equal dates and codes).  The default match rules
+// are used; see ConvertToConditionalRequests to configure them.
 func ConvertToConditionalUpdates(bundle *models.Bundle) error {
+	return ConvertToConditionalRequests(bundle, BundleOptions{Conditional: ConditionalUpdate})
+}
+
+// ConvertToConditionalCreates converts the POST requests in a bundle to conditional creates, which only create the
+// resources that have no match on the server.  Resources are matched the same way as in ConvertToConditionalUpdates.
+func ConvertToConditionalCreates(bundle *models.Bundle) error {
+	return ConvertToConditionalRequests(bundle, BundleOptions{Conditional: ConditionalCreate})
+}
+
+// ConvertToConditionalRequests converts the POST requests in a bundle to conditional updates or creates, using the
+// strategy the options select for each resource type.  Resources that can't be matched precisely enough are still
+// created unconditionally.  If the options have an unknown strategy, an error is returned and the bundle is left
+// unchanged.
+func ConvertToConditionalRequests(bundle *models.Bundle, opts BundleOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
 	for _, entry := range bundle.Entry {
-		values := url.Values{}
-		switch t := entry.Resource.(type) {
-		case *models.AllergyIntolerance:
-			if check(t.Patient, t.Substance, t.Onset) {
-				addRefParam(values, "patient", t.Patient)
-				addCCParam(values, "substance", t.Substance)
-				addDateParam(values, "onset", t.Onset)
-			}
-		case *models.Condition:
-			if check(t.Patient, t.Code, t.OnsetDateTime) {
-				addRefParam(values, "patient", t.Patient)
-				addCCParam(values, "code", t.Code)
-				addDateParam(values, "onset", t.OnsetDateTime)
-			}
-		case *models.DiagnosticReport:
-			// TODO: Consider if this query is precise enough, consider searching on results too
-			if check(t.Subject, t.Code, t.EffectivePeriod) {
-				addRefParam(values, "patient", t.Subject)
-				addCCParam(values, "code", t.Code)
-				addPeriodParam(values, "date", t.EffectivePeriod)
-			}
-		case *models.Encounter:
-			if check(t.Patient, t.Type, t.Period) {
-				addRefParam(values, "patient", t.Patient)
-				for _, cc := range t.Type {
-					addCCParam(values, "type", &cc)
-				}
-				// TODO: the date param references "a date within the period the encounter lasted."  Is this OK?
-				addPeriodParam(values, "date", t.Period)
-			}
-		case *models.Immunization:
-			if check(t.Patient, t.VaccineCode, t.Date) {
-				addRefParam(values, "patient", t.Patient)
-				addCCParam(values, "vaccine-code", t.VaccineCode)
-				addDateParam(values, "date", t.Date)
-			}
-		case *models.MedicationStatement:
-			if check(t.Patient, t.MedicationCodeableConcept, t.EffectivePeriod) {
-				addRefParam(values, "patient", t.Patient)
-				addCCParam(values, "code", t.MedicationCodeableConcept)
-				addPeriodParam(values, "effectivedate", t.EffectivePeriod)
-			}
-		case *models.Observation:
-			if check(t.Subject, t.Code, t.EffectivePeriod) {
-				addRefParam(values, "patient", t.Subject)
-				addCCParam(values, "code", t.Code)
-				addPeriodParam(values, "date", t.EffectivePeriod)
-				if check(t.ValueCodeableConcept) {
-					addCCParam(values, "value-concept", t.ValueCodeableConcept)
-				} else if check(t.ValueQuantity) {
-					q := t.ValueQuantity
-					if q.Code != "" {
-						values.Add("value-quantity", fmt.Sprintf("%g|%s|%s", *q.Value, q.System, q.Code))
-					} else {
-						values.Add("value-quantity", fmt.Sprintf("%g|%s|%s", *q.Value, q.System, q.Unit))
-					}
-				} else if check(t.ValueString) {
-					values.Add("value-string", t.ValueString)
+		if entry.Request == nil || entry.Request.Method != "POST" {
+			continue
+		}
+		values := conditionalSearch(entry.Resource, opts.matchRule(entry.Request.Url))
+		if len(values) == 0 {
+			continue
+		}
+		// The URL of a POST is the resource type
+		switch opts.conditionalStrategy(entry.Request.Url) {
+		case ConditionalUpdate:
+			entry.Request.Method = "PUT"
+			entry.Request.Url += "?" + values.Encode()
+		case ConditionalCreate:
+			entry.Request.IfNoneExist = values.Encode()
+		}
+	}
+	return nil
+}
+
+// conditionalSearch returns the search parameters that match the resource with the same resource on the server, or
+// no parameters if it can't be matched precisely enough.  The rule selects which of the parameters are used.
+func conditionalSearch(resource interface{}, rule MatchRule) url.Values {
+	b := &searchBuilder{values: url.Values{}, rule: rule}
+	switch t := resource.(type) {
+	case *models.AllergyIntolerance:
+		if b.check("patient", t.Patient, "substance", t.Substance, "onset", t.Onset) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("substance", t.Substance)
+			b.addDateParam("onset", t.Onset)
+		}
+	case *models.Condition:
+		if b.check("patient", t.Patient, "code", t.Code, "onset", t.OnsetDateTime) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("code", t.Code)
+			b.addDateParam("onset", t.OnsetDateTime)
+		}
+	case *models.DiagnosticReport:
+		// TODO: Consider if this query is precise enough, consider searching on results too
+		if b.check("patient", t.Subject, "code", t.Code, "date", t.EffectivePeriod) {
+			b.addRefParam("patient", t.Subject)
+			b.addCCParam("code", t.Code)
+			b.addPeriodParam("date", t.EffectivePeriod)
+		}
+	case *models.Coverage:
+		// Coverage has no patient search param, so only the member ID is precise enough to match on.  Coverages without
+		// one are matched on their entry identifiers instead, since several of them may share an issuer and type.
+		var identifier *models.Identifier
+		if len(t.Identifier) > 0 && t.Identifier[0].Value != "" && t.Identifier[0].System != EntryIdentifierSystem {
+			identifier = &t.Identifier[0]
+		}
+		if b.check("issuer", t.Issuer, "identifier", identifier) {
+			b.addRefParam("issuer", t.Issuer)
+			b.addIdentifierParam("identifier", identifier)
+			if t.Type != nil {
+				b.add("type", t.Type.System+"|"+t.Type.Code)
+			}
+		}
+	case *models.Device:
+		// A patient may have several devices of the same type (e.g., a pacemaker and its replacement), so devices
+		// converted from HDS entries are matched on their entry identifiers (below) instead of their types
+		if findEntryIdentifier(t) == nil && b.check("patient", t.Patient, "type", t.Type) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("type", t.Type)
+		}
+	case *models.DeviceUseStatement:
+		// DeviceUseStatement only supports searching by patient and device, so rely on the device's match
+		if b.check("patient", t.Subject, "device", t.Device) {
+			b.addRefParam("patient", t.Subject)
+			b.addRefParam("device", t.Device)
+		}
+	case *models.DocumentReference:
+		// Without an indexed time (see AdvanceDirective), the document is matched on its entry identifier instead
+		if b.check("patient", t.Subject, "type", t.Type, "indexed", t.Indexed) {
+			b.addRefParam("patient", t.Subject)
+			b.addCCParam("type", t.Type)
+			b.addDateParam("indexed", t.Indexed)
+		}
+	case *models.Encounter:
+		if b.check("patient", t.Patient, "type", t.Type, "date", t.Period) {
+			b.addRefParam("patient", t.Patient)
+			for _, cc := range t.Type {
+				b.addCCParam("type", &cc)
+			}
+			// TODO: the date param references "a date within the period the encounter lasted."  Is this OK?
+			b.addPeriodParam("date", t.Period)
+		}
+	case *models.Immunization:
+		if b.check("patient", t.Patient, "vaccine-code", t.VaccineCode, "date", t.Date) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("vaccine-code", t.VaccineCode)
+			b.addDateParam("date", t.Date)
+		}
+	case *models.Location:
+		// Locations without names are kinds of locations (e.g., "home"), which are too vague to match on by type, so
+		// they are matched by entry identifier
+		if b.check("name", t.Name) {
+			b.add("name", t.Name)
+			// Facility types in code systems FHIR doesn't know have no system, and would only match other unknown
+			// systems' codes
+			if check(t.Type) && hasCodingSystems(t.Type) {
+				b.addCCParam("type", t.Type)
+			}
+		}
+	case *models.MedicationAdministration:
+		if b.check("patient", t.Patient, "code", t.MedicationCodeableConcept, "effectivetime", t.EffectiveTimePeriod) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("code", t.MedicationCodeableConcept)
+			b.addPeriodParam("effectivetime", t.EffectiveTimePeriod)
+		}
+	case *models.MedicationDispense:
+		if b.check("patient", t.Patient, "code", t.MedicationCodeableConcept, "whenhandedover", t.WhenHandedOver) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("code", t.MedicationCodeableConcept)
+			b.addDateParam("whenhandedover", t.WhenHandedOver)
+		}
+	case *models.MedicationOrder:
+		if b.check("patient", t.Patient, "code", t.MedicationCodeableConcept, "datewritten", t.DateWritten) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("code", t.MedicationCodeableConcept)
+			b.addDateParam("datewritten", t.DateWritten)
+		}
+	case *models.MedicationStatement:
+		if b.check("patient", t.Patient, "code", t.MedicationCodeableConcept, "effectivedate", t.EffectivePeriod) {
+			b.addRefParam("patient", t.Patient)
+			b.addCCParam("code", t.MedicationCodeableConcept)
+			b.addPeriodParam("effectivedate", t.EffectivePeriod)
+		}
+	case *models.Observation:
+		if b.check("patient", t.Subject, "code", t.Code, "date", t.EffectivePeriod) {
+			b.addRefParam("patient", t.Subject)
+			b.addCCParam("code", t.Code)
+			b.addPeriodParam("date", t.EffectivePeriod)
+			if check(t.ValueCodeableConcept) {
+				b.addCCParam("value-concept", t.ValueCodeableConcept)
+			} else if check(t.ValueQuantity) {
+				q := t.ValueQuantity
+				if q.Code != "" {
+					b.add("value-quantity", fmt.Sprintf("%g|%s|%s", *q.Value, q.System, q.Code))
+				} else {
+					b.add("value-quantity", fmt.Sprintf("%g|%s|%s", *q.Value, q.System, q.Unit))
 				}
+			} else if check(t.ValueString) {
+				b.add("value-string", t.ValueString)
 			}
-		case *models.Procedure:
-			if check(t.Subject, t.Code, t.PerformedPeriod) {
-				addRefParam(values, "patient", t.Subject)
-				addCCParam(values, "code", t.Code)
-				addPeriodParam(values, "date", t.PerformedPeriod)
+		}
+	case *models.Procedure:
+		if b.check("patient", t.Subject, "code", t.Code, "date", t.PerformedPeriod) {
+			b.addRefParam("patient", t.Subject)
+			b.addCCParam("code", t.Code)
+			b.addPeriodParam("date", t.PerformedPeriod)
+		}
+	case *models.ProcedureRequest:
+		// ProcedureRequest does not have search params for code or orderedOn, so we can't get precise enough for a
+		// conditional update without the entry identifier (below)
+	case *models.Organization:
+		if b.check("name", t.Name, "type", t.Type) {
+			b.add("name", t.Name)
+			b.addCCParam("type", t.Type)
+		}
+	case *models.Practitioner:
+		// Prefer the NPI, since it is unique, but fall back to the name when it isn't available
+		var npi *models.Identifier
+		for i, identifier := range t.Identifier {
+			if identifier.System == "http://hl7.org/fhir/sid/us-npi" && identifier.Value != "" {
+				npi = &t.Identifier[i]
+				break
 			}
-		case *models.ProcedureRequest:
-			// We can't do anything meaningful because ProcedureRequest does not have search params
-			// for code or orderedOn.  We simply can't get precise enough for a conditional update.
-		case *models.Patient:
-			if len(t.Identifier) > 0 && t.Identifier[0].Value != "" {
-				values.Set("identifier", t.Identifier[0].Value)
+		}
+		if npi != nil && b.rule.uses("identifier") {
+			b.addIdentifierParam("identifier", npi)
+		} else if t.Name != nil && len(t.Name.Family) > 0 {
+			for _, name := range t.Name.Family {
+				b.add("family", name)
+			}
+			for _, name := range t.Name.Given {
+				b.add("given", name)
 			}
 		}
-		if entry.Request.Method == "POST" && len(values) > 0 {
-			entry.Request.Method = "PUT"
-			entry.Request.Url += "?" + values.Encode()
+	case *models.RelatedPerson:
+		if b.check("patient", t.Patient) && t.Name != nil && len(t.Name.Family) > 0 {
+			b.addRefParam("patient", t.Patient)
+			for _, name := range t.Name.Family {
+				b.add("name", name)
+			}
+			for _, name := range t.Name.Given {
+				b.add("name", name)
+			}
+		}
+	case *models.Patient:
+		// By default, only the medical record number (the first identifier) is used
+		var identifier *models.Identifier
+		if len(t.Identifier) > 0 && t.Identifier[0].Value != "" {
+			identifier = &t.Identifier[0]
+		}
+		if b.check("identifier", identifier, "birthdate", t.BirthDate) {
+			b.addIdentifierParam("identifier", identifier)
+			if len(t.Name) > 0 {
+				for _, name := range t.Name[0].Family {
+					b.add("family", name)
+				}
+				for _, name := range t.Name[0].Given {
+					b.add("given", name)
+				}
+			}
+			if t.BirthDate != nil {
+				b.add("birthdate", t.BirthDate.Time.Format("2006-01-02"))
+			}
+			if t.Gender != "" {
+				b.add("gender", t.Gender)
+			}
 		}
 	}
-	return nil
+	if len(b.values) == 0 {
+		// Fall back to the entry identifier for resources that can't be matched on their data, whether their types
+		// have no suitable search params (e.g., Goal) or they are missing the data to search on (e.g., a transfer's
+		// location, which has no name)
+		b.addEntryIdentifierParam(resource)
+	}
+	return b.values
 }
 
 func check(things ...interface{}) bool {
@@ -124,6 +348,10 @@ func check(things ...interface{}) bool {
 			if t == nil || t.Reference == "" {
 				return false
 			}
+		case *models.Identifier:
+			if t == nil || t.Value == "" {
+				return false
+			}
 		case string:
 			if t == "" {
 				return false
@@ -133,29 +361,106 @@ func check(things ...interface{}) bool {
 	return true
 }
 
-func addCCParam(values url.Values, name string, cc *models.CodeableConcept) {
+// searchBuilder adds the search parameters its match rule uses.  The things the other parameters search on aren't
+// checked, so they may be missing.
+type searchBuilder struct {
+	values url.Values
+	rule   MatchRule
+}
+
+// check takes pairs of search parameter names and the things they search on, and indicates if each thing the rule
+// uses is available (see the check function)
+func (b *searchBuilder) check(pairs ...interface{}) bool {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if b.rule.uses(pairs[i].(string)) && !check(pairs[i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *searchBuilder) add(name, value string) {
+	if b.rule.uses(name) {
+		b.values.Add(name, value)
+	}
+}
+
+func (b *searchBuilder) addCCParam(name string, cc *models.CodeableConcept) {
+	if !b.rule.uses(name) {
+		return
+	}
 	codes := make([]string, len(cc.Coding))
 	for i := range cc.Coding {
 		codes[i] = cc.Coding[i].System + "|" + cc.Coding[i].Code
 	}
 	// sort for predictability (a.k.a., easier testing)
 	sort.Strings(codes)
-	values.Add(name, strings.Join(codes, ","))
+	b.add(name, strings.Join(codes, ","))
 }
 
-func addDateParam(values url.Values, name string, date *models.FHIRDateTime) {
-	values.Add(name, date.Time.Format("2006-01-02T15:04:05-07:00"))
+// hasCodingSystems indicates if every coding in the concept has a system
+func hasCodingSystems(cc *models.CodeableConcept) bool {
+	for _, coding := range cc.Coding {
+		if coding.System == "" {
+			return false
+		}
+	}
+	return len(cc.Coding) > 0
 }
 
-func addPeriodParam(values url.Values, name string, period *models.Period) {
+func (b *searchBuilder) addDateParam(name string, date *models.FHIRDateTime) {
+	if !b.rule.uses(name) {
+		return
+	}
+	if b.rule.DateTolerance == 0 {
+		b.add(name, date.Time.Format("2006-01-02T15:04:05-07:00"))
+		return
+	}
+	tolerance := time.Duration(b.rule.DateTolerance)
+	b.add(name, "ge"+date.Time.Add(-tolerance).Format("2006-01-02T15:04:05-07:00"))
+	b.add(name, "le"+date.Time.Add(tolerance).Format("2006-01-02T15:04:05-07:00"))
+}
+
+func (b *searchBuilder) addPeriodParam(name string, period *models.Period) {
+	if !b.rule.uses(name) {
+		return
+	}
+	if b.rule.DateTolerance == 0 {
+		// Match the periods that include the start
+		b.add(name, "ge"+period.Start.Time.Format("2006-01-02T15:04:05-07:00"))
+		b.add(name, "le"+period.Start.Time.Format("2006-01-02T15:04:05-07:00"))
+		return
+	}
 	// Due to the way searching on periods is defined, the only way we can try to match on the start date is by using
 	// a combination of sa (starts after) and lt (less than) query parameters.
-	l := period.Start.Time.Add(-1 * time.Second)
-	h := period.Start.Time.Add(1 * time.Second)
-	values.Add(name, "sa"+l.Format("2006-01-02T15:04:05-07:00"))
-	values.Add(name, "lt"+h.Format("2006-01-02T15:04:05-07:00"))
+	l := period.Start.Time.Add(-time.Duration(b.rule.DateTolerance))
+	h := period.Start.Time.Add(time.Duration(b.rule.DateTolerance))
+	b.add(name, "sa"+l.Format("2006-01-02T15:04:05-07:00"))
+	b.add(name, "lt"+h.Format("2006-01-02T15:04:05-07:00"))
+}
+
+func (b *searchBuilder) addRefParam(name string, ref *models.Reference) {
+	if !b.rule.uses(name) {
+		return
+	}
+	b.add(name, ref.Reference)
+}
+
+func (b *searchBuilder) addIdentifierParam(name string, identifier *models.Identifier) {
+	if identifier == nil || !b.rule.uses(name) {
+		return
+	}
+	if b.rule.QualifiedIdentifiers && identifier.System != "" {
+		b.add(name, identifier.System+"|"+identifier.Value)
+	} else {
+		b.add(name, identifier.Value)
+	}
 }
 
-func addRefParam(values url.Values, name string, ref *models.Reference) {
-	values.Add(name, ref.Reference)
+// addEntryIdentifierParam adds the resource's entry identifier (see EntryIdentifierSystem), if it has one.  It is
+// always qualified with the system, since the value alone means nothing.
+func (b *searchBuilder) addEntryIdentifierParam(resource interface{}) {
+	if identifier := findEntryIdentifier(resource); identifier != nil {
+		b.add("identifier", identifier.System+"|"+identifier.Value)
+	}
 }
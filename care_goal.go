@@ -0,0 +1,137 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type CareGoal struct {
+	Entry
+}
+
+// goalTargetExtensionURL identifies the extension used to carry the coded goal, since DSTU2 Goal has no code
+const goalTargetExtensionURL = "http://hl7.org/fhir/StructureDefinition/goal-target"
+
+// goalDescriptionSystems lists the code systems whose codes are preferred for making up a goal's description, most
+// preferred first
+var goalDescriptionSystems = []string{"http://snomed.info/sct", "http://loinc.org"}
+
+func (g *CareGoal) FHIRModels() []interface{} {
+	fhirGoal := &fhir.Goal{}
+	fhirGoal.Id = g.GetTempID()
+	fhirGoal.Subject = g.Patient.FHIRReference()
+	target := g.Codes.FHIRCodeableConcept(g.Description)
+	fhirGoal.Description = g.Description
+	if coding := descriptionCoding(target); fhirGoal.Description == "" && coding != nil {
+		fhirGoal.Description = coding.System + "|" + coding.Code
+	}
+	if len(target.Coding) > 0 {
+		fhirGoal.Extension = []fhir.Extension{
+			{Url: goalTargetExtensionURL, ValueCodeableConcept: target},
+		}
+	}
+	if g.StartTime != nil {
+		fhirGoal.StartDate = g.StartTime.FHIRDate()
+	}
+	if g.EndTime != nil {
+		fhirGoal.TargetDate = g.EndTime.FHIRDate()
+	}
+	fhirGoal.Status = g.convertStatus()
+	if g.NegationReason != nil {
+		fhirGoal.StatusReason = g.NegationReason.FHIRCodeableConcept("")
+	}
+
+	return []interface{}{fhirGoal}
+}
+
+// FromFHIR sets the care goal from the FHIR goal.  It is the inverse of FHIRModels.
+func (g *CareGoal) FromFHIR(fhirGoal *fhir.Goal) {
+	g.SetTempID(fhirGoal.Id)
+	g.Description = fhirGoal.Description
+	for _, extension := range fhirGoal.Extension {
+		if target := extension.ValueCodeableConcept; extension.Url == goalTargetExtensionURL && target != nil {
+			g.Codes = CodeMapFromFHIR(target)
+			// FHIRModels makes up the description from one of the codes when HDS has none
+			if coding := descriptionCoding(target); coding != nil && g.Description == coding.System+"|"+coding.Code {
+				g.Description = ""
+			}
+		}
+	}
+	g.StartTime = UnixTimeFromFHIR(fhirGoal.StartDate)
+	g.EndTime = UnixTimeFromFHIR(fhirGoal.TargetDate)
+	g.StatusCode = goalStatusFromFHIR(fhirGoal.Status)
+	g.NegationReason = CodeObjectFromFHIR(fhirGoal.StatusReason)
+}
+
+// descriptionCoding picks the coding used to make up the goal's description when HDS has none: the first code in the
+// most preferred system (see goalDescriptionSystems), or else the first code.  It returns nil if there are no codes.
+func descriptionCoding(target *fhir.CodeableConcept) *fhir.Coding {
+	for _, system := range goalDescriptionSystems {
+		for i := range target.Coding {
+			if target.Coding[i].System == system {
+				return &target.Coding[i]
+			}
+		}
+	}
+	if len(target.Coding) > 0 {
+		return &target.Coding[0]
+	}
+	return nil
+}
+
+// convertStatus maps the status to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-goal-status.html
+// If the status cannot be reliably mapped, "in-progress" will be assumed.
+func (g *CareGoal) convertStatus() string {
+	var status string
+	statusConcept := g.StatusCode.FHIRCodeableConcept("")
+	switch {
+	case g.NegationInd:
+		status = "cancelled"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "active"):
+		status = "in-progress"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "completed"):
+		status = "achieved"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "aborted"):
+		status = "cancelled"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "cancelled"):
+		status = "cancelled"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "held"):
+		status = "on-hold"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "suspended"):
+		status = "on-hold"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "new"):
+		status = "planned"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "nullified"):
+		status = "cancelled"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "obsolete"):
+		status = "cancelled"
+	// NOTE: this is not a real ActStatus, but HDS seems to use it
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "ordered"):
+		status = "planned"
+	// NOTE: this is not a real ActStatus, but HDS seems to use it
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "recommended"):
+		status = "proposed"
+	default:
+		status = "in-progress"
+	}
+
+	return status
+}
+
+// goalStatusFromFHIR maps the FHIR goal status back to an ActStatus.  It is the inverse of convertStatus.  If the
+// status cannot be mapped, nil is returned.
+func goalStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "in-progress":
+		return actStatus("active")
+	case "achieved":
+		return actStatus("completed")
+	case "cancelled", "rejected":
+		return actStatus("cancelled")
+	case "on-hold":
+		return actStatus("held")
+	case "planned":
+		return actStatus("new")
+	case "proposed":
+		return actStatus("recommended")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type CareGoalSuite struct {
+	Patient   *Patient
+	CareGoals map[string]*CareGoal
+}
+
+var _ = Suite(&CareGoalSuite{})
+
+func (s *CareGoalSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/care_goals.json")
+	util.CheckErr(err)
+
+	s.CareGoals = make(map[string]*CareGoal)
+	err = json.Unmarshal(data, &s.CareGoals)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	for _, goal := range s.CareGoals {
+		goal.Patient = s.Patient
+	}
+}
+
+func (s *CareGoalSuite) TestCareGoal(c *C) {
+	models := s.CareGoals["ldlGoal"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Goal{})
+
+	goal := models[0].(*fhir.Goal)
+	c.Assert(goal.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(goal.Description, Equals, "Care Goal: Length of time since last LDL below target")
+	c.Assert(goal.Extension, HasLen, 1)
+	c.Assert(goal.Extension[0].Url, Equals, "http://hl7.org/fhir/StructureDefinition/goal-target")
+	c.Assert(goal.Extension[0].ValueCodeableConcept.MatchesCode("http://snomed.info/sct", "412726003"), Equals, true)
+	c.Assert(goal.StartDate, DeepEquals, NewUnixTime(1320149800).FHIRDate())
+	c.Assert(goal.TargetDate, DeepEquals, NewUnixTime(1362239100).FHIRDate())
+	c.Assert(goal.Status, Equals, "in-progress")
+	c.Assert(goal.StatusReason, IsNil)
+}
+
+func (s *CareGoalSuite) TestNegatedCareGoal(c *C) {
+	goal := s.CareGoals["cancelledGoal"].FHIRModels()[0].(*fhir.Goal)
+	c.Assert(goal.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(goal.Description, Equals, "Care Goal: Weight loss")
+	c.Assert(goal.StartDate, DeepEquals, NewUnixTime(1320149800).FHIRDate())
+	c.Assert(goal.TargetDate, IsNil)
+	c.Assert(goal.Status, Equals, "cancelled")
+	c.Assert(goal.StatusReason.MatchesCode("http://snomed.info/sct", "183932001"), Equals, true)
+}
+
+func (s *CareGoalSuite) TestDescriptionFromCodes(c *C) {
+	goal := &CareGoal{Entry: Entry{
+		Patient: s.Patient,
+		Codes:   CodeMap{"ICD-10-CM": []string{"Z71.3"}, "SNOMED-CT": []string{"289169006"}, "LOINC": []string{"29463-7"}},
+	}}
+	// The SNOMED code describes the goal, however the codes are ordered
+	for i := 0; i < 10; i++ {
+		fhirGoal := goal.FHIRModels()[0].(*fhir.Goal)
+		c.Assert(fhirGoal.Description, Equals, "http://snomed.info/sct|289169006")
+	}
+
+	// The made-up description isn't kept when converting back
+	restored := &CareGoal{}
+	restored.FromFHIR(goal.FHIRModels()[0].(*fhir.Goal))
+	c.Assert(restored.Description, Equals, "")
+	c.Assert(restored.Codes, DeepEquals, goal.Codes)
+}
@@ -0,0 +1,285 @@
+// Command hdsfhir converts patients exported from health-data-standards (HDS) to FHIR.
+//
+// Usage:
+//
+//	hdsfhir convert [flags] [file or directory ...]
+//
+// Each argument is an HDS patient JSON file or a directory, which is searched (recursively) for .json files.  If
+// there are no arguments, or an argument is "-", a patient is read from standard input.  By default, each patient is
+// written to standard output as a FHIR transaction bundle; with -out, each is written to its own file instead.
+//
+// Entries that cannot be converted are skipped and reported on standard error, and the command exits with a non-zero
+// status once all of the patients have been converted.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/hdsfhir"
+)
+
+// Exit statuses
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Values of the -type flag.  "resources" writes each resource on its own rather than in a bundle.
+var outputTypes = []string{"transaction", "collection", "resources"}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments (excluding the program name) and returns the exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "convert" {
+		fmt.Fprintln(stderr, "usage: hdsfhir convert [flags] [file or directory ...]")
+		fmt.Fprintln(stderr, "Run 'hdsfhir convert -h' for the flags.")
+		return exitUsage
+	}
+
+	c := &converter{stdin: stdin, stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("hdsfhir convert", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.BoolVar(&c.conditional, "conditional", false, "use conditional updates instead of creates (transaction bundles only)")
+	flags.StringVar(&c.matchRulesFile, "match-rules", "", "load the search criteria for conditional updates from this JSON or YAML `file`")
+	flags.StringVar(&c.outputType, "type", "transaction", "output type: "+strings.Join(outputTypes, ", "))
+	flags.StringVar(&c.outDir, "out", "", "write each patient to a file in this `directory` instead of standard output")
+	flags.BoolVar(&c.pretty, "pretty", false, "indent the JSON output")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hdsfhir convert [flags] [file or directory ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if err := c.checkFlags(); err != nil {
+		fmt.Fprintln(stderr, "hdsfhir:", err)
+		return exitUsage
+	}
+
+	inputs, err := findInputs(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, "hdsfhir:", err)
+		return exitError
+	}
+	for _, input := range inputs {
+		c.convert(input)
+	}
+
+	return c.summarize()
+}
+
+// converter converts each input and keeps track of the problems found along the way
+type converter struct {
+	conditional    bool
+	matchRulesFile string
+	outputType     string
+	outDir         string
+	pretty         bool
+	matchRules     hdsfhir.MatchRuleSet
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	// written holds the output files written so far, so that inputs with the same name don't overwrite each other
+	written map[string]bool
+	// converted, failed, and entryErrors count the patients converted, the inputs that couldn't be converted at all,
+	// and the entries that were skipped
+	converted, failed, entryErrors int
+}
+
+func (c *converter) checkFlags() error {
+	valid := false
+	for _, outputType := range outputTypes {
+		valid = valid || c.outputType == outputType
+	}
+	switch {
+	case c.outputType == "batch":
+		// The entries refer to each other by their urn:uuid full URLs, which servers only resolve within a transaction
+		return errors.New("-type batch isn't supported, since entries in a batch can't refer to each other (use transaction)")
+	case !valid:
+		return fmt.Errorf("unknown -type %q (must be one of %s)", c.outputType, strings.Join(outputTypes, ", "))
+	case c.conditional && c.outputType != "transaction":
+		return errors.New("-conditional only applies to transaction bundles")
+	case c.matchRulesFile != "" && !c.conditional:
+		return errors.New("-match-rules only applies with -conditional")
+	}
+	if c.matchRulesFile != "" {
+		var err error
+		if c.matchRules, err = hdsfhir.LoadMatchRulesFile(c.matchRulesFile); err != nil {
+			return fmt.Errorf("invalid -match-rules: %v", err)
+		}
+	}
+	return nil
+}
+
+// convert converts the patient in the input file ("-" for standard input) and writes the output
+func (c *converter) convert(input string) {
+	if err := c.convertPatient(input); err != nil {
+		fmt.Fprintf(c.stderr, "%s: %v\n", input, err)
+		c.failed++
+	}
+}
+
+func (c *converter) convertPatient(input string) error {
+	var data []byte
+	var err error
+	if input == "-" {
+		data, err = ioutil.ReadAll(c.stdin)
+	} else {
+		data, err = ioutil.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	patient := &hdsfhir.Patient{}
+	if err := json.Unmarshal(data, patient); err != nil {
+		return fmt.Errorf("not an HDS patient: %v", err)
+	}
+	result, err := patient.Convert(hdsfhir.ConversionOptions{})
+	if err != nil {
+		return err
+	}
+	for _, diagnostic := range result.Diagnostics {
+		if diagnostic.Severity == hdsfhir.SeverityError {
+			fmt.Fprintf(c.stderr, "%s: %s\n", input, diagnostic)
+			c.entryErrors++
+		}
+	}
+	c.converted++
+
+	opts := hdsfhir.BundleOptions{MatchRules: c.matchRules}
+	if c.conditional {
+		opts.Conditional = hdsfhir.ConditionalUpdate
+	}
+	bundle := result.FHIRTransactionBundleWithOptions(opts)
+	switch c.outputType {
+	case "collection":
+		bundle.Type = "collection"
+		for i := range bundle.Entry {
+			bundle.Entry[i].Request = nil
+		}
+	case "resources":
+		return c.writeResources(input, bundle)
+	}
+	return c.write(c.outputPath(input, ".json"), bundle)
+}
+
+// writeResources writes each resource in the bundle.  With an output directory, the resources are written to a
+// subdirectory named after the input, one file per resource.
+func (c *converter) writeResources(input string, bundle *fhir.Bundle) error {
+	dir := c.outputPath(input, "")
+	for _, entry := range bundle.Entry {
+		var path string
+		if dir != "" {
+			resourceType := reflect.TypeOf(entry.Resource).Elem().Name()
+			id := strings.TrimPrefix(entry.FullUrl, "urn:uuid:")
+			path = filepath.Join(dir, resourceType+"-"+id+".json")
+		}
+		if err := c.write(path, entry.Resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputPath returns the path of the output for the input, or "" if the output goes to standard output
+func (c *converter) outputPath(input, ext string) string {
+	if c.outDir == "" {
+		return ""
+	}
+	name := "stdin"
+	if input != "-" {
+		name = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	}
+	return filepath.Join(c.outDir, name+ext)
+}
+
+// write writes the value as JSON to the file, or to standard output if the path is empty
+func (c *converter) write(path string, v interface{}) error {
+	var data []byte
+	var err error
+	if c.pretty {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "" {
+		_, err = c.stdout.Write(data)
+		return err
+	}
+	if c.written[path] {
+		return fmt.Errorf("%s was already written for another input", path)
+	}
+	if c.written == nil {
+		c.written = make(map[string]bool)
+	}
+	c.written[path] = true
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// summarize reports the totals on standard error if anything went wrong, and returns the exit status
+func (c *converter) summarize() int {
+	if c.failed == 0 && c.entryErrors == 0 {
+		return exitOK
+	}
+	fmt.Fprintf(c.stderr, "hdsfhir: converted %d patient(s); %d input(s) could not be converted and %d entries were skipped\n",
+		c.converted, c.failed, c.entryErrors)
+	return exitError
+}
+
+// findInputs expands the arguments into the input files, searching directories for .json files.  No arguments means
+// standard input.
+func findInputs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return []string{"-"}, nil
+	}
+
+	var inputs []string
+	for _, arg := range args {
+		if arg == "-" {
+			inputs = append(inputs, arg)
+			continue
+		}
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".json") {
+				inputs = append(inputs, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MainSuite struct {
+	Dir            string
+	Stdout, Stderr *bytes.Buffer
+}
+
+var _ = Suite(&MainSuite{})
+
+const fixture = "../../fixtures/john_peters.json"
+
+func (s *MainSuite) SetUpTest(c *C) {
+	s.Dir = c.MkDir()
+	s.Stdout = &bytes.Buffer{}
+	s.Stderr = &bytes.Buffer{}
+}
+
+func (s *MainSuite) run(stdin string, args ...string) int {
+	return run(args, strings.NewReader(stdin), s.Stdout, s.Stderr)
+}
+
+func (s *MainSuite) readBundle(c *C, data []byte) *fhir.Bundle {
+	bundle := &fhir.Bundle{}
+	c.Assert(json.Unmarshal(data, bundle), IsNil)
+	return bundle
+}
+
+func (s *MainSuite) TestConvertFile(c *C) {
+	c.Assert(s.run("", "convert", fixture), Equals, exitOK)
+	c.Assert(s.Stderr.String(), Equals, "")
+
+	bundle := s.readBundle(c, s.Stdout.Bytes())
+	c.Assert(bundle.Type, Equals, "transaction")
+	c.Assert(bundle.Entry, HasLen, 23)
+	c.Assert(bundle.Entry[0].Request.Method, Equals, "POST")
+}
+
+func (s *MainSuite) TestConvertStdin(c *C) {
+	data, err := ioutil.ReadFile(fixture)
+	util.CheckErr(err)
+
+	c.Assert(s.run(string(data), "convert", "-conditional", "-pretty"), Equals, exitOK)
+	c.Assert(s.Stdout.String(), Matches, "(?s)\\{\n  \"resourceType\": \"Bundle\".*")
+
+	bundle := s.readBundle(c, s.Stdout.Bytes())
+	c.Assert(bundle.Entry[0].Request.Method, Equals, "PUT")
+}
+
+func (s *MainSuite) TestConvertMatchRules(c *C) {
+	rules := filepath.Join(s.Dir, "rules.json")
+	util.CheckErr(ioutil.WriteFile(rules, []byte(`{"Patient": {"params": ["family", "given"]}}`), 0644))
+
+	c.Assert(s.run("", "convert", "-conditional", "-match-rules", rules, fixture), Equals, exitOK)
+	bundle := s.readBundle(c, s.Stdout.Bytes())
+	c.Assert(bundle.Entry[0].Request.Url, Equals, "Patient?family=Peters&given=John")
+}
+
+func (s *MainSuite) TestConvertCollection(c *C) {
+	c.Assert(s.run("", "convert", "-type", "collection", fixture), Equals, exitOK)
+
+	bundle := s.readBundle(c, s.Stdout.Bytes())
+	c.Assert(bundle.Type, Equals, "collection")
+	for _, entry := range bundle.Entry {
+		c.Assert(entry.Request, IsNil)
+	}
+}
+
+func (s *MainSuite) TestConvertDirectory(c *C) {
+	c.Assert(s.run("", "convert", "-out", s.Dir, "../../fixtures"), Equals, exitOK)
+
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, "john_peters.json"))
+	c.Assert(err, IsNil)
+	c.Assert(s.readBundle(c, data).Entry, HasLen, 23)
+	_, err = os.Stat(filepath.Join(s.Dir, "providers.json"))
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout.Len(), Equals, 0)
+}
+
+func (s *MainSuite) TestConvertResources(c *C) {
+	c.Assert(s.run("", "convert", "-type", "resources", "-out", s.Dir, fixture), Equals, exitOK)
+
+	files, err := filepath.Glob(filepath.Join(s.Dir, "john_peters", "*.json"))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 23)
+	patients, err := filepath.Glob(filepath.Join(s.Dir, "john_peters", "Patient-*.json"))
+	c.Assert(err, IsNil)
+	c.Assert(patients, HasLen, 1)
+
+	data, err := ioutil.ReadFile(patients[0])
+	util.CheckErr(err)
+	patient := &fhir.Patient{}
+	c.Assert(json.Unmarshal(data, patient), IsNil)
+	c.Assert(filepath.Base(patients[0]), Equals, "Patient-"+patient.Id+".json")
+}
+
+func (s *MainSuite) TestBadInput(c *C) {
+	bad := filepath.Join(s.Dir, "bad.json")
+	util.CheckErr(ioutil.WriteFile(bad, []byte("[1, 2, 3]"), 0644))
+
+	c.Assert(s.run("", "convert", bad, fixture), Equals, exitError)
+	c.Assert(s.Stderr.String(), Matches, "(?s).*bad.json: not an HDS patient.*")
+	c.Assert(s.Stderr.String(), Matches, "(?s).*converted 1 patient\\(s\\); 1 input\\(s\\) could not be converted.*")
+
+	// The other patient is still converted
+	c.Assert(s.readBundle(c, s.Stdout.Bytes()).Entry, HasLen, 23)
+}
+
+func (s *MainSuite) TestUsage(c *C) {
+	c.Assert(s.run(""), Equals, exitUsage)
+	c.Assert(s.run("", "upload"), Equals, exitUsage)
+	c.Assert(s.run("", "convert", "-type", "document", fixture), Equals, exitUsage)
+	c.Assert(s.run("", "convert", "-type", "collection", "-conditional", fixture), Equals, exitUsage)
+	c.Assert(s.run("", "convert", "-match-rules", fixture, fixture), Equals, exitUsage)
+	c.Assert(s.run("", "convert", "-conditional", "-match-rules", filepath.Join(s.Dir, "missing.json"), fixture), Equals, exitUsage)
+	c.Assert(s.Stdout.Len(), Equals, 0)
+}
+
+func (s *MainSuite) TestBatchRejected(c *C) {
+	// A batch can't resolve the urn:uuid references between its entries, conditional or not
+	c.Assert(s.run("", "convert", "-type", "batch", fixture), Equals, exitUsage)
+	c.Assert(s.run("", "convert", "-type", "batch", "-conditional", fixture), Equals, exitUsage)
+	c.Assert(s.Stderr.String(), Matches, "(?s).*-type batch isn't supported.*")
+	c.Assert(s.Stdout.Len(), Equals, 0)
+}
+
+func (s *MainSuite) TestMissingFile(c *C) {
+	c.Assert(s.run("", "convert", filepath.Join(s.Dir, "missing.json")), Equals, exitError)
+	c.Assert(s.Stdout.Len(), Equals, 0)
+}
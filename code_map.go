@@ -1,15 +1,26 @@
 package hdsfhir
 
-import fhir "github.com/intervention-engine/fhir/models"
+import (
+	"sort"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
 
 type CodeMap map[string][]string
 
+// FHIRCodeableConcept converts the codes to a codeable concept.  The codings are ordered by code system name (and then
+// in the order of the codes), so that the first coding is the same every time.
 func (c *CodeMap) FHIRCodeableConcept(text string) *fhir.CodeableConcept {
 	concept := &fhir.CodeableConcept{}
 	codings := make([]fhir.Coding, 0)
-	for codeSystem, codes := range *c {
+	codeSystems := make([]string, 0, len(*c))
+	for codeSystem := range *c {
+		codeSystems = append(codeSystems, codeSystem)
+	}
+	sort.Strings(codeSystems)
+	for _, codeSystem := range codeSystems {
 		codeSystemURL := CodeSystemMap[codeSystem]
-		for _, code := range codes {
+		for _, code := range (*c)[codeSystem] {
 			coding := fhir.Coding{System: codeSystemURL, Code: code}
 			codings = append(codings, coding)
 		}
@@ -69,3 +80,59 @@ var CodeSystemMap = map[string]string{
 	"CDT":               "urn:oid:2.16.840.1.113883.6.13",
 	"AdministrativeSex": "urn:oid:2.16.840.1.113883.18.2",
 }
+
+// CodeSystemNameMap maps FHIR code system URLs back to HDS code system names.  It is the inverse of CodeSystemMap,
+// except that where several HDS names share a URL, the name HDS uses most often is preferred.
+var CodeSystemNameMap = invertCodeSystemMap(map[string]string{
+	"http://hl7.org/fhir/sid/icd-9":      "ICD-9-CM",
+	"http://hl7.org/fhir/sid/icd-10":     "ICD-10-CM",
+	"urn:oid:2.16.840.1.113883.6.259":    "HSLOC",
+	"urn:oid:2.16.840.1.113883.3.26.1.1": "NCI Thesaurus",
+	"urn:oid:2.16.840.1.113883.3.221.5":  "SOP",
+})
+
+func invertCodeSystemMap(preferred map[string]string) map[string]string {
+	names := make(map[string]string, len(CodeSystemMap))
+	for name, url := range CodeSystemMap {
+		names[url] = name
+	}
+	for url, name := range preferred {
+		names[url] = name
+	}
+	return names
+}
+
+// codeSystemName returns the HDS name for the FHIR code system URL.  If HDS has no name for it, the URL itself is
+// used, so that the code isn't lost.
+func codeSystemName(system string) string {
+	if name, ok := CodeSystemNameMap[system]; ok {
+		return name
+	}
+	return system
+}
+
+// CodeMapFromFHIR returns the codings in the concept as a CodeMap.  It is the inverse of CodeMap.FHIRCodeableConcept.
+func CodeMapFromFHIR(concept *fhir.CodeableConcept) CodeMap {
+	if concept == nil || len(concept.Coding) == 0 {
+		return nil
+	}
+	codes := make(CodeMap)
+	for _, coding := range concept.Coding {
+		codeSystem := codeSystemName(coding.System)
+		codes[codeSystem] = append(codes[codeSystem], coding.Code)
+	}
+	return codes
+}
+
+// CodeObjectFromFHIR returns the first coding in the concept as a CodeObject, or nil if there are no codings.
+func CodeObjectFromFHIR(concept *fhir.CodeableConcept) *CodeObject {
+	if concept == nil || len(concept.Coding) == 0 {
+		return nil
+	}
+	return &CodeObject{Code: concept.Coding[0].Code, CodeSystem: codeSystemName(concept.Coding[0].System)}
+}
+
+// actStatus returns a status code using the HL7 ActStatus code system, as HDS records most entry statuses
+func actStatus(code string) CodeMap {
+	return CodeMap{"HL7 ActStatus": []string{code}}
+}
@@ -1,6 +1,9 @@
 package hdsfhir
 
-import . "gopkg.in/check.v1"
+import (
+	fhir "github.com/intervention-engine/fhir/models"
+	. "gopkg.in/check.v1"
+)
 
 type CodeMapSuite struct {
 }
@@ -20,6 +23,24 @@ func (s *CodeMapSuite) TestCodeMapToCodeableConcept(c *C) {
 	c.Assert(concept.MatchesCode("http://www.ama-assn.org/go/cpt", "abcd"), Equals, true)
 }
 
+func (s *CodeMapSuite) TestCodeMapOrder(c *C) {
+	codeMap := CodeMap{
+		"SNOMED-CT": []string{"5678", "1234"},
+		"ICD-10-CM": []string{"I50.1"},
+		"CPT":       []string{"abcd"}}
+
+	// The codings don't depend on the map's (random) iteration order
+	for i := 0; i < 10; i++ {
+		concept := codeMap.FHIRCodeableConcept("")
+		c.Assert(concept.Coding, DeepEquals, []fhir.Coding{
+			{System: "http://www.ama-assn.org/go/cpt", Code: "abcd"},
+			{System: "http://hl7.org/fhir/sid/icd-10", Code: "I50.1"},
+			{System: "http://snomed.info/sct", Code: "5678"},
+			{System: "http://snomed.info/sct", Code: "1234"},
+		})
+	}
+}
+
 func (s *CodeMapSuite) TestCodeObjectToCodeableConcept(c *C) {
 	codeObj := CodeObject{CodeSystem: "SNOMED-CT", Code: "1234"}
 
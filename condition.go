@@ -6,9 +6,15 @@ type Condition struct {
 	Entry
 	// NOTE: HDS has inconsistent representations of severity, but the only working importer (cat1)
 	// models it like a CodeMap -- so that's what we assume.  Note the difference from Allergy.
-	Severity CodeMap `json:"severity"`
+	Severity     CodeMap   `json:"severity"`
+	CauseOfDeath bool      `json:"causeOfDeath"`
+	TimeOfDeath  *UnixTime `json:"time_of_death"`
 }
 
+// causeOfDeathExtensionURL identifies the extension used to flag the condition that caused the patient's death,
+// since DSTU2 Condition has no element or category for it
+const causeOfDeathExtensionURL = "http://github.com/intervention-engine/hdsfhir/StructureDefinition/cause-of-death"
+
 func (c *Condition) FHIRModels() []interface{} {
 	fhirCondition := &fhir.Condition{}
 	fhirCondition.Id = c.GetTempID()
@@ -28,10 +34,32 @@ func (c *Condition) FHIRModels() []interface{} {
 	if c.EndTime != nil {
 		fhirCondition.AbatementDateTime = c.EndTime.FHIRDateTime()
 	}
+	if c.CauseOfDeath {
+		t := true
+		fhirCondition.Extension = []fhir.Extension{
+			{Url: causeOfDeathExtensionURL, ValueBoolean: &t},
+		}
+	}
 
 	return []interface{}{fhirCondition}
 }
 
+// FromFHIR sets the condition from the FHIR condition.  It is the inverse of FHIRModels.
+func (c *Condition) FromFHIR(fhirCondition *fhir.Condition) {
+	c.SetTempID(fhirCondition.Id)
+	c.setFHIRCodes(fhirCondition.Code)
+	c.StatusCode = clinicalStatusFromFHIR(fhirCondition.ClinicalStatus)
+	c.NegationInd = fhirCondition.VerificationStatus == "refuted"
+	c.Severity = CodeMapFromFHIR(fhirCondition.Severity)
+	c.StartTime = UnixTimeFromFHIR(fhirCondition.OnsetDateTime)
+	c.EndTime = UnixTimeFromFHIR(fhirCondition.AbatementDateTime)
+	for _, extension := range fhirCondition.Extension {
+		if extension.Url == causeOfDeathExtensionURL && extension.ValueBoolean != nil {
+			c.CauseOfDeath = *extension.ValueBoolean
+		}
+	}
+}
+
 // convertClinicalStatus maps the clinical status to a code in the "preferred" FHIR value set:
 //   http://hl7.org/fhir/DSTU2/valueset-condition-clinical.html
 // If the status cannot be reliably mapped, an empty code will be returned.
@@ -58,6 +86,20 @@ func (c *Condition) convertClinicalStatus() string {
 	return status
 }
 
+// clinicalStatusFromFHIR maps the FHIR clinical status back to the SNOMED-CT code HDS uses.  It is the inverse of
+// convertClinicalStatus.  If the status cannot be mapped, nil is returned.
+func clinicalStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "active", "relapse":
+		return CodeMap{"SNOMED-CT": []string{"55561003"}}
+	case "remission":
+		return CodeMap{"SNOMED-CT": []string{"73425007"}}
+	case "resolved":
+		return CodeMap{"SNOMED-CT": []string{"413322009"}}
+	}
+	return nil
+}
+
 // convertSeverity maps the severity to a CodeableConcept. If possible, it will add a display name.
 // FHIR has a "preferred" value set for severity:
 //   http://hl7.org/fhir/DSTU2/valueset-condition-severity.html
@@ -44,6 +44,7 @@ func (s *ConditionSuite) TestActiveCondition(c *C) {
 	c.Assert(condition.Severity.Text, Equals, "Severe")
 	c.Assert(condition.OnsetDateTime, DeepEquals, NewUnixTime(1330603200).FHIRDateTime())
 	c.Assert(condition.AbatementDateTime, IsNil)
+	c.Assert(condition.Extension, IsNil)
 }
 
 func (s *ConditionSuite) TestInactiveCondition(c *C) {
@@ -115,3 +116,15 @@ func (s *ConditionSuite) TestNegatedCondition(c *C) {
 	c.Assert(condition.OnsetDateTime, IsNil)
 	c.Assert(condition.AbatementDateTime, IsNil)
 }
+
+func (s *ConditionSuite) TestCauseOfDeathCondition(c *C) {
+	condition := s.Conditions["causeOfDeath"].FHIRModels()[0].(*fhir.Condition)
+	c.Assert(condition.Patient, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(condition.Code.MatchesCode("http://snomed.info/sct", "22298006"), Equals, true)
+	c.Assert(condition.ClinicalStatus, Equals, "active")
+	c.Assert(condition.VerificationStatus, Equals, "confirmed")
+	c.Assert(condition.OnsetDateTime, DeepEquals, NewUnixTime(1362239100).FHIRDateTime())
+	c.Assert(condition.Extension, HasLen, 1)
+	c.Assert(condition.Extension[0].Url, Equals, "http://github.com/intervention-engine/hdsfhir/StructureDefinition/cause-of-death")
+	c.Assert(*condition.Extension[0].ValueBoolean, Equals, true)
+}
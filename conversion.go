@@ -0,0 +1,322 @@
+package hdsfhir
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+// ConversionOptions configures how a patient is converted to FHIR
+type ConversionOptions struct {
+	// FailOnError causes Convert to return an error if any entry could not be converted.  Otherwise, entries that
+	// cannot be converted are skipped and reported in the result's diagnostics.
+	FailOnError bool
+	// IDStrategy, if set, is used to assign the IDs of all converted resources before converting them (see
+	// Patient.AssignIDs).  Otherwise, any IDs already assigned are kept and the rest are generated randomly.
+	IDStrategy IDStrategy
+	// IDRegistry, if set, is used to find the server resources already created for the patient's entries.  The
+	// transaction bundle updates those resources directly (with a PUT to their IDs) rather than creating them.
+	IDRegistry IDRegistry
+}
+
+// Severity indicates how serious a conversion diagnostic is, using the codes in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-issue-severity.html
+type Severity string
+
+const (
+	SeverityError       Severity = "error"
+	SeverityWarning     Severity = "warning"
+	SeverityInformation Severity = "information"
+)
+
+// Diagnostic describes a problem found while converting an HDS entry
+type Diagnostic struct {
+	Severity Severity
+	// Type is a code in the required FHIR value set: http://hl7.org/fhir/DSTU2/valueset-issue-type.html
+	Type string
+	// Section is the JSON name of the patient section containing the entry (e.g., "vital_signs")
+	Section string
+	// Index is the position of the entry in its section
+	Index int
+	// ID is the HDS ID of the entry, if known
+	ID     ObjectID
+	Reason string
+}
+
+// Location returns the path to the entry in the HDS patient document (e.g., "vital_signs[2]")
+func (d Diagnostic) Location() string {
+	if d.Section == "" {
+		return ""
+	}
+	return d.Section + "[" + strconv.Itoa(d.Index) + "]"
+}
+
+func (d Diagnostic) String() string {
+	s := string(d.Severity)
+	if location := d.Location(); location != "" {
+		s += " at " + location
+	}
+	if d.ID != "" {
+		s += " (" + string(d.ID) + ")"
+	}
+	return s + ": " + d.Reason
+}
+
+// ConversionResult holds the FHIR models converted from an HDS patient along with any problems encountered
+type ConversionResult struct {
+	Models      []interface{}
+	Diagnostics []Diagnostic
+
+	// registered holds the server locations of the models found in the ID registry, keyed by their full URLs
+	registered map[string]ResourceLocation
+}
+
+// HasErrors indicates if any entries could not be converted
+func (r *ConversionResult) HasErrors() bool {
+	for _, diagnostic := range r.Diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// FHIRTransactionBundle returns a FHIR bundle representing a transaction to post all converted models to a server.
+// Models found in the ID registry (if any) are updated directly; conditionalUpdate only applies to the others.
+func (r *ConversionResult) FHIRTransactionBundle(conditionalUpdate bool) *fhir.Bundle {
+	return transactionBundle(r.Models, conditionalUpdateOptions(conditionalUpdate), r.registered)
+}
+
+// FHIRTransactionBundleWithOptions is like FHIRTransactionBundle, but uses the conditional strategies selected by the
+// options
+func (r *ConversionResult) FHIRTransactionBundleWithOptions(opts BundleOptions) *fhir.Bundle {
+	return transactionBundle(r.Models, opts, r.registered)
+}
+
+// OperationOutcome returns the diagnostics as a FHIR operation outcome.  If there are no diagnostics, the outcome
+// contains a single informational issue, since FHIR requires at least one.
+func (r *ConversionResult) OperationOutcome() *fhir.OperationOutcome {
+	outcome := &fhir.OperationOutcome{}
+	for _, diagnostic := range r.Diagnostics {
+		issue := fhir.OperationOutcomeIssueComponent{
+			Severity:    string(diagnostic.Severity),
+			Code:        diagnostic.Type,
+			Diagnostics: diagnostic.Reason,
+		}
+		if diagnostic.ID != "" {
+			issue.Details = &fhir.CodeableConcept{Text: "HDS entry " + string(diagnostic.ID)}
+		}
+		if location := diagnostic.Location(); location != "" {
+			issue.Location = []string{location}
+		}
+		outcome.Issue = append(outcome.Issue, issue)
+	}
+	if len(outcome.Issue) == 0 {
+		outcome.Issue = []fhir.OperationOutcomeIssueComponent{
+			{Severity: string(SeverityInformation), Code: "informational", Diagnostics: "All entries were converted"},
+		}
+	}
+	return outcome
+}
+
+// Convert converts the patient and all of its entries to FHIR models.  Unlike FHIRModels, an entry that cannot be
+// converted doesn't stop the conversion; it is skipped and reported in the result's diagnostics, along with
+// warnings about data that could only be partially converted.  Every model but the patient gets an identifier in the
+// EntryIdentifierSystem that is derived from its HDS entry, so it can be matched when the patient is converted
+// again.  An error is returned if there is no patient, or if FailOnError is set and any entry could not be converted.
+func (p *Patient) Convert(opts ConversionOptions) (*ConversionResult, error) {
+	c := &converter{result: &ConversionResult{}}
+	if p == nil {
+		return c.result, errors.New("no patient to convert")
+	}
+	p.linkProviders()
+	p.linkFacilities()
+	p.linkPayers()
+	if opts.IDStrategy != nil {
+		p.AssignIDs(opts.IDStrategy)
+	}
+	if opts.IDRegistry != nil {
+		c.result.registered = p.registeredLocations(opts.IDRegistry)
+	}
+	c.result.Models = append(c.result.Models, p.FHIRModel())
+
+	for i, provider := range p.Providers() {
+		c.convert("providers", i, provider.ID, func() ([]interface{}, error) { return provider.FHIRModels(), nil })
+	}
+	for i, encounter := range p.Encounters {
+		c.checkEntry("encounters", i, &encounter.Entry, nil)
+		c.convert("encounters", i, encounter.ID, entryConverter(&encounter.Entry, nil, encounter.FHIRModels))
+	}
+	for i, condition := range p.Conditions {
+		c.checkEntry("conditions", i, &condition.Entry, nil)
+		c.convert("conditions", i, condition.ID, entryConverter(&condition.Entry, nil, condition.FHIRModels))
+	}
+	vitalSignIndexes := make(map[*VitalSign]int)
+	for i, vitalSign := range p.VitalSigns {
+		vitalSignIndexes[vitalSign] = i
+		c.checkEntry("vital_signs", i, &vitalSign.Entry, vitalSign.Values)
+	}
+	for _, panel := range GroupVitalSigns(p.VitalSigns) {
+		panel := panel
+		c.convert("vital_signs", vitalSignIndexes[panel[0]], panel[0].ID, func() ([]interface{}, error) {
+			for _, vitalSign := range panel {
+				if err := vitalSign.checkConvertible(vitalSign.Values); err != nil {
+					return nil, err
+				}
+			}
+			return panel.FHIRModels(), nil
+		})
+	}
+	for i, procedure := range p.Procedures {
+		c.checkEntry("procedures", i, &procedure.Entry, procedure.Values)
+		c.convert("procedures", i, procedure.ID, entryConverter(&procedure.Entry, procedure.Values, procedure.FHIRModels))
+	}
+	for i, medication := range p.Medications {
+		c.checkEntry("medications", i, &medication.Entry, nil)
+		medication := medication
+		c.convert("medications", i, medication.ID, func() ([]interface{}, error) {
+			if err := medication.checkScalars(); err != nil {
+				return nil, err
+			}
+			return entryConverter(&medication.Entry, nil, medication.FHIRModels)()
+		})
+	}
+	for i, immunization := range p.Immunizations {
+		c.checkEntry("immunizations", i, &immunization.Entry, nil)
+		c.convert("immunizations", i, immunization.ID, entryConverter(&immunization.Entry, nil, immunization.FHIRModels))
+	}
+	for i, allergy := range p.Allergies {
+		c.checkEntry("allergies", i, &allergy.Entry, nil)
+		c.convert("allergies", i, allergy.ID, entryConverter(&allergy.Entry, nil, allergy.FHIRModels))
+	}
+	resultIndexes := make(map[*LabResult]int)
+	for i, result := range p.Results {
+		resultIndexes[result] = i
+		c.checkEntry("results", i, &result.Entry, result.Values)
+	}
+	for _, panel := range GroupLabResults(p.Results) {
+		panel := panel
+		c.convert("results", resultIndexes[panel[0]], panel[0].ID, func() ([]interface{}, error) {
+			for _, result := range panel {
+				if err := result.checkConvertible(result.Values); err != nil {
+					return nil, err
+				}
+			}
+			return panel.FHIRModels(), nil
+		})
+	}
+	for i, socialHistory := range p.SocialHistory {
+		c.checkEntry("social_history", i, &socialHistory.Entry, socialHistory.Values)
+		c.convert("social_history", i, socialHistory.ID, entryConverter(&socialHistory.Entry, socialHistory.Values, socialHistory.FHIRModels))
+	}
+	for i, goal := range p.CareGoals {
+		c.checkEntry("care_goals", i, &goal.Entry, nil)
+		c.convert("care_goals", i, goal.ID, entryConverter(&goal.Entry, nil, goal.FHIRModels))
+	}
+	for i, equipment := range p.MedicalEquipment {
+		c.checkEntry("medical_equipment", i, &equipment.Entry, nil)
+		if equipment.NegationInd {
+			c.report(SeverityWarning, "not-supported", "medical_equipment", i, equipment.ID,
+				"negated equipment can't be converted, since FHIR can't state that a device wasn't used")
+			continue
+		}
+		c.convert("medical_equipment", i, equipment.ID, entryConverter(&equipment.Entry, nil, equipment.FHIRModels))
+	}
+	// Insurance providers and support entries are identified by names rather than codes, so they aren't checked
+	for i, insuranceProvider := range p.InsuranceProviders {
+		c.convert("insurance_providers", i, insuranceProvider.ID, entryConverter(&insuranceProvider.Entry, nil, insuranceProvider.FHIRModels))
+	}
+	for i, directive := range p.AdvanceDirectives {
+		c.checkEntry("advance_directives", i, &directive.Entry, nil)
+		if directive.FreeText != "" && !directive.NegationInd && directive.indexedTime() == nil {
+			c.report(SeverityWarning, "incomplete", "advance_directives", i, directive.ID,
+				"directive has no times, so its document reference has no indexed time")
+		}
+		c.convert("advance_directives", i, directive.ID, entryConverter(&directive.Entry, nil, directive.FHIRModels))
+	}
+	for i, functionalStatus := range p.FunctionalStatuses {
+		c.checkEntry("functional_statuses", i, &functionalStatus.Entry, functionalStatus.Values)
+		c.convert("functional_statuses", i, functionalStatus.ID, entryConverter(&functionalStatus.Entry, functionalStatus.Values, functionalStatus.FHIRModels))
+	}
+	for i, support := range p.Support {
+		c.convert("support", i, support.ID, entryConverter(&support.Entry, nil, support.FHIRModels))
+	}
+	p.addEntryIdentifiers(c.result.Models)
+
+	if opts.FailOnError && c.result.HasErrors() {
+		return c.result, fmt.Errorf("%d diagnostics, including errors, were reported converting the patient", len(c.result.Diagnostics))
+	}
+	return c.result, nil
+}
+
+// converter accumulates the models and diagnostics for a conversion
+type converter struct {
+	result *ConversionResult
+}
+
+// convert runs the conversion function and adds its models to the result.  If the conversion returns an error, it is
+// reported instead.
+func (c *converter) convert(section string, index int, id ObjectID, fn func() ([]interface{}, error)) {
+	models, err := fn()
+	if err != nil {
+		c.report(SeverityError, "invalid", section, index, id, err.Error())
+		return
+	}
+	c.result.Models = append(c.result.Models, models...)
+}
+
+// entryConverter returns a conversion function that converts the entry with fn, unless the entry can't be converted
+func entryConverter(e *Entry, values []ResultValue, fn func() []interface{}) func() ([]interface{}, error) {
+	return func() ([]interface{}, error) {
+		if err := e.checkConvertible(values); err != nil {
+			return nil, err
+		}
+		return fn(), nil
+	}
+}
+
+// checkConvertible returns an error if the entry (with its values, if it has any) is missing something it needs to
+// be converted
+func (e *Entry) checkConvertible(values []ResultValue) error {
+	if e.Patient == nil {
+		return errors.New("entry does not belong to a patient")
+	}
+	for i := range values {
+		if err := values[i].err; err != nil {
+			return fmt.Errorf("value %d is invalid: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// checkEntry reports warnings about data in the entry that can't be converted faithfully
+func (c *converter) checkEntry(section string, index int, e *Entry, values []ResultValue) {
+	if len(e.Codes) == 0 {
+		c.report(SeverityWarning, "incomplete", section, index, e.ID, "entry has no codes")
+	}
+	for i := range values {
+		value := &values[i]
+		switch {
+		case value.err != nil:
+			// The entry is reported when it is converted
+		case value.Physical == nil && value.Coded == nil:
+			c.report(SeverityWarning, "incomplete", section, index, e.ID, fmt.Sprintf("value %d is empty", i))
+		case value.Physical != nil && value.Physical.FHIRQuantity() == nil:
+			c.report(SeverityWarning, "value", section, index, e.ID,
+				fmt.Sprintf("value %d (%q) is not numeric and was converted to a string", i, value.Physical.Scalar))
+		}
+	}
+}
+
+func (c *converter) report(severity Severity, issueType string, section string, index int, id ObjectID, reason string) {
+	c.result.Diagnostics = append(c.result.Diagnostics, Diagnostic{
+		Severity: severity,
+		Type:     issueType,
+		Section:  section,
+		Index:    index,
+		ID:       id,
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,182 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type ConversionSuite struct {
+	Patient *Patient
+}
+
+var _ = Suite(&ConversionSuite{})
+
+func (s *ConversionSuite) SetUpTest(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	err = json.Unmarshal(data, s.Patient)
+	util.CheckErr(err)
+}
+
+func (s *ConversionSuite) TestConvertCleanPatient(c *C) {
+	result, err := s.Patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Diagnostics, HasLen, 0)
+	c.Assert(result.HasErrors(), Equals, false)
+	c.Assert(result.Models, HasLen, len(s.Patient.FHIRModels()))
+
+	outcome := result.OperationOutcome()
+	c.Assert(outcome.Issue, HasLen, 1)
+	c.Assert(outcome.Issue[0].Severity, Equals, "information")
+	c.Assert(outcome.Issue[0].Code, Equals, "informational")
+}
+
+func (s *ConversionSuite) TestConvertWithBadEntries(c *C) {
+	// A condition without a patient can't be converted, and has no codes either
+	s.Patient.Conditions = append(s.Patient.Conditions, &Condition{Entry: Entry{ID: "5697d8b2c1c1b1a2b3000020"}})
+	broken := len(s.Patient.Conditions) - 1
+	s.Patient.VitalSigns[0].Values = append(s.Patient.VitalSigns[0].Values, ResultValue{Physical: &PhysicalQuantityResult{Scalar: "high"}})
+
+	result, err := s.Patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.HasErrors(), Equals, true)
+	c.Assert(result.Diagnostics, HasLen, 3)
+
+	c.Assert(result.Diagnostics[0].Severity, Equals, SeverityWarning)
+	c.Assert(result.Diagnostics[0].Type, Equals, "incomplete")
+	c.Assert(result.Diagnostics[0].Section, Equals, "conditions")
+	c.Assert(result.Diagnostics[0].Index, Equals, broken)
+	c.Assert(result.Diagnostics[0].ID, Equals, ObjectID("5697d8b2c1c1b1a2b3000020"))
+	c.Assert(result.Diagnostics[0].Reason, Equals, "entry has no codes")
+
+	c.Assert(result.Diagnostics[1].Severity, Equals, SeverityError)
+	c.Assert(result.Diagnostics[1].Type, Equals, "invalid")
+	c.Assert(result.Diagnostics[1].Location(), Equals, "conditions[5]")
+	c.Assert(result.Diagnostics[1].ID, Equals, ObjectID("5697d8b2c1c1b1a2b3000020"))
+	c.Assert(result.Diagnostics[1].Reason, Equals, "entry does not belong to a patient")
+
+	c.Assert(result.Diagnostics[2].Severity, Equals, SeverityWarning)
+	c.Assert(result.Diagnostics[2].Type, Equals, "value")
+	c.Assert(result.Diagnostics[2].Location(), Equals, "vital_signs[0]")
+	c.Assert(result.Diagnostics[2].Reason, Equals, `value 1 ("high") is not numeric and was converted to a string`)
+
+	// Everything except the broken condition is still converted, and the vital sign now has two member observations
+	c.Assert(result.Models, HasLen, 25)
+	for _, model := range result.Models {
+		if condition, ok := model.(*fhir.Condition); ok {
+			c.Assert(condition.Id, Not(Equals), s.Patient.Conditions[broken].GetTempID())
+		}
+	}
+
+	outcome := result.OperationOutcome()
+	c.Assert(outcome.Issue, HasLen, 3)
+	c.Assert(outcome.Issue[1].Severity, Equals, "error")
+	c.Assert(outcome.Issue[1].Code, Equals, "invalid")
+	c.Assert(outcome.Issue[1].Location, DeepEquals, []string{"conditions[5]"})
+	c.Assert(outcome.Issue[1].Details.Text, Equals, "HDS entry 5697d8b2c1c1b1a2b3000020")
+	c.Assert(outcome.Issue[1].Diagnostics, Equals, result.Diagnostics[1].Reason)
+
+	c.Assert(s.Patient.FHIRModels(), HasLen, 25)
+}
+
+func (s *ConversionSuite) TestConvertFailOnError(c *C) {
+	s.Patient.Conditions = append(s.Patient.Conditions, &Condition{Entry: Entry{Codes: CodeMap{"SNOMED-CT": []string{"10091002"}}}})
+
+	result, err := s.Patient.Convert(ConversionOptions{FailOnError: true})
+	c.Assert(err, NotNil)
+	c.Assert(result.Diagnostics, HasLen, 1)
+	c.Assert(result.HasErrors(), Equals, true)
+}
+
+func (s *ConversionSuite) TestConvertNilPatient(c *C) {
+	var p *Patient
+	result, err := p.Convert(ConversionOptions{})
+	c.Assert(err, ErrorMatches, "no patient to convert")
+	c.Assert(result.Models, HasLen, 0)
+}
+
+func (s *ConversionSuite) TestConversionResultBundle(c *C) {
+	result, err := s.Patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	bundle := result.FHIRTransactionBundle(false)
+	c.Assert(bundle.Type, Equals, "transaction")
+	c.Assert(bundle.Entry, HasLen, len(result.Models))
+	c.Assert(bundle.Entry[0].Resource, Equals, result.Models[0])
+}
+
+func (s *ConversionSuite) TestResultValueNumericScalar(c *C) {
+	value := &ResultValue{}
+	err := json.Unmarshal([]byte(`{"scalar": 72, "unit": "/min", "_type": "PhysicalQuantityResultValue"}`), value)
+	c.Assert(err, IsNil)
+	c.Assert(value.err, IsNil)
+	c.Assert(value.Physical.Scalar, Equals, "72")
+}
+
+func (s *ConversionSuite) TestConvertInvalidResultValue(c *C) {
+	// One bad value doesn't stop the rest of the patient from being decoded
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+	var raw map[string]interface{}
+	util.CheckErr(json.Unmarshal(data, &raw))
+	vitalSign := raw["vital_signs"].([]interface{})[0].(map[string]interface{})
+	vitalSign["values"] = []interface{}{map[string]interface{}{"scalar": map[string]interface{}{}, "_type": "PhysicalQuantityResultValue"}}
+	data, err = json.Marshal(raw)
+	util.CheckErr(err)
+	patient := &Patient{}
+	c.Assert(json.Unmarshal(data, patient), IsNil)
+	c.Assert(patient.Conditions, HasLen, len(s.Patient.Conditions))
+
+	result, err := patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Diagnostics, HasLen, 1)
+	c.Assert(result.Diagnostics[0].Severity, Equals, SeverityError)
+	c.Assert(result.Diagnostics[0].Location(), Equals, "vital_signs[0]")
+	c.Assert(result.Diagnostics[0].Reason, Matches, "value 0 is invalid: .*")
+	c.Assert(result.Models, HasLen, len(s.Patient.FHIRModels())-1)
+}
+
+func (s *ConversionSuite) TestScalarOnlyStringsAndNumbers(c *C) {
+	for _, value := range []string{`true`, `{}`, `[1]`} {
+		scalar := &Scalar{}
+		c.Assert(json.Unmarshal([]byte(`{"value": `+value+`, "unit": "mg"}`), scalar), IsNil)
+		c.Assert(scalar.err, ErrorMatches, "scalar .* is neither a string nor a number")
+		c.Assert(scalar.Value, Equals, "")
+
+		result := &ResultValue{}
+		c.Assert(json.Unmarshal([]byte(`{"scalar": `+value+`, "_type": "PhysicalQuantityResultValue"}`), result), IsNil)
+		c.Assert(result.err, ErrorMatches, "scalar .* is neither a string nor a number")
+	}
+
+	scalar := &Scalar{}
+	c.Assert(json.Unmarshal([]byte(`{"value": 1.5, "unit": "mg"}`), scalar), IsNil)
+	c.Assert(scalar.err, IsNil)
+	c.Assert(scalar.Value, Equals, "1.5")
+}
+
+func (s *ConversionSuite) TestConvertInvalidDose(c *C) {
+	// A bad dose doesn't stop the rest of the patient from being decoded
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+	var raw map[string]interface{}
+	util.CheckErr(json.Unmarshal(data, &raw))
+	medication := raw["medications"].([]interface{})[0].(map[string]interface{})
+	medication["dose"] = map[string]interface{}{"value": true, "unit": "mg"}
+	data, err = json.Marshal(raw)
+	util.CheckErr(err)
+	patient := &Patient{}
+	c.Assert(json.Unmarshal(data, patient), IsNil)
+
+	result, err := patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Diagnostics, HasLen, 1)
+	c.Assert(result.Diagnostics[0].Severity, Equals, SeverityError)
+	c.Assert(result.Diagnostics[0].Location(), Equals, "medications[0]")
+	c.Assert(result.Diagnostics[0].Reason, Equals, "dose is invalid: scalar true is neither a string nor a number")
+	c.Assert(result.Models, HasLen, len(s.Patient.FHIRModels())-1)
+}
@@ -0,0 +1,91 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+// Extensions defined by the DSTU2 US Core profiles for patient demographics
+const (
+	raceExtensionURL      = "http://hl7.org/fhir/StructureDefinition/us-core-race"
+	ethnicityExtensionURL = "http://hl7.org/fhir/StructureDefinition/us-core-ethnicity"
+	religionExtensionURL  = "http://hl7.org/fhir/StructureDefinition/us-core-religion"
+)
+
+// DemographicCode is a patient-level code, such as race or marital status.  HDS stores these with a display name and
+// often leaves out the code system.
+type DemographicCode struct {
+	CodeObject
+	Name string `json:"name"`
+}
+
+// FHIRCodeableConcept returns the code as a CodeableConcept, using the default code system if there is none.
+func (d *DemographicCode) FHIRCodeableConcept(defaultCodeSystem string) *fhir.CodeableConcept {
+	codeSystem := d.CodeSystem
+	if codeSystem == "" {
+		codeSystem = defaultCodeSystem
+	}
+	concept := &fhir.CodeableConcept{Text: d.Name}
+	if d.Code != "" {
+		concept.Coding = []fhir.Coding{
+			{System: CodeSystemMap[codeSystem], Code: d.Code, Display: d.Name},
+		}
+	}
+	return concept
+}
+
+// DemographicCodeFromFHIR returns the first coding in the concept as a demographic code, using the concept's text
+// (or the coding's display) as the name.  It is the inverse of DemographicCode.FHIRCodeableConcept.
+func DemographicCodeFromFHIR(concept *fhir.CodeableConcept) *DemographicCode {
+	if concept == nil {
+		return nil
+	}
+	d := &DemographicCode{Name: concept.Text}
+	if code := CodeObjectFromFHIR(concept); code != nil {
+		d.CodeObject = *code
+		if d.Name == "" {
+			d.Name = concept.Coding[0].Display
+		}
+	}
+	return d
+}
+
+// LanguageCode is a BCP 47 language code.  HDS has represented languages as both plain strings and code objects, so
+// both are accepted.
+type LanguageCode string
+
+func (l *LanguageCode) UnmarshalJSON(data []byte) (err error) {
+	var code string
+	if err = json.Unmarshal(data, &code); err == nil {
+		*l = LanguageCode(code)
+		return
+	}
+
+	obj := &CodeObject{}
+	if err = json.Unmarshal(data, obj); err == nil {
+		*l = LanguageCode(obj.Code)
+	}
+	return
+}
+
+func (l LanguageCode) FHIRCodeableConcept() *fhir.CodeableConcept {
+	return &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "urn:ietf:bcp:47", Code: string(l)},
+		},
+	}
+}
+
+// LanguageCodeFromFHIR returns the first BCP 47 code in the concept, or an empty code if there is none
+func LanguageCodeFromFHIR(concept *fhir.CodeableConcept) LanguageCode {
+	if concept == nil {
+		return ""
+	}
+	for _, coding := range concept.Coding {
+		if coding.System == "urn:ietf:bcp:47" {
+			return LanguageCode(coding.Code)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+// Scalar is a value with units, as HDS represents doses and timing periods.  Depending on the source of the data,
+// HDS exports the value as either a string or a number, so both are accepted.
+type Scalar struct {
+	Value string `json:"value"`
+	Unit  string `json:"unit"`
+	// err holds the problem decoding the value, if any.  Like ResultValue, the entry with the scalar is reported when
+	// the patient is converted, rather than failing to decode the whole patient.
+	err error
+}
+
+func (s *Scalar) UnmarshalJSON(data []byte) error {
+	raw := &struct {
+		Value json.RawMessage `json:"value"`
+		Unit  string          `json:"unit"`
+	}{}
+	if s.err = json.Unmarshal(data, raw); s.err != nil {
+		return nil
+	}
+
+	s.Unit = raw.Unit
+	s.Value, s.err = decodeScalar(raw.Value)
+	return nil
+}
+
+// decodeScalar returns the scalar value as a string, whether HDS exported it as a string or a number.  A missing or
+// null value is empty.  Any other JSON value is an error.
+func decodeScalar(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	if raw[0] == '"' {
+		var value string
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+	var f float64
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return "", fmt.Errorf("scalar %s is neither a string nor a number", raw)
+	}
+	return string(raw), nil
+}
+
+// checkScalar returns an error naming the scalar if it couldn't be decoded
+func checkScalar(name string, s *Scalar) error {
+	if s != nil && s.err != nil {
+		return fmt.Errorf("%s is invalid: %v", name, s.err)
+	}
+	return nil
+}
+
+// FHIRQuantity returns the scalar as a FHIR quantity, or nil if the value is not numeric
+func (s *Scalar) FHIRQuantity() *fhir.Quantity {
+	val, err := strconv.ParseFloat(s.Value, 64)
+	if err != nil {
+		return nil
+	}
+	return &fhir.Quantity{Unit: s.Unit, Value: &val}
+}
+
+// ScalarFromFHIR returns the quantity as a scalar, or nil if there is no quantity.  It is the inverse of
+// Scalar.FHIRQuantity.
+func ScalarFromFHIR(quantity *fhir.Quantity) *Scalar {
+	if quantity == nil {
+		return nil
+	}
+	scalar := &Scalar{Unit: quantity.Unit}
+	if quantity.Value != nil {
+		scalar.Value = strconv.FormatFloat(*quantity.Value, 'f', -1, 64)
+	}
+	return scalar
+}
+
+// AdministrationTiming represents how often a medication is taken.  HDS only captures the period between doses
+// and whether the institution determines the exact time of administration.
+type AdministrationTiming struct {
+	InstitutionSpecified bool    `json:"institutionSpecified"`
+	Period               *Scalar `json:"period"`
+}
+
+// FHIRTiming returns a timing that repeats once per period, or nil if the period is unknown or not numeric
+func (a *AdministrationTiming) FHIRTiming() *fhir.Timing {
+	if a.Period == nil {
+		return nil
+	}
+	period, err := strconv.ParseFloat(a.Period.Value, 64)
+	periodUnits := convertPeriodUnits(a.Period.Unit)
+	if err != nil || periodUnits == "" {
+		return nil
+	}
+
+	frequency := int32(1)
+	return &fhir.Timing{
+		Repeat: &fhir.TimingRepeatComponent{
+			Frequency:   &frequency,
+			Period:      &period,
+			PeriodUnits: periodUnits,
+		},
+	}
+}
+
+// AdministrationTimingFromFHIR returns the time between doses in the timing, or nil if it has no period.  It is the
+// inverse of AdministrationTiming.FHIRTiming.  Since HDS only has the period, a timing that repeats more than once per
+// period is converted to the equivalent shorter period.
+func AdministrationTimingFromFHIR(timing *fhir.Timing) *AdministrationTiming {
+	if timing == nil || timing.Repeat == nil || timing.Repeat.Period == nil || timing.Repeat.PeriodUnits == "" {
+		return nil
+	}
+	period := *timing.Repeat.Period
+	if frequency := timing.Repeat.Frequency; frequency != nil && *frequency > 1 {
+		period /= float64(*frequency)
+	}
+	// The FHIR units of time are also UCUM units, so they can be used as-is
+	return &AdministrationTiming{
+		Period: &Scalar{Value: strconv.FormatFloat(period, 'f', -1, 64), Unit: timing.Repeat.PeriodUnits},
+	}
+}
+
+// convertPeriodUnits maps the UCUM time unit to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-units-of-time.html
+// If the unit cannot be mapped, an empty string is returned.
+func convertPeriodUnits(unit string) string {
+	switch unit {
+	case "s", "sec":
+		return "s"
+	case "min":
+		return "min"
+	case "h", "hr":
+		return "h"
+	case "d", "day":
+		return "d"
+	case "wk":
+		return "wk"
+	case "mo":
+		return "mo"
+	case "a", "yr":
+		return "a"
+	}
+	return ""
+}
+
+// DoseRestriction represents the maximum amount of a medication that may be taken in a given period of time
+type DoseRestriction struct {
+	Numerator   *Scalar `json:"numerator"`
+	Denominator *Scalar `json:"denominator"`
+}
+
+// FHIRRatio returns the restriction as a FHIR ratio, or nil if neither the numerator nor the denominator is numeric
+func (d *DoseRestriction) FHIRRatio() *fhir.Ratio {
+	ratio := &fhir.Ratio{}
+	if d.Numerator != nil {
+		ratio.Numerator = d.Numerator.FHIRQuantity()
+	}
+	if d.Denominator != nil {
+		ratio.Denominator = d.Denominator.FHIRQuantity()
+	}
+	if ratio.Numerator == nil && ratio.Denominator == nil {
+		return nil
+	}
+	return ratio
+}
+
+// DoseRestrictionFromFHIR returns the ratio as a dose restriction, or nil if there is no ratio.  It is the inverse of
+// DoseRestriction.FHIRRatio.
+func DoseRestrictionFromFHIR(ratio *fhir.Ratio) *DoseRestriction {
+	if ratio == nil {
+		return nil
+	}
+	return &DoseRestriction{Numerator: ScalarFromFHIR(ratio.Numerator), Denominator: ScalarFromFHIR(ratio.Denominator)}
+}
@@ -6,6 +6,12 @@ type Encounter struct {
 	Entry
 	Reason               *Entry      `json:"reason"`
 	DischargeDisposition *CodeObject `json:"dischargeDisposition"`
+	AdmitTime            *UnixTime   `json:"admitTime"`
+	DischargeTime        *UnixTime   `json:"dischargeTime"`
+	AdmitType            *CodeObject `json:"admitType"`
+	TransferFrom         *Transfer   `json:"transferFrom"`
+	TransferTo           *Transfer   `json:"transferTo"`
+	Facility             *Facility   `json:"facility"`
 }
 
 func (e *Encounter) FHIRModels() []interface{} {
@@ -15,18 +21,92 @@ func (e *Encounter) FHIRModels() []interface{} {
 	typeConcept := e.Codes.FHIRCodeableConcept(e.Description)
 	fhirEncounter.Type = []fhir.CodeableConcept{*typeConcept}
 	fhirEncounter.Patient = e.Patient.FHIRReference()
-	fhirEncounter.Period = e.GetFHIRPeriod()
+	fhirEncounter.Period = e.convertPeriod()
+	if performer := e.Patient.PerformerReference(e.PerformerID); performer != nil {
+		fhirEncounter.Participant = []fhir.EncounterParticipantComponent{{Individual: performer}}
+	}
 	if e.Reason != nil && len(e.Reason.Codes) > 0 {
 		reasonConcept := e.Reason.Codes.FHIRCodeableConcept("")
 		fhirEncounter.Reason = []fhir.CodeableConcept{*reasonConcept}
 	}
-	if e.DischargeDisposition != nil {
-		fhirEncounter.Hospitalization = &fhir.EncounterHospitalizationComponent{
-			DischargeDisposition: e.DischargeDisposition.FHIRCodeableConcept(""),
+	fhirEncounter.Hospitalization = e.convertHospitalization()
+
+	models := []interface{}{fhirEncounter}
+	if e.Facility != nil {
+		// A facility shared with an earlier encounter is converted with that encounter
+		if e.Facility.sharedWith == nil {
+			models = append(models, e.Facility.FHIRModels()...)
+		}
+		location := fhir.EncounterLocationComponent{Location: e.Facility.locationID().FHIRReference()}
+		if location.Period = e.Facility.GetFHIRPeriod(); location.Period == nil {
+			location.Period = fhirEncounter.Period
 		}
+		fhirEncounter.Location = []fhir.EncounterLocationComponent{location}
 	}
+	if e.TransferFrom != nil {
+		models = append(models, e.TransferFrom.FHIRModels()...)
+		fhirEncounter.Location = append(fhirEncounter.Location, e.TransferFrom.encounterLocation(false))
+	}
+	if e.TransferTo != nil {
+		models = append(models, e.TransferTo.FHIRModels()...)
+		fhirEncounter.Location = append(fhirEncounter.Location, e.TransferTo.encounterLocation(true))
+	}
+
+	return models
+}
 
-	return []interface{}{fhirEncounter}
+// FromFHIR sets the encounter from the FHIR encounter.  It is the inverse of FHIRModels, except that the facility,
+// transfers, and performer are left to FromFHIRBundle, since they require the related resources.
+func (e *Encounter) FromFHIR(fhirEncounter *fhir.Encounter) {
+	e.SetTempID(fhirEncounter.Id)
+	e.StatusCode = encounterStatusFromFHIR(fhirEncounter.Status)
+	if len(fhirEncounter.Type) > 0 {
+		e.setFHIRCodes(&fhirEncounter.Type[0])
+	}
+	e.setFHIRPeriod(fhirEncounter.Period)
+	if len(fhirEncounter.Reason) > 0 {
+		e.Reason = &Entry{Codes: CodeMapFromFHIR(&fhirEncounter.Reason[0])}
+	}
+	if hospitalization := fhirEncounter.Hospitalization; hospitalization != nil {
+		e.AdmitType = CodeObjectFromFHIR(hospitalization.AdmitSource)
+		e.DischargeDisposition = CodeObjectFromFHIR(hospitalization.DischargeDisposition)
+	}
+}
+
+// convertPeriod returns the period of the encounter, using the admission and discharge times when the start and
+// end times are missing
+func (e *Encounter) convertPeriod() *fhir.Period {
+	entry := &Entry{StartTime: e.StartTime, EndTime: e.EndTime}
+	if entry.StartTime == nil {
+		entry.StartTime = e.AdmitTime
+	}
+	if entry.EndTime == nil {
+		entry.EndTime = e.DischargeTime
+	}
+	return entry.GetFHIRPeriod()
+}
+
+// convertHospitalization returns the admission and discharge details, or nil if HDS has none
+func (e *Encounter) convertHospitalization() *fhir.EncounterHospitalizationComponent {
+	if e.AdmitType == nil && e.TransferFrom == nil && e.TransferTo == nil && e.DischargeDisposition == nil {
+		return nil
+	}
+
+	hospitalization := &fhir.EncounterHospitalizationComponent{}
+	if e.AdmitType != nil {
+		// QDM's admission type is the closest thing HDS has to an admit source
+		hospitalization.AdmitSource = e.AdmitType.FHIRCodeableConcept("")
+	}
+	if e.TransferFrom != nil {
+		hospitalization.Origin = e.TransferFrom.FHIRReference()
+	}
+	if e.TransferTo != nil {
+		hospitalization.Destination = e.TransferTo.FHIRReference()
+	}
+	if e.DischargeDisposition != nil {
+		hospitalization.DischargeDisposition = e.DischargeDisposition.FHIRCodeableConcept("")
+	}
+	return hospitalization
 }
 
 // convertStatus maps the status to a code in the required FHIR value set:
@@ -65,3 +145,21 @@ func (e *Encounter) convertStatus() string {
 
 	return status
 }
+
+// encounterStatusFromFHIR maps the FHIR status back to an ActStatus.  It is the inverse of convertStatus.  If the
+// status cannot be mapped, nil is returned.
+func encounterStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "in-progress", "arrived":
+		return actStatus("active")
+	case "cancelled":
+		return actStatus("cancelled")
+	case "planned":
+		return actStatus("new")
+	case "onleave":
+		return actStatus("suspended")
+	case "finished":
+		return actStatus("completed")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package hdsfhir
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/url"
 
 	fhir "github.com/intervention-engine/fhir/models"
 	"github.com/pebbe/util"
@@ -74,3 +75,111 @@ func (s *EncounterSuite) TestInpatientEncounter(c *C) {
 	c.Assert(encounter.Hospitalization.DischargeDisposition.Coding, HasLen, 1)
 	c.Assert(encounter.Hospitalization.DischargeDisposition.MatchesCode("urn:oid:2.16.840.1.113883.3.88.12.80.33", "1"), Equals, true)
 }
+
+func (s *EncounterSuite) TestTransferredInpatientEncounter(c *C) {
+	models := s.Encounters["transferredInpatientEncounter"].FHIRModels()
+	c.Assert(models, HasLen, 4)
+	c.Assert(models[0], FitsTypeOf, &fhir.Encounter{})
+	encounter := models[0].(*fhir.Encounter)
+
+	// The admission and discharge times stand in for the missing start and end times
+	c.Assert(encounter.Period.Start, DeepEquals, NewUnixTime(1320148800).FHIRDateTime())
+	c.Assert(encounter.Period.End, DeepEquals, NewUnixTime(1320321600).FHIRDateTime())
+
+	c.Assert(models[1], FitsTypeOf, &fhir.Location{})
+	facility := models[1].(*fhir.Location)
+	c.Assert(facility.Name, Equals, "General Hospital")
+	c.Assert(facility.Mode, Equals, "instance")
+	c.Assert(facility.Type.MatchesCode("urn:oid:2.16.840.1.113883.6.259", "1025-6"), Equals, true)
+	c.Assert(facility.Address.City, Equals, "Bedford")
+	c.Assert(facility.Telecom, HasLen, 1)
+	c.Assert(facility.Telecom[0].Value, Equals, "+1-781-555-0100")
+	c.Assert(encounter.Location, HasLen, 3)
+	c.Assert(encounter.Location[0].Location, DeepEquals, &fhir.Reference{Reference: "urn:uuid:" + facility.Id})
+	c.Assert(encounter.Location[0].Period.Start, DeepEquals, NewUnixTime(1320152400).FHIRDateTime())
+	c.Assert(encounter.Location[0].Period.End, DeepEquals, NewUnixTime(1320321600).FHIRDateTime())
+
+	c.Assert(models[2], FitsTypeOf, &fhir.Location{})
+	origin := models[2].(*fhir.Location)
+	c.Assert(origin.Mode, Equals, "kind")
+	c.Assert(origin.Type.MatchesCode("http://snomed.info/sct", "309911002"), Equals, true)
+	c.Assert(models[3], FitsTypeOf, &fhir.Location{})
+	destination := models[3].(*fhir.Location)
+	c.Assert(destination.Mode, Equals, "kind")
+	c.Assert(destination.Type.MatchesCode("http://snomed.info/sct", "42665001"), Equals, true)
+
+	// The patient left the origin, and arrived at the destination, at the times of the transfers
+	c.Assert(encounter.Location[1].Location, DeepEquals, &fhir.Reference{Reference: "urn:uuid:" + origin.Id})
+	c.Assert(encounter.Location[1].Period, DeepEquals, &fhir.Period{End: NewUnixTime(1320148800).FHIRDateTime()})
+	c.Assert(encounter.Location[2].Location, DeepEquals, &fhir.Reference{Reference: "urn:uuid:" + destination.Id})
+	c.Assert(encounter.Location[2].Period, DeepEquals, &fhir.Period{Start: NewUnixTime(1320321600).FHIRDateTime()})
+
+	hospitalization := encounter.Hospitalization
+	c.Assert(hospitalization.AdmitSource.MatchesCode("urn:oid:2.16.840.1.113883.3.88.12.80.33", "1"), Equals, true)
+	c.Assert(hospitalization.Origin, DeepEquals, &fhir.Reference{Reference: "urn:uuid:" + origin.Id})
+	c.Assert(hospitalization.Destination, DeepEquals, &fhir.Reference{Reference: "urn:uuid:" + destination.Id})
+	c.Assert(hospitalization.DischargeDisposition.MatchesCode("urn:oid:2.16.840.1.113883.3.88.12.80.33", "02"), Equals, true)
+}
+
+func (s *EncounterSuite) TestFacilityWithoutPeriod(c *C) {
+	encounter := &Encounter{
+		Entry:    Entry{Patient: s.Patient, StartTime: NewUnixTime(1320148800), EndTime: NewUnixTime(1320152400)},
+		Facility: &Facility{Name: "Clinic"},
+	}
+	fhirEncounter := encounter.FHIRModels()[0].(*fhir.Encounter)
+	c.Assert(fhirEncounter.Location, HasLen, 1)
+	c.Assert(fhirEncounter.Location[0].Period, DeepEquals, fhirEncounter.Period)
+	c.Assert(fhirEncounter.Hospitalization, IsNil)
+}
+
+func (s *EncounterSuite) TestLocationConditionalUpdate(c *C) {
+	encounter := s.Encounters["transferredInpatientEncounter"]
+	patient := &Patient{Encounters: []*Encounter{encounter}}
+	encounter.Patient = patient
+	defer func() { encounter.Patient = s.Patient }()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	c.Assert(bundle.Entry, HasLen, 5)
+	c.Assert(bundle.Entry[2].Request.Method, Equals, "PUT")
+	assertURL(c, bundle, 2, "Location?name=General+Hospital&type=urn:oid:2.16.840.1.113883.6.259|1025-6")
+	// The transfer locations have no names, so they are matched by their entry identifiers
+	for i := 3; i < 5; i++ {
+		identifier := findEntryIdentifier(bundle.Entry[i].Resource)
+		c.Assert(identifier, NotNil)
+		c.Assert(bundle.Entry[i].Request.Method, Equals, "PUT")
+		assertURL(c, bundle, i, "Location?identifier=%s", url.QueryEscape(EntryIdentifierSystem+"|"+identifier.Value))
+	}
+}
+
+func (s *EncounterSuite) TestSharedFacility(c *C) {
+	// Encounters at the same facility share one location, so the bundle doesn't update it twice
+	patient := &Patient{Encounters: []*Encounter{
+		{Entry: Entry{StartTime: NewUnixTime(1320148800)}, Facility: &Facility{Name: "General Hospital", Code: &CodeObject{CodeSystem: "Unknown", Code: "1"}}},
+		{Entry: Entry{StartTime: NewUnixTime(1320235200)}, Facility: &Facility{Name: "General Hospital", Code: &CodeObject{CodeSystem: "Unknown", Code: "1"}}},
+		{Entry: Entry{StartTime: NewUnixTime(1320321600)}, Facility: &Facility{Name: "Clinic"}},
+	}}
+	patient.linkEntries()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	var encounters []*fhir.Encounter
+	var locations []string
+	for i, entry := range bundle.Entry {
+		switch resource := entry.Resource.(type) {
+		case *fhir.Encounter:
+			encounters = append(encounters, resource)
+		case *fhir.Location:
+			locations = append(locations, bundle.Entry[i].FullUrl)
+			if resource.Name == "General Hospital" {
+				// The facility type has no known system, so it isn't searched on
+				assertURL(c, bundle, i, "Location?name=General+Hospital")
+			}
+		}
+	}
+	c.Assert(locations, HasLen, 2)
+	c.Assert(encounters, HasLen, 3)
+	c.Assert(encounters[0].Location[0].Location.Reference, Equals, locations[0])
+	c.Assert(encounters[1].Location[0].Location.Reference, Equals, locations[0])
+	c.Assert(encounters[2].Location[0].Location.Reference, Equals, locations[1])
+	// Each encounter still has its own period at the facility
+	c.Assert(encounters[1].Location[0].Period.Start, DeepEquals, NewUnixTime(1320235200).FHIRDateTime())
+}
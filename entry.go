@@ -5,6 +5,8 @@ import fhir "github.com/intervention-engine/fhir/models"
 type Entry struct {
 	TemporallyIdentified
 	Patient        *Patient    `json:"-"`
+	ID             ObjectID    `json:"_id"`
+	PerformerID    ObjectID    `json:"performer_id"`
 	StartTime      *UnixTime   `json:"start_time"`
 	EndTime        *UnixTime   `json:"end_time"`
 	Time           *UnixTime   `json:"time"`
@@ -32,3 +34,21 @@ func (e *Entry) GetFHIRPeriod() *fhir.Period {
 
 	return period
 }
+
+// setFHIRPeriod sets the start and end times from the period.  It is the inverse of GetFHIRPeriod.
+func (e *Entry) setFHIRPeriod(period *fhir.Period) {
+	if period == nil {
+		return
+	}
+	e.StartTime = UnixTimeFromFHIR(period.Start)
+	e.EndTime = UnixTimeFromFHIR(period.End)
+}
+
+// setFHIRCodes sets the codes from the concept, and the description from its text
+func (e *Entry) setFHIRCodes(concept *fhir.CodeableConcept) {
+	if concept == nil {
+		return
+	}
+	e.Codes = CodeMapFromFHIR(concept)
+	e.Description = concept.Text
+}
@@ -0,0 +1,69 @@
+package hdsfhir
+
+import (
+	"reflect"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/satori/go.uuid"
+)
+
+// EntryIdentifierSystem is the system of the identifiers added to converted resources to tie them to the HDS entries
+// they were converted from
+const EntryIdentifierSystem = "http://github.com/intervention-engine/hdsfhir/entry"
+
+// entryIdentifier returns the value of the resource's entry identifier.  Resources converted from entries (or
+// providers) with HDS IDs are identified by the HDS ID and the path, e.g., "5697d8b2c1c1b1a2b3000020/report".  Since
+// the other resources are only identified by a fingerprint of the entry (see identifiedResource), they get a
+// name-based UUID, which is safe to use in search parameters.
+func (r *identifiedResource) entryIdentifier() string {
+	if r.hdsID != "" {
+		return string(r.hdsID) + r.path
+	}
+	return uuid.NewV5(DefaultIDNamespace, r.name).String()
+}
+
+// addEntryIdentifiers adds an entry identifier to each model converted from the patient's entries and providers, so
+// that converting the patient again results in resources that can be matched by identifier.  The patient itself is
+// identified by its medical record number instead.
+func (p *Patient) addEntryIdentifiers(models []interface{}) {
+	values := make(map[string]string)
+	for _, resource := range p.identifiedResources() {
+		if resource.key.Section != "" {
+			values[resource.id.GetTempID()] = resource.entryIdentifier()
+		}
+	}
+
+	identifiersType := reflect.TypeOf([]fhir.Identifier{})
+	for _, model := range models {
+		v := reflect.ValueOf(model).Elem()
+		value, ok := values[v.FieldByName("Id").String()]
+		identifiers := v.FieldByName("Identifier")
+		if !ok || !identifiers.IsValid() || identifiers.Type() != identifiersType || findEntryIdentifier(model) != nil {
+			continue
+		}
+		identifier := fhir.Identifier{System: EntryIdentifierSystem, Value: value}
+		identifiers.Set(reflect.Append(identifiers, reflect.ValueOf(identifier)))
+	}
+}
+
+// findEntryIdentifier returns the model's entry identifier, or nil if it doesn't have one
+func findEntryIdentifier(model interface{}) *fhir.Identifier {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.Elem().FieldByName("Identifier")
+	if !field.IsValid() {
+		return nil
+	}
+	identifiers, ok := field.Interface().([]fhir.Identifier)
+	if !ok {
+		return nil
+	}
+	for i := range identifiers {
+		if identifiers[i].System == EntryIdentifierSystem {
+			return &identifiers[i]
+		}
+	}
+	return nil
+}
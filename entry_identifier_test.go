@@ -0,0 +1,135 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type EntryIdentifierSuite struct {
+	Patient *Patient
+}
+
+var _ = Suite(&EntryIdentifierSuite{})
+
+func (s *EntryIdentifierSuite) SetUpTest(c *C) {
+	s.Patient = s.loadPatient()
+}
+
+func (s *EntryIdentifierSuite) loadPatient() *Patient {
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+
+	patient := &Patient{}
+	err = json.Unmarshal(data, patient)
+	util.CheckErr(err)
+	return patient
+}
+
+// entryIdentifiers returns the value of each model's entry identifier, or "" if it has none
+func entryIdentifiers(models []interface{}) []string {
+	values := make([]string, len(models))
+	for i, model := range models {
+		if identifier := findEntryIdentifier(model); identifier != nil {
+			values[i] = identifier.Value
+		}
+	}
+	return values
+}
+
+func (s *EntryIdentifierSuite) TestEntryIdentifiers(c *C) {
+	models := s.Patient.FHIRModels()
+	values := entryIdentifiers(models)
+	c.Assert(values[0], Equals, "")
+	c.Assert(models[0].(*fhir.Patient).Identifier, HasLen, 1)
+
+	unique := make(map[string]bool)
+	for i, value := range values[1:] {
+		c.Assert(value, Not(Equals), "", Commentf("model %d has no entry identifier", i+1))
+		unique[value] = true
+	}
+	c.Assert(unique, HasLen, len(models)-1)
+
+	// The identifiers don't depend on the (random) temporary IDs, so they are the same when the patient is converted
+	// again
+	c.Assert(entryIdentifiers(s.loadPatient().FHIRModels()), DeepEquals, values)
+}
+
+func (s *EntryIdentifierSuite) TestEntryIdentifiersFromHDSIDs(c *C) {
+	s.Patient.Conditions[0].ID = "5697d8b2c1c1b1a2b3000020"
+	s.Patient.Procedures[0].ID = "5697d8b2c1c1b1a2b3000021"
+
+	models := s.Patient.FHIRModels()
+	values := entryIdentifiers(models)
+	c.Assert(values[5], Equals, "5697d8b2c1c1b1a2b3000020")
+	c.Assert(values[11], Equals, "5697d8b2c1c1b1a2b3000021")
+	c.Assert(values[12], Equals, "5697d8b2c1c1b1a2b3000021/report")
+	c.Assert(values[13], Equals, "5697d8b2c1c1b1a2b3000021/values[0]")
+
+	// Converting the entry again doesn't add another identifier
+	s.Patient.addEntryIdentifiers(models)
+	c.Assert(models[5].(*fhir.Condition).Identifier, HasLen, 1)
+}
+
+func (s *EntryIdentifierSuite) TestConditionalUpdateByEntryIdentifier(c *C) {
+	var procedures map[string]*Procedure
+	data, err := ioutil.ReadFile("./fixtures/procedures.json")
+	util.CheckErr(err)
+	util.CheckErr(json.Unmarshal(data, &procedures))
+	var goals map[string]*CareGoal
+	data, err = ioutil.ReadFile("./fixtures/care_goals.json")
+	util.CheckErr(err)
+	util.CheckErr(json.Unmarshal(data, &goals))
+
+	s.Patient.Procedures = append(s.Patient.Procedures, procedures["procedureOrdered"])
+	s.Patient.CareGoals = append(s.Patient.CareGoals, goals["ldlGoal"])
+	s.Patient.linkEntries()
+
+	bundle := s.Patient.FHIRTransactionBundle(true)
+	var matched []string
+	for i, entry := range bundle.Entry {
+		var resourceType string
+		switch entry.Resource.(type) {
+		case *fhir.ProcedureRequest:
+			resourceType = "ProcedureRequest"
+		case *fhir.Goal:
+			resourceType = "Goal"
+		default:
+			continue
+		}
+		identifier := findEntryIdentifier(entry.Resource)
+		c.Assert(identifier, NotNil)
+		c.Assert(entry.Request.Method, Equals, "PUT")
+		assertURL(c, bundle, i, resourceType+"?identifier=%s", url.QueryEscape(EntryIdentifierSystem+"|"+identifier.Value))
+		matched = append(matched, resourceType)
+	}
+	c.Assert(matched, DeepEquals, []string{"ProcedureRequest", "Goal"})
+
+	// The identifiers aren't used for types that can be matched on their data
+	assertURL(c, bundle, 6, "Condition?code=http://snomed.info/sct|981000124106&onset=%s&patient=%s", ld("2012-03-01T12:05:00"), bundle.Entry[0].FullUrl)
+}
+
+func (s *EntryIdentifierSuite) TestCoverageMemberID(c *C) {
+	// Without a member ID, the entry identifier is the coverage's only identifier, and it isn't read as the member ID
+	s.Patient.InsuranceProviders = []*InsuranceProvider{{Entry: Entry{Codes: CodeMap{"SOP": []string{"349"}}}}}
+	s.Patient.linkEntries()
+
+	result, err := s.Patient.Convert(ConversionOptions{})
+	util.CheckErr(err)
+	for _, model := range result.Models {
+		if coverage, ok := model.(*fhir.Coverage); ok {
+			c.Assert(coverage.Identifier, HasLen, 1)
+			c.Assert(coverage.Identifier[0].System, Equals, EntryIdentifierSystem)
+
+			insuranceProvider := &InsuranceProvider{}
+			insuranceProvider.FromFHIR(coverage, nil)
+			c.Assert(insuranceProvider.MemberID, Equals, "")
+			return
+		}
+	}
+	c.Fatal("no coverage was converted")
+}
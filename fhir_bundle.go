@@ -0,0 +1,442 @@
+package hdsfhir
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+// FromFHIRBundle rebuilds an HDS patient from a bundle of FHIR resources, such as the transaction bundle created by
+// FHIRTransactionBundle.  It is the inverse of Patient.FHIRModels: each resource is converted back to the kind of
+// entry it came from, and resources that were created to support an entry (e.g., the results of a procedure report,
+// or the dispenses authorized by an order) are folded back into it.  Resources that don't correspond to anything in
+// HDS are ignored.  An error is returned if the bundle doesn't contain exactly one patient.
+func FromFHIRBundle(bundle *fhir.Bundle) (*Patient, error) {
+	if bundle == nil {
+		return nil, errors.New("no bundle to convert")
+	}
+
+	r := newBundleReader(bundle)
+	var fhirPatient *fhir.Patient
+	for _, resource := range r.order {
+		if t, ok := resource.(*fhir.Patient); ok {
+			if fhirPatient != nil {
+				return nil, errors.New("bundle contains more than one patient")
+			}
+			fhirPatient = t
+		}
+	}
+	if fhirPatient == nil {
+		return nil, errors.New("bundle does not contain a patient")
+	}
+
+	p := r.patient
+	p.FromFHIR(fhirPatient)
+	for i := range fhirPatient.CareProvider {
+		if provider := r.provider(&fhirPatient.CareProvider[i]); provider != nil {
+			p.ProviderPerformances = append(p.ProviderPerformances, &ProviderPerformance{ProviderID: provider.ID, Provider: provider})
+		}
+	}
+	for _, resource := range r.order {
+		if !r.used[resource] {
+			r.read(resource)
+		}
+	}
+	// Lab reports come before their results, so they can only be read once the results have been
+	for _, resource := range r.order {
+		if report, ok := resource.(*fhir.DiagnosticReport); ok && !r.used[report] {
+			r.readLabReport(report)
+		}
+	}
+	for i := range fhirPatient.Contact {
+		r.readContact(&fhirPatient.Contact[i])
+	}
+
+	p.linkEntries()
+	return p, nil
+}
+
+// bundleReader converts the resources in a bundle, keeping track of the resources that are part of other entries
+type bundleReader struct {
+	patient *Patient
+	// order holds the resources in the order they appear in the bundle
+	order []interface{}
+	// resources holds the resources by every reference that might be used to refer to them
+	resources map[string]interface{}
+	// used holds the resources that are converted as part of another resource's entry
+	used map[interface{}]bool
+	// dispenses holds the dispenses authorized by each medication order
+	dispenses map[*fhir.MedicationOrder][]*fhir.MedicationDispense
+	// providers holds the providers already converted from practitioners
+	providers map[*fhir.Practitioner]*Provider
+	// labResults holds the lab result converted from each observation, so that reports can set their performers
+	labResults map[*fhir.Observation]*LabResult
+}
+
+func newBundleReader(bundle *fhir.Bundle) *bundleReader {
+	r := &bundleReader{
+		patient:    &Patient{},
+		resources:  make(map[string]interface{}),
+		used:       make(map[interface{}]bool),
+		dispenses:  make(map[*fhir.MedicationOrder][]*fhir.MedicationDispense),
+		providers:  make(map[*fhir.Practitioner]*Provider),
+		labResults: make(map[*fhir.Observation]*LabResult),
+	}
+	for _, entry := range bundle.Entry {
+		if entry.Resource == nil {
+			continue
+		}
+		// Resources are normally pointers, but accept values too, so that the type switches only need pointers
+		resource := entry.Resource
+		if v := reflect.ValueOf(resource); v.Kind() != reflect.Ptr {
+			ptr := reflect.New(v.Type())
+			ptr.Elem().Set(v)
+			resource = ptr.Interface()
+		}
+		r.order = append(r.order, resource)
+		if entry.FullUrl != "" {
+			r.resources[entry.FullUrl] = resource
+		}
+		id := reflect.ValueOf(resource).Elem().FieldByName("Id").String()
+		if id != "" {
+			r.resources[reflect.TypeOf(resource).Elem().Name()+"/"+id] = resource
+			r.resources["urn:uuid:"+id] = resource
+		}
+	}
+
+	// Find the resources that belong to other entries, so they aren't converted on their own
+	for _, resource := range r.order {
+		switch t := resource.(type) {
+		case *fhir.Procedure:
+			for _, report := range r.procedureReports(t) {
+				r.used[report] = true
+				for _, observation := range r.reportResults(report) {
+					r.used[observation] = true
+				}
+			}
+		case *fhir.Observation:
+			for _, member := range r.members(t) {
+				r.used[member] = true
+			}
+		case *fhir.MedicationDispense:
+			for i := range t.AuthorizingPrescription {
+				if order, ok := r.resolve(&t.AuthorizingPrescription[i]).(*fhir.MedicationOrder); ok {
+					r.dispenses[order] = append(r.dispenses[order], t)
+					r.used[t] = true
+					break
+				}
+			}
+		}
+	}
+	return r
+}
+
+// resolve returns the resource the reference refers to, or nil if it isn't in the bundle
+func (r *bundleReader) resolve(ref *fhir.Reference) interface{} {
+	if ref == nil || ref.Reference == "" {
+		return nil
+	}
+	if resource, ok := r.resources[ref.Reference]; ok {
+		return resource
+	}
+	// Absolute references can still be found by their resource type and ID
+	parts := strings.Split(ref.Reference, "/")
+	if len(parts) >= 2 {
+		return r.resources[strings.Join(parts[len(parts)-2:], "/")]
+	}
+	return nil
+}
+
+// read converts the resource to an entry in the appropriate section of the patient
+func (r *bundleReader) read(resource interface{}) {
+	p := r.patient
+	switch t := resource.(type) {
+	case *fhir.Encounter:
+		p.Encounters = append(p.Encounters, r.readEncounter(t))
+	case *fhir.Condition:
+		condition := &Condition{}
+		condition.FromFHIR(t)
+		p.Conditions = append(p.Conditions, condition)
+	case *fhir.Observation:
+		r.readObservation(t)
+	case *fhir.Procedure:
+		p.Procedures = append(p.Procedures, r.readProcedure(t))
+	case *fhir.ProcedureRequest:
+		procedure := &Procedure{}
+		procedure.FromFHIRProcedureRequest(t)
+		p.Procedures = append(p.Procedures, procedure)
+	case *fhir.MedicationStatement:
+		medication := &Medication{}
+		medication.FromFHIRMedicationStatement(t)
+		p.Medications = append(p.Medications, medication)
+	case *fhir.MedicationOrder:
+		medication := &Medication{}
+		medication.FromFHIRMedicationOrder(t)
+		medication.PerformerID = r.performerID(t.Prescriber)
+		for _, dispense := range r.dispenses[t] {
+			fulfillment := &Fulfillment{}
+			fulfillment.FromFHIR(dispense)
+			medication.FulfillmentHistory = append(medication.FulfillmentHistory, fulfillment)
+		}
+		p.Medications = append(p.Medications, medication)
+	case *fhir.MedicationDispense:
+		medication := &Medication{}
+		medication.FromFHIRMedicationDispense(t)
+		p.Medications = append(p.Medications, medication)
+	case *fhir.MedicationAdministration:
+		medication := &Medication{}
+		medication.FromFHIRMedicationAdministration(t)
+		medication.PerformerID = r.performerID(t.Practitioner)
+		p.Medications = append(p.Medications, medication)
+	case *fhir.Immunization:
+		immunization := &Immunization{}
+		immunization.FromFHIR(t)
+		p.Immunizations = append(p.Immunizations, immunization)
+	case *fhir.AllergyIntolerance:
+		allergy := &Allergy{}
+		allergy.FromFHIR(t)
+		p.Allergies = append(p.Allergies, allergy)
+	case *fhir.Goal:
+		goal := &CareGoal{}
+		goal.FromFHIR(t)
+		p.CareGoals = append(p.CareGoals, goal)
+	case *fhir.DeviceUseStatement:
+		equipment := &MedicalEquipment{}
+		device, _ := r.resolve(t.Device).(*fhir.Device)
+		equipment.FromFHIR(t, device)
+		p.MedicalEquipment = append(p.MedicalEquipment, equipment)
+	case *fhir.Coverage:
+		insuranceProvider := &InsuranceProvider{}
+		payer, _ := r.resolve(t.Issuer).(*fhir.Organization)
+		insuranceProvider.FromFHIR(t, payer)
+		p.InsuranceProviders = append(p.InsuranceProviders, insuranceProvider)
+	case *fhir.DocumentReference:
+		// Advance directives are the only documents HDS has
+		if t.Class != nil && t.Class.MatchesCode("http://loinc.org", "42348-3") {
+			directive := &AdvanceDirective{}
+			directive.FromFHIRDocumentReference(t)
+			p.AdvanceDirectives = append(p.AdvanceDirectives, directive)
+		}
+	case *fhir.RelatedPerson:
+		support := &Support{}
+		support.FromFHIR(t)
+		p.Support = append(p.Support, support)
+	}
+}
+
+func (r *bundleReader) readEncounter(fhirEncounter *fhir.Encounter) *Encounter {
+	encounter := &Encounter{}
+	encounter.FromFHIR(fhirEncounter)
+	if len(fhirEncounter.Participant) > 0 {
+		encounter.PerformerID = r.performerID(fhirEncounter.Participant[0].Individual)
+	}
+	if hospitalization := fhirEncounter.Hospitalization; hospitalization != nil {
+		if origin, ok := r.resolve(hospitalization.Origin).(*fhir.Location); ok {
+			encounter.TransferFrom = &Transfer{}
+			encounter.TransferFrom.FromFHIR(origin)
+		}
+		if destination, ok := r.resolve(hospitalization.Destination).(*fhir.Location); ok {
+			encounter.TransferTo = &Transfer{}
+			encounter.TransferTo.FromFHIR(destination)
+		}
+	}
+	// The transfer locations are listed along with the facility, with the time of each transfer in their periods
+	for _, location := range fhirEncounter.Location {
+		fhirLocation, ok := r.resolve(location.Location).(*fhir.Location)
+		if !ok {
+			continue
+		}
+		switch {
+		case encounter.TransferFrom != nil && fhirLocation.Id == encounter.TransferFrom.GetTempID():
+			if location.Period != nil {
+				encounter.TransferFrom.Time = UnixTimeFromFHIR(location.Period.End)
+			}
+		case encounter.TransferTo != nil && fhirLocation.Id == encounter.TransferTo.GetTempID():
+			if location.Period != nil {
+				encounter.TransferTo.Time = UnixTimeFromFHIR(location.Period.Start)
+			}
+		case encounter.Facility == nil:
+			encounter.Facility = &Facility{}
+			encounter.Facility.FromFHIR(fhirLocation)
+			if location.Period != nil {
+				encounter.Facility.StartTime = UnixTimeFromFHIR(location.Period.Start)
+				encounter.Facility.EndTime = UnixTimeFromFHIR(location.Period.End)
+			}
+		}
+	}
+	return encounter
+}
+
+// readObservation uses the observation's category to decide which section it belongs in.  Observations without a
+// category HDS recognizes are treated as results.
+func (r *bundleReader) readObservation(observation *fhir.Observation) {
+	p := r.patient
+	switch {
+	case hasObservationCategory(observation, "vital-signs"):
+		vitalSign := &VitalSign{}
+		vitalSign.FromFHIR(observation)
+		if members := r.members(observation); len(members) > 0 {
+			vitalSign.SetTempID(observation.Id)
+			vitalSign.Values = make([]ResultValue, len(members))
+			for i, member := range members {
+				vitalSign.Values[i].FromFHIR(member)
+				if vitalSign.Interpretation == nil {
+					vitalSign.Interpretation = CodeObjectFromFHIR(member.Interpretation)
+				}
+			}
+		}
+		p.VitalSigns = append(p.VitalSigns, vitalSign)
+	case hasObservationCategory(observation, "social-history"):
+		socialHistory := &SocialHistory{}
+		socialHistory.FromFHIR(observation)
+		p.SocialHistory = append(p.SocialHistory, socialHistory)
+	case hasObservationCategory(observation, "functional-status"), hasObservationCategory(observation, "survey"):
+		functionalStatus := &FunctionalStatus{}
+		functionalStatus.FromFHIR(observation)
+		p.FunctionalStatuses = append(p.FunctionalStatuses, functionalStatus)
+	case hasObservationCategory(observation, "advance-directive"):
+		directive := &AdvanceDirective{}
+		directive.FromFHIRObservation(observation)
+		p.AdvanceDirectives = append(p.AdvanceDirectives, directive)
+	default:
+		result := &LabResult{}
+		result.FromFHIR(observation)
+		r.labResults[observation] = result
+		p.Results = append(p.Results, result)
+	}
+}
+
+func (r *bundleReader) readProcedure(fhirProcedure *fhir.Procedure) *Procedure {
+	procedure := &Procedure{}
+	procedure.FromFHIRProcedure(fhirProcedure)
+	if len(fhirProcedure.Performer) > 0 {
+		procedure.PerformerID = r.performerID(fhirProcedure.Performer[0].Actor)
+	}
+	for _, report := range r.procedureReports(fhirProcedure) {
+		procedure.report.SetTempID(report.Id)
+		results := r.reportResults(report)
+		procedure.Values = make([]ResultValue, len(results))
+		for i, observation := range results {
+			procedure.Values[i].FromFHIR(observation)
+		}
+	}
+	return procedure
+}
+
+// readLabReport sets the report ID and performer of the report's results.  Lab reports are recreated by grouping the
+// results, so the ID (which belongs to the first result) and the performer are the only things that need to be kept.
+func (r *bundleReader) readLabReport(report *fhir.DiagnosticReport) {
+	performerID := r.performerID(report.Performer)
+	for i, observation := range r.reportResults(report) {
+		result, ok := r.labResults[observation]
+		if !ok {
+			continue
+		}
+		if i == 0 {
+			result.report.SetTempID(report.Id)
+		}
+		if performerID != "" {
+			result.PerformerID = performerID
+		}
+	}
+}
+
+// readContact adds the patient contact to the support, unless the support already has the same person (since
+// emergency contacts are converted to both contacts and related people)
+func (r *bundleReader) readContact(contact *fhir.PatientContactComponent) {
+	fromContact := &Support{}
+	fromContact.FromFHIRPatientContact(contact)
+	for _, support := range r.patient.Support {
+		if support.GivenName == fromContact.GivenName && support.FamilyName == fromContact.FamilyName {
+			if fromContact.Type != "" {
+				support.Type = fromContact.Type
+			}
+			return
+		}
+	}
+	r.patient.Support = append(r.patient.Support, fromContact)
+}
+
+// provider returns the provider for the referenced practitioner, or nil if it isn't in the bundle
+func (r *bundleReader) provider(ref *fhir.Reference) *Provider {
+	practitioner, ok := r.resolve(ref).(*fhir.Practitioner)
+	if !ok {
+		return nil
+	}
+	if provider, ok := r.providers[practitioner]; ok {
+		return provider
+	}
+
+	provider := &Provider{}
+	provider.FromFHIR(practitioner)
+	if provider.ID == "" {
+		provider.ID = ObjectID(ref.Reference)
+	}
+	for _, role := range practitioner.PractitionerRole {
+		if organization, ok := r.resolve(role.ManagingOrganization).(*fhir.Organization); ok {
+			provider.Organization = &Organization{}
+			provider.Organization.FromFHIR(organization)
+		}
+	}
+	r.providers[practitioner] = provider
+	return provider
+}
+
+// performerID returns the HDS ID of the provider for the referenced practitioner, making sure the patient can find
+// the provider by that ID.  If the practitioner isn't in the bundle, an empty ID is returned.
+func (r *bundleReader) performerID(ref *fhir.Reference) ObjectID {
+	provider := r.provider(ref)
+	if provider == nil {
+		return ""
+	}
+	for _, performance := range r.patient.ProviderPerformances {
+		if performance.Provider == provider {
+			return provider.ID
+		}
+	}
+	for _, performer := range r.patient.performers {
+		if performer == provider {
+			return provider.ID
+		}
+	}
+	r.patient.performers = append(r.patient.performers, provider)
+	return provider.ID
+}
+
+// procedureReports returns the diagnostic reports for the procedure's results that are in the bundle
+func (r *bundleReader) procedureReports(fhirProcedure *fhir.Procedure) []*fhir.DiagnosticReport {
+	var reports []*fhir.DiagnosticReport
+	for i := range fhirProcedure.Report {
+		if report, ok := r.resolve(&fhirProcedure.Report[i]).(*fhir.DiagnosticReport); ok {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// reportResults returns the report's result observations that are in the bundle
+func (r *bundleReader) reportResults(report *fhir.DiagnosticReport) []*fhir.Observation {
+	var observations []*fhir.Observation
+	for i := range report.Result {
+		if observation, ok := r.resolve(&report.Result[i]).(*fhir.Observation); ok {
+			observations = append(observations, observation)
+		}
+	}
+	return observations
+}
+
+// members returns the observations that are members of the observation and are in the bundle
+func (r *bundleReader) members(observation *fhir.Observation) []*fhir.Observation {
+	var members []*fhir.Observation
+	for _, related := range observation.Related {
+		if related.Type != "has-member" {
+			continue
+		}
+		if member, ok := r.resolve(related.Target).(*fhir.Observation); ok {
+			members = append(members, member)
+		}
+	}
+	return members
+}
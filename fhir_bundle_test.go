@@ -0,0 +1,129 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type FHIRBundleSuite struct {
+}
+
+var _ = Suite(&FHIRBundleSuite{})
+
+func (s *FHIRBundleSuite) loadPatient(path string) *Patient {
+	data, err := ioutil.ReadFile(path)
+	util.CheckErr(err)
+
+	patient := &Patient{}
+	err = json.Unmarshal(data, patient)
+	util.CheckErr(err)
+	return patient
+}
+
+// roundTrip converts the patient to a bundle, serializes it as JSON, and converts it back
+func (s *FHIRBundleSuite) roundTrip(c *C, patient *Patient) *Patient {
+	data, err := json.Marshal(patient.FHIRTransactionBundle(false))
+	util.CheckErr(err)
+	bundle := &fhir.Bundle{}
+	err = json.Unmarshal(data, bundle)
+	util.CheckErr(err)
+
+	restored, err := FromFHIRBundle(bundle)
+	c.Assert(err, IsNil)
+	return restored
+}
+
+func (s *FHIRBundleSuite) fullURLs(patient *Patient) []string {
+	var urls []string
+	for _, entry := range patient.FHIRTransactionBundle(false).Entry {
+		urls = append(urls, reflect.TypeOf(entry.Resource).Elem().Name()+" "+entry.FullUrl)
+	}
+	return urls
+}
+
+func (s *FHIRBundleSuite) TestRoundTrip(c *C) {
+	patient := s.loadPatient("./fixtures/john_peters.json")
+	restored := s.roundTrip(c, patient)
+	c.Assert(s.fullURLs(restored), DeepEquals, s.fullURLs(patient))
+}
+
+func (s *FHIRBundleSuite) TestRoundTripProviders(c *C) {
+	patient := s.loadPatient("./fixtures/providers.json")
+	restored := s.roundTrip(c, patient)
+	c.Assert(s.fullURLs(restored), DeepEquals, s.fullURLs(patient))
+}
+
+func (s *FHIRBundleSuite) TestRoundTripPatient(c *C) {
+	patient := s.loadPatient("./fixtures/john_peters.json")
+	restored := s.roundTrip(c, patient)
+
+	c.Assert(restored.GetTempID(), Equals, patient.GetTempID())
+	c.Assert(restored.MedicalRecordNumber, Equals, patient.MedicalRecordNumber)
+	c.Assert(restored.FirstName, Equals, patient.FirstName)
+	c.Assert(restored.LastName, Equals, patient.LastName)
+	c.Assert(restored.Gender, Equals, patient.Gender)
+	// FHIR birth dates have no time, so only the date survives
+	c.Assert(restored.BirthTime.FHIRDate().Time.Format("2006-01-02"), Equals, patient.BirthTime.FHIRDate().Time.Format("2006-01-02"))
+	c.Assert(restored.Encounters, HasLen, len(patient.Encounters))
+	c.Assert(restored.Conditions, HasLen, len(patient.Conditions))
+	c.Assert(restored.VitalSigns, HasLen, len(patient.VitalSigns))
+	c.Assert(restored.Procedures, HasLen, len(patient.Procedures))
+	c.Assert(restored.Allergies, HasLen, len(patient.Allergies))
+	c.Assert(restored.MedicalEquipment, HasLen, len(patient.MedicalEquipment))
+
+	for i, condition := range restored.Conditions {
+		c.Assert(condition.Patient, Equals, restored)
+		c.Assert(condition.Codes, DeepEquals, patient.Conditions[i].Codes)
+		c.Assert(condition.StartTime, DeepEquals, patient.Conditions[i].StartTime)
+	}
+	for _, encounter := range restored.Encounters {
+		c.Assert(encounter.Patient, Equals, restored)
+	}
+	c.Assert(restored.VitalSigns[0].Values, HasLen, 1)
+	c.Assert(restored.VitalSigns[0].Values[0].Physical.Scalar, Equals, "8")
+	c.Assert(restored.VitalSigns[0].Values[0].Physical.Unit, Equals, "%")
+}
+
+func (s *FHIRBundleSuite) TestRoundTripPerformers(c *C) {
+	patient := s.loadPatient("./fixtures/providers.json")
+	restored := s.roundTrip(c, patient)
+
+	c.Assert(restored.ProviderPerformances, HasLen, len(patient.ProviderPerformances))
+	c.Assert(restored.Encounters[0].PerformerID, Not(Equals), ObjectID(""))
+	c.Assert(restored.Providers(), HasLen, len(patient.Providers()))
+}
+
+func (s *FHIRBundleSuite) TestRoundTripTransfers(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/encounters.json")
+	util.CheckErr(err)
+	var encounters map[string]*Encounter
+	util.CheckErr(json.Unmarshal(data, &encounters))
+	patient := s.loadPatient("./fixtures/john_peters.json")
+	patient.Encounters = []*Encounter{encounters["transferredInpatientEncounter"]}
+	patient.linkEntries()
+
+	restored := s.roundTrip(c, patient)
+	c.Assert(s.fullURLs(restored), DeepEquals, s.fullURLs(patient))
+	encounter := restored.Encounters[0]
+	c.Assert(encounter.Facility.Name, Equals, "General Hospital")
+	c.Assert(encounter.TransferFrom.Time, DeepEquals, NewUnixTime(1320148800))
+	c.Assert(encounter.TransferFrom.Codes, DeepEquals, CodeMap{"SNOMED-CT": []string{"309911002"}})
+	c.Assert(encounter.TransferTo.Time, DeepEquals, NewUnixTime(1320321600))
+}
+
+func (s *FHIRBundleSuite) TestNoPatient(c *C) {
+	bundle := &fhir.Bundle{Entry: []fhir.BundleEntryComponent{{Resource: &fhir.Condition{}}}}
+	_, err := FromFHIRBundle(bundle)
+	c.Assert(err, NotNil)
+}
+
+func (s *FHIRBundleSuite) TestMultiplePatients(c *C) {
+	bundle := &fhir.Bundle{Entry: []fhir.BundleEntryComponent{{Resource: &fhir.Patient{}}, {Resource: &fhir.Patient{}}}}
+	_, err := FromFHIRBundle(bundle)
+	c.Assert(err, NotNil)
+}
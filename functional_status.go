@@ -0,0 +1,44 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type FunctionalStatus struct {
+	Entry
+	// Type is "condition" for a functional status itself or "result" for the result of a functional assessment
+	Type   string        `json:"type"`
+	Values []ResultValue `json:"values"`
+}
+
+func (f *FunctionalStatus) FHIRModels() []interface{} {
+	observations := valueObservations(&f.Entry, f.Values)
+	models := make([]interface{}, len(observations))
+	for i, observation := range observations {
+		observation.Category = f.convertCategory()
+		if f.NegationInd {
+			observation.Status = "cancelled"
+		}
+		models[i] = observation
+	}
+
+	return models
+}
+
+// FromFHIR sets the functional status from the FHIR observation.  It is the inverse of FHIRModels.
+func (f *FunctionalStatus) FromFHIR(observation *fhir.Observation) {
+	f.Values = f.setFHIRObservation(observation)
+	f.NegationInd = observation.Status == "cancelled"
+	if hasObservationCategory(observation, "survey") {
+		f.Type = "result"
+	} else {
+		f.Type = "condition"
+	}
+}
+
+// convertCategory uses the functional status type to choose the observation category.  Assessment results are
+// categorized as surveys, and everything else as a functional status.
+func (f *FunctionalStatus) convertCategory() *fhir.CodeableConcept {
+	if f.Type == "result" {
+		return observationCategory("survey", "Survey")
+	}
+	return observationCategory("functional-status", "Functional Status")
+}
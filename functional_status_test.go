@@ -0,0 +1,74 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type FunctionalStatusSuite struct {
+	Patient            *Patient
+	FunctionalStatuses map[string]*FunctionalStatus
+	Encounter          *Encounter
+}
+
+var _ = Suite(&FunctionalStatusSuite{})
+
+func (s *FunctionalStatusSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/functional_statuses.json")
+	util.CheckErr(err)
+
+	s.FunctionalStatuses = make(map[string]*FunctionalStatus)
+	err = json.Unmarshal(data, &s.FunctionalStatuses)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	s.Encounter = &Encounter{Entry: Entry{StartTime: NewUnixTime(1320148800), EndTime: NewUnixTime(1320152400)}}
+	s.Patient.Encounters = []*Encounter{s.Encounter}
+	for _, functionalStatus := range s.FunctionalStatuses {
+		functionalStatus.Patient = s.Patient
+	}
+}
+
+func (s *FunctionalStatusSuite) TestFunctionalStatusCondition(c *C) {
+	models := s.FunctionalStatuses["ambulationDifficulty"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Status, Equals, "final")
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "functional-status"), Equals, true)
+	c.Assert(observation.Code.Text, Equals, "Functional Status, Performed: Walking disability")
+	c.Assert(observation.Code.MatchesCode("http://snomed.info/sct", "228158008"), Equals, true)
+	c.Assert(observation.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(observation.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(observation.ValueQuantity, IsNil)
+	c.Assert(observation.ValueCodeableConcept, IsNil)
+}
+
+func (s *FunctionalStatusSuite) TestPhysicalResult(c *C) {
+	models := s.FunctionalStatuses["phq9"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "survey"), Equals, true)
+	c.Assert(observation.Code.MatchesCode("http://loinc.org", "44261-6"), Equals, true)
+	c.Assert(*observation.ValueQuantity.Value, Equals, float64(12))
+	c.Assert(observation.Encounter, IsNil)
+	c.Assert(observation.EffectivePeriod.Start, DeepEquals, NewUnixTime(1362239100).FHIRDateTime())
+}
+
+func (s *FunctionalStatusSuite) TestCodedResult(c *C) {
+	models := s.FunctionalStatuses["adlAssessment"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "survey"), Equals, true)
+	c.Assert(observation.Code.MatchesCode("http://snomed.info/sct", "273547007"), Equals, true)
+	c.Assert(observation.ValueCodeableConcept.Text, Equals, "Independent")
+	c.Assert(observation.ValueCodeableConcept.MatchesCode("http://snomed.info/sct", "371153006"), Equals, true)
+	c.Assert(observation.Encounter, DeepEquals, s.Encounter.FHIRReference())
+}
@@ -0,0 +1,137 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// IDRegistry remembers the server resources created for the resources converted from HDS patients, so that
+// converting a patient again can update them directly instead of relying on conditional updates to find them.
+//
+// Each resource is registered with a key based on the HDS ID of the entry it was converted from (or of the provider,
+// for practitioners and their organizations).  Entries without HDS IDs, and the patient itself, are keyed by a
+// fingerprint of the entry instead: the patient's medical record number, the entry's section and position, and its
+// codes and times.  Since the fingerprint changes when any of those do, an edited entry without an HDS ID is treated
+// as a new one.
+type IDRegistry interface {
+	// Lookup returns the server location registered for the key, if there is one
+	Lookup(key string) (ResourceLocation, bool)
+	// Register records the server location for the key, replacing any previous location
+	Register(key string, location ResourceLocation) error
+}
+
+// registryKey returns the key the resource is registered with in an ID registry
+func (r *identifiedResource) registryKey() string {
+	if r.hdsID != "" {
+		return string(r.hdsID) + r.path
+	}
+	return r.name
+}
+
+// RegisterLocations registers the server location of each resource converted from the patient.  The locations are
+// keyed by the full URLs of the resources (e.g., "urn:uuid:..."), as returned by TransactionResponseLocations, so the
+// patient's temporary IDs must not have changed since the resources were converted.
+func (p *Patient) RegisterLocations(registry IDRegistry, locations map[string]ResourceLocation) error {
+	for _, resource := range p.identifiedResources() {
+		if location, ok := locations["urn:uuid:"+resource.id.GetTempID()]; ok {
+			if err := registry.Register(resource.registryKey(), location); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// registeredLocations looks up the patient's resources in the registry, and returns the locations of the registered
+// ones keyed by their full URLs
+func (p *Patient) registeredLocations(registry IDRegistry) map[string]ResourceLocation {
+	locations := make(map[string]ResourceLocation)
+	for _, resource := range p.identifiedResources() {
+		if location, ok := registry.Lookup(resource.registryKey()); ok {
+			locations["urn:uuid:"+resource.id.GetTempID()] = location
+		}
+	}
+	return locations
+}
+
+// FileIDRegistry is an ID registry kept in memory and saved to a JSON file, which maps each key to the location of
+// the resource, e.g.:
+//
+//	{"5697d8b2c1c1b1a2b3000020": "Condition/123/_history/2"}
+//
+// Registered locations are only written to the file when Save is called.  It is safe for concurrent use.
+type FileIDRegistry struct {
+	path      string
+	mu        sync.Mutex
+	locations map[string]ResourceLocation
+}
+
+// OpenFileIDRegistry loads the registry from the file at the path.  If the file doesn't exist, the registry starts
+// out empty, and the file is created when the registry is saved.
+func OpenFileIDRegistry(path string) (*FileIDRegistry, error) {
+	r := &FileIDRegistry{path: path, locations: make(map[string]ResourceLocation)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var locations map[string]string
+	if err := json.Unmarshal(data, &locations); err != nil {
+		return nil, err
+	}
+	for key, location := range locations {
+		if r.locations[key], err = ParseResourceLocation(location); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *FileIDRegistry) Lookup(key string) (ResourceLocation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	location, ok := r.locations[key]
+	return location, ok
+}
+
+func (r *FileIDRegistry) Register(key string, location ResourceLocation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.locations[key] = location
+	return nil
+}
+
+// Save writes the registry to its file.  The file is replaced atomically, so it is never left partly written.
+func (r *FileIDRegistry) Save() error {
+	r.mu.Lock()
+	locations := make(map[string]string, len(r.locations))
+	for key, location := range r.locations {
+		locations[key] = location.String()
+	}
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(locations, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type IDRegistrySuite struct {
+	Path string
+}
+
+var _ = Suite(&IDRegistrySuite{})
+
+func (s *IDRegistrySuite) SetUpTest(c *C) {
+	s.Path = filepath.Join(c.MkDir(), "ids.json")
+}
+
+func (s *IDRegistrySuite) loadPatient() *Patient {
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+
+	patient := &Patient{}
+	err = json.Unmarshal(data, patient)
+	util.CheckErr(err)
+	return patient
+}
+
+// upload converts the patient with the registry and registers the resources as if a server had created each one
+// with a sequential ID.  It returns the transaction bundle.
+func (s *IDRegistrySuite) upload(c *C, patient *Patient, registry *FileIDRegistry) *fhir.Bundle {
+	result, err := patient.Convert(ConversionOptions{IDRegistry: registry})
+	c.Assert(err, IsNil)
+	bundle := result.FHIRTransactionBundle(false)
+
+	response := &fhir.Bundle{Type: "transaction-response"}
+	for i, entry := range bundle.Entry {
+		location := fmt.Sprintf("%s/%d/_history/1", reflect.TypeOf(entry.Resource).Elem().Name(), i+1)
+		if entry.Request.Method == "PUT" {
+			location = entry.Request.Url + "/_history/2"
+		}
+		response.Entry = append(response.Entry, fhir.BundleEntryComponent{
+			Response: &fhir.BundleEntryResponseComponent{Status: "201 Created", Location: location},
+		})
+	}
+	locations, err := TransactionResponseLocations(bundle, response)
+	c.Assert(err, IsNil)
+	c.Assert(patient.RegisterLocations(registry, locations), IsNil)
+	return bundle
+}
+
+func (s *IDRegistrySuite) TestReimport(c *C) {
+	registry, err := OpenFileIDRegistry(s.Path)
+	c.Assert(err, IsNil)
+	first := s.upload(c, s.loadPatient(), registry)
+	for _, entry := range first.Entry {
+		c.Assert(entry.Request.Method, Equals, "POST")
+	}
+	c.Assert(registry.Save(), IsNil)
+
+	// Reopening the registry and converting the same patient again updates every resource
+	registry, err = OpenFileIDRegistry(s.Path)
+	c.Assert(err, IsNil)
+	patient := s.loadPatient()
+	result, err := patient.Convert(ConversionOptions{IDRegistry: registry})
+	c.Assert(err, IsNil)
+	second := result.FHIRTransactionBundle(false)
+	c.Assert(second.Entry, HasLen, len(first.Entry))
+	for i, entry := range second.Entry {
+		c.Assert(entry.Request.Method, Equals, "PUT")
+		c.Assert(entry.Request.Url, Equals, fmt.Sprintf("%s/%d", reflect.TypeOf(entry.Resource).Elem().Name(), i+1))
+		c.Assert(reflect.ValueOf(entry.Resource).Elem().FieldByName("Id").String(), Equals, fmt.Sprintf("%d", i+1))
+		// The full URL and the converted model keep the temporary ID
+		c.Assert(entry.FullUrl, Not(Equals), first.Entry[i].FullUrl)
+		c.Assert(entry.FullUrl, Equals, "urn:uuid:"+reflect.ValueOf(result.Models[i]).Elem().FieldByName("Id").String())
+	}
+}
+
+func (s *IDRegistrySuite) TestNewEntriesAreCreated(c *C) {
+	registry, err := OpenFileIDRegistry(s.Path)
+	c.Assert(err, IsNil)
+	s.upload(c, s.loadPatient(), registry)
+
+	patient := s.loadPatient()
+	patient.Conditions = append(patient.Conditions, &Condition{Entry: Entry{
+		Patient:   patient,
+		ID:        "5697d8b2c1c1b1a2b3000030",
+		Codes:     CodeMap{"SNOMED-CT": []string{"44054006"}},
+		StartTime: NewUnixTime(1330605000),
+	}})
+	bundle := s.upload(c, patient, registry)
+
+	methods := make(map[string]int)
+	for _, entry := range bundle.Entry {
+		methods[entry.Request.Method]++
+		if _, ok := entry.Resource.(*fhir.Condition); ok && entry.Request.Method == "POST" {
+			c.Assert(entry.FullUrl, Equals, "urn:uuid:"+patient.Conditions[len(patient.Conditions)-1].GetTempID())
+		}
+	}
+	c.Assert(methods["POST"], Equals, 1)
+
+	// Once it's registered, the new condition is found by its HDS ID
+	location, ok := registry.Lookup("5697d8b2c1c1b1a2b3000030")
+	c.Assert(ok, Equals, true)
+	c.Assert(location.ResourceType, Equals, "Condition")
+}
+
+func (s *IDRegistrySuite) TestConditionalUpdatesForUnregisteredResources(c *C) {
+	registry, err := OpenFileIDRegistry(s.Path)
+	c.Assert(err, IsNil)
+	patient := s.loadPatient()
+	util.CheckErr(registry.Register(patient.patientName(), ResourceLocation{ResourceType: "Patient", ID: "42"}))
+
+	result, err := patient.Convert(ConversionOptions{IDRegistry: registry})
+	c.Assert(err, IsNil)
+	bundle := result.FHIRTransactionBundle(true)
+	c.Assert(bundle.Entry[0].Request.Url, Equals, "Patient/42")
+	c.Assert(strings.HasPrefix(bundle.Entry[1].Request.Url, "Encounter?"), Equals, true)
+}
+
+func (s *IDRegistrySuite) TestMismatchedTypeIsCreated(c *C) {
+	registry, err := OpenFileIDRegistry(s.Path)
+	c.Assert(err, IsNil)
+	patient := s.loadPatient()
+	util.CheckErr(registry.Register(patient.patientName(), ResourceLocation{ResourceType: "Person", ID: "42"}))
+
+	result, err := patient.Convert(ConversionOptions{IDRegistry: registry})
+	c.Assert(err, IsNil)
+	bundle := result.FHIRTransactionBundle(false)
+	c.Assert(bundle.Entry[0].Request.Method, Equals, "POST")
+	c.Assert(bundle.Entry[0].Request.Url, Equals, "Patient")
+}
+
+func (s *IDRegistrySuite) TestFile(c *C) {
+	registry, err := OpenFileIDRegistry(s.Path)
+	c.Assert(err, IsNil)
+	_, ok := registry.Lookup("5697d8b2c1c1b1a2b3000020")
+	c.Assert(ok, Equals, false)
+
+	util.CheckErr(registry.Register("5697d8b2c1c1b1a2b3000020", ResourceLocation{ResourceType: "Condition", ID: "123", VersionID: "2"}))
+	c.Assert(registry.Save(), IsNil)
+	data, err := ioutil.ReadFile(s.Path)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "{\n  \"5697d8b2c1c1b1a2b3000020\": \"Condition/123/_history/2\"\n}")
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(s.Path), "*"))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+
+	util.CheckErr(ioutil.WriteFile(s.Path, []byte(`{"5697d8b2c1c1b1a2b3000020": "urn:uuid:123"}`), 0644))
+	_, err = OpenFileIDRegistry(s.Path)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,215 @@
+package hdsfhir
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+// IDStrategy generates the IDs that identify converted resources (and the references between them) in a bundle
+type IDStrategy interface {
+	// NewID returns an ID for the resource with the given name.  The name identifies the resource within the HDS
+	// patient record, so strategies that need reproducible IDs can derive the ID from it.
+	NewID(name string) string
+}
+
+// RandomIDs generates random (version 4) UUIDs, ignoring the resource names.  This is the default strategy.
+type RandomIDs struct{}
+
+func (RandomIDs) NewID(name string) string {
+	return uuid.NewV4().String()
+}
+
+// DefaultIDNamespace is the namespace used for name-based IDs when no other namespace is specified
+var DefaultIDNamespace = uuid.NewV5(uuid.NamespaceURL, "http://github.com/intervention-engine/hdsfhir")
+
+// NameBasedIDs generates name-based (version 5) UUIDs, so converting the same HDS patient again results in the same
+// IDs.  Since the names are derived from the medical record number and the position, codes, and times of each entry,
+// the IDs change if the entries are reordered or edited.
+type NameBasedIDs struct {
+	// Namespace scopes the generated IDs, so that different sources can convert the same records without colliding.
+	// If it isn't set, DefaultIDNamespace is used.
+	Namespace uuid.UUID
+}
+
+func (n NameBasedIDs) NewID(name string) string {
+	namespace := n.Namespace
+	if uuid.Equal(namespace, uuid.Nil) {
+		namespace = DefaultIDNamespace
+	}
+	return uuid.NewV5(namespace, name).String()
+}
+
+// AssignIDs sets the IDs of the patient and of every resource converted from it, including the ones that have no
+// HDS counterpart (e.g., the diagnostic report for procedure results), using the given strategy.  Any IDs already
+// assigned or generated are replaced.
+func (p *Patient) AssignIDs(strategy IDStrategy) {
+	for _, resource := range p.identifiedResources() {
+		resource.id.SetTempID(strategy.NewID(resource.name))
+	}
+}
+
+// patientName names the patient, preferably by medical record number
+func (p *Patient) patientName() string {
+	if p.MedicalRecordNumber != "" {
+		return p.MedicalRecordNumber
+	}
+	// Fall back to the demographics, which are the next best thing for telling patients apart
+	name := p.FirstName + " " + p.LastName
+	if p.BirthTime != nil {
+		name += " " + strconv.FormatInt(int64(*p.BirthTime), 10)
+	}
+	return name
+}
+
+// entryName names the entry based on the patient, the section and index of the entry, and the entry's codes and
+// times
+func entryName(patientName string, key EntryKey, e *Entry) string {
+	var codes []string
+	for codeSystem, systemCodes := range e.Codes {
+		for _, code := range systemCodes {
+			codes = append(codes, codeSystem+"|"+code)
+		}
+	}
+	sort.Strings(codes)
+
+	var times []string
+	for _, t := range []*UnixTime{e.StartTime, e.EndTime, e.Time} {
+		if t != nil {
+			times = append(times, strconv.FormatInt(int64(*t), 10))
+		} else {
+			times = append(times, "")
+		}
+	}
+
+	return fmt.Sprintf("%s/%s[%d]/%s@%s", patientName, key.Section, key.Index, strings.Join(codes, ","), strings.Join(times, "-"))
+}
+
+// identifiedResource is the ID of a resource converted from the patient, along with the part of the HDS record it
+// was converted from
+type identifiedResource struct {
+	key EntryKey
+	// hdsID is the HDS ID of the entry or provider, if it has one
+	hdsID ObjectID
+	// entry is the HDS entry, which is nil for the patient and providers
+	entry *Entry
+	// path distinguishes the resource from the others converted from the same entry (e.g., "/report").  It is empty
+	// for the resource that has the entry's own ID.
+	path string
+	// name identifies the resource within the HDS patient record, based on the patient, the section and index of the
+	// entry, the entry's codes and times, and the path
+	name string
+	id   *TemporallyIdentified
+}
+
+// identifiedResources returns the IDs of the patient and of every resource converted from it, with the ID of each
+// entry's own resource before the IDs of its other resources
+func (p *Patient) identifiedResources() []identifiedResource {
+	patientName := p.patientName()
+	names := make(map[EntryKey]string)
+	var resources []identifiedResource
+	add := func(key EntryKey, hdsID ObjectID, e *Entry, path string, id *TemporallyIdentified) {
+		name, ok := names[key]
+		if !ok {
+			switch {
+			case key.Section == "":
+				name = patientName
+			case e == nil:
+				name = fmt.Sprintf("%s/%s[%d]/%s", patientName, key.Section, key.Index, hdsID)
+			default:
+				name = entryName(patientName, key, e)
+			}
+			names[key] = name
+		}
+		resources = append(resources, identifiedResource{key: key, hdsID: hdsID, entry: e, path: path, name: name + path, id: id})
+	}
+	entry := func(section string, index int, e *Entry) EntryKey {
+		key := EntryKey{Section: section, Index: index}
+		add(key, e.ID, e, "", &e.TemporallyIdentified)
+		return key
+	}
+	values := func(key EntryKey, e *Entry, values []ResultValue) {
+		for i := range values {
+			add(key, e.ID, e, fmt.Sprintf("/values[%d]", i), &values[i].TemporallyIdentified)
+		}
+	}
+
+	add(EntryKey{}, "", nil, "", &p.TemporallyIdentified)
+	for i, provider := range p.Providers() {
+		key := EntryKey{Section: "providers", Index: i}
+		add(key, provider.ID, nil, "", &provider.TemporallyIdentified)
+		if provider.Organization != nil {
+			add(key, provider.ID, nil, "/organization", &provider.Organization.TemporallyIdentified)
+		}
+	}
+	for i, encounter := range p.Encounters {
+		key := entry("encounters", i, &encounter.Entry)
+		if encounter.Facility != nil && encounter.Facility.sharedWith == nil {
+			add(key, encounter.ID, &encounter.Entry, "/facility", &encounter.Facility.TemporallyIdentified)
+		}
+		if encounter.TransferFrom != nil {
+			add(key, encounter.ID, &encounter.Entry, "/transfer_from", &encounter.TransferFrom.TemporallyIdentified)
+		}
+		if encounter.TransferTo != nil {
+			add(key, encounter.ID, &encounter.Entry, "/transfer_to", &encounter.TransferTo.TemporallyIdentified)
+		}
+	}
+	for i, condition := range p.Conditions {
+		entry("conditions", i, &condition.Entry)
+	}
+	for i, vitalSign := range p.VitalSigns {
+		values(entry("vital_signs", i, &vitalSign.Entry), &vitalSign.Entry, vitalSign.Values)
+	}
+	for i, procedure := range p.Procedures {
+		key := entry("procedures", i, &procedure.Entry)
+		add(key, procedure.ID, &procedure.Entry, "/report", &procedure.report)
+		values(key, &procedure.Entry, procedure.Values)
+	}
+	for i, medication := range p.Medications {
+		key := entry("medications", i, &medication.Entry)
+		for j, fulfillment := range medication.FulfillmentHistory {
+			add(key, medication.ID, &medication.Entry, fmt.Sprintf("/fulfillment_history[%d]", j), &fulfillment.TemporallyIdentified)
+		}
+	}
+	for i, immunization := range p.Immunizations {
+		entry("immunizations", i, &immunization.Entry)
+	}
+	for i, allergy := range p.Allergies {
+		entry("allergies", i, &allergy.Entry)
+	}
+	for i, result := range p.Results {
+		key := entry("results", i, &result.Entry)
+		add(key, result.ID, &result.Entry, "/report", &result.report)
+		values(key, &result.Entry, result.Values)
+	}
+	for i, socialHistory := range p.SocialHistory {
+		values(entry("social_history", i, &socialHistory.Entry), &socialHistory.Entry, socialHistory.Values)
+	}
+	for i, goal := range p.CareGoals {
+		entry("care_goals", i, &goal.Entry)
+	}
+	for i, equipment := range p.MedicalEquipment {
+		key := entry("medical_equipment", i, &equipment.Entry)
+		add(key, equipment.ID, &equipment.Entry, "/device", &equipment.device)
+	}
+	for i, insuranceProvider := range p.InsuranceProviders {
+		key := entry("insurance_providers", i, &insuranceProvider.Entry)
+		if insuranceProvider.sharedPayerWith != nil {
+			continue
+		}
+		add(key, insuranceProvider.ID, &insuranceProvider.Entry, "/payer", insuranceProvider.payerID())
+	}
+	for i, directive := range p.AdvanceDirectives {
+		entry("advance_directives", i, &directive.Entry)
+	}
+	for i, functionalStatus := range p.FunctionalStatuses {
+		values(entry("functional_statuses", i, &functionalStatus.Entry), &functionalStatus.Entry, functionalStatus.Values)
+	}
+	for i, support := range p.Support {
+		entry("support", i, &support.Entry)
+	}
+	return resources
+}
@@ -0,0 +1,119 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	"github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+type IDStrategySuite struct {
+}
+
+var _ = Suite(&IDStrategySuite{})
+
+func (s *IDStrategySuite) loadPatient() *Patient {
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+
+	patient := &Patient{}
+	err = json.Unmarshal(data, patient)
+	util.CheckErr(err)
+	return patient
+}
+
+func (s *IDStrategySuite) fullURLs(c *C, patient *Patient, opts ConversionOptions) []string {
+	result, err := patient.Convert(opts)
+	c.Assert(err, IsNil)
+
+	var urls []string
+	for _, entry := range result.FHIRTransactionBundle(false).Entry {
+		urls = append(urls, entry.FullUrl)
+	}
+	return urls
+}
+
+func (s *IDStrategySuite) TestNameBasedIDs(c *C) {
+	ids := NameBasedIDs{}
+	id := ids.NewID("12345/conditions[0]")
+	parsed, err := uuid.FromString(id)
+	c.Assert(err, IsNil)
+	c.Assert(parsed.Version(), Equals, uint(5))
+	c.Assert(ids.NewID("12345/conditions[0]"), Equals, id)
+	c.Assert(ids.NewID("12345/conditions[1]"), Not(Equals), id)
+
+	other := NameBasedIDs{Namespace: uuid.NamespaceOID}
+	c.Assert(other.NewID("12345/conditions[0]"), Not(Equals), id)
+}
+
+func (s *IDStrategySuite) TestRandomIDs(c *C) {
+	id := RandomIDs{}.NewID("12345/conditions[0]")
+	parsed, err := uuid.FromString(id)
+	c.Assert(err, IsNil)
+	c.Assert(parsed.Version(), Equals, uint(4))
+	c.Assert(RandomIDs{}.NewID("12345/conditions[0]"), Not(Equals), id)
+}
+
+func (s *IDStrategySuite) TestConversionIsReproducible(c *C) {
+	opts := ConversionOptions{IDStrategy: NameBasedIDs{}}
+	first := s.fullURLs(c, s.loadPatient(), opts)
+	second := s.fullURLs(c, s.loadPatient(), opts)
+	c.Assert(first, DeepEquals, second)
+
+	seen := make(map[string]bool)
+	for _, url := range first {
+		c.Assert(seen[url], Equals, false)
+		seen[url] = true
+	}
+}
+
+func (s *IDStrategySuite) TestDefaultConversionIsRandom(c *C) {
+	first := s.fullURLs(c, s.loadPatient(), ConversionOptions{})
+	second := s.fullURLs(c, s.loadPatient(), ConversionOptions{})
+	c.Assert(first, HasLen, len(second))
+	for i := range first {
+		c.Assert(first[i], Not(Equals), second[i])
+	}
+}
+
+func (s *IDStrategySuite) TestInternalIDs(c *C) {
+	patient := s.loadPatient()
+	var procedure *Procedure
+	for _, p := range patient.Procedures {
+		if len(p.Values) > 0 {
+			procedure = p
+		}
+	}
+	c.Assert(procedure, NotNil)
+
+	patient.AssignIDs(NameBasedIDs{})
+	var report *fhir.DiagnosticReport
+	for _, model := range procedure.FHIRModels() {
+		if r, ok := model.(*fhir.DiagnosticReport); ok {
+			report = r
+		}
+	}
+	c.Assert(report, NotNil)
+	c.Assert(report.Id, Not(Equals), procedure.GetTempID())
+	c.Assert(report.Id, Equals, procedure.report.GetTempID())
+
+	// Reassigning the IDs gives the same report ID, even though it has no HDS counterpart
+	reportID := report.Id
+	procedure.report.SetTempID(RandomIDs{}.NewID(""))
+	patient.AssignIDs(NameBasedIDs{})
+	c.Assert(procedure.report.GetTempID(), Equals, reportID)
+}
+
+func (s *IDStrategySuite) TestPatientWithoutMedicalRecordNumber(c *C) {
+	patient := s.loadPatient()
+	patient.MedicalRecordNumber = ""
+	patient.AssignIDs(NameBasedIDs{})
+	id := patient.GetTempID()
+
+	patient.FirstName = "Jonathan"
+	patient.AssignIDs(NameBasedIDs{})
+	c.Assert(patient.GetTempID(), Not(Equals), id)
+}
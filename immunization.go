@@ -36,3 +36,17 @@ func (i *Immunization) FHIRModels() []interface{} {
 
 	return []interface{}{fhirImmunization}
 }
+
+// FromFHIR sets the immunization from the FHIR immunization.  It is the inverse of FHIRModels.
+func (i *Immunization) FromFHIR(fhirImmunization *fhir.Immunization) {
+	i.SetTempID(fhirImmunization.Id)
+	i.Time = UnixTimeFromFHIR(fhirImmunization.Date)
+	i.setFHIRCodes(fhirImmunization.VaccineCode)
+	i.NegationInd = fhirImmunization.WasNotGiven != nil && *fhirImmunization.WasNotGiven
+	if explanation := fhirImmunization.Explanation; explanation != nil && len(explanation.ReasonNotGiven) > 0 {
+		i.NegationReason = CodeObjectFromFHIR(&explanation.ReasonNotGiven[0])
+	}
+	if len(fhirImmunization.VaccinationProtocol) > 0 {
+		i.SeriesNumber = fhirImmunization.VaccinationProtocol[0].DoseSequence
+	}
+}
@@ -0,0 +1,155 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type InsuranceProvider struct {
+	Entry
+	Name                        string        `json:"name"`
+	Type                        string        `json:"type"`
+	MemberID                    string        `json:"member_id"`
+	Relationship                *CodeObject   `json:"relationship"`
+	FinancialResponsibilityType *CodeObject   `json:"financial_responsibility_type"`
+	Payer                       *Organization `json:"payer"`
+	// generatedPayer identifies the payer made up when there isn't one (see payer)
+	generatedPayer TemporallyIdentified
+	// sharedPayerWith is an earlier insurance provider with a payer of the same name, whose payer organization this
+	// one shares (see Patient.linkPayers)
+	sharedPayerWith *InsuranceProvider
+}
+
+// subscriberRelationshipExtensionURL identifies the extension used to carry the relationship of the patient to the
+// subscriber, since DSTU2 Coverage has no element for it
+const subscriberRelationshipExtensionURL = "http://github.com/intervention-engine/hdsfhir/StructureDefinition/subscriber-relationship"
+
+// beneficiaryExtensionURL identifies the extension used to refer to the patient from coverages where the patient isn't
+// the subscriber (e.g., a spouse's plan), since DSTU2 Coverage only refers to the subscriber
+const beneficiaryExtensionURL = "http://github.com/intervention-engine/hdsfhir/StructureDefinition/beneficiary"
+
+func (i *InsuranceProvider) FHIRModels() []interface{} {
+	fhirCoverage := &fhir.Coverage{}
+	fhirCoverage.Id = i.GetTempID()
+	fhirCoverage.Issuer = i.payerID().FHIRReference()
+	fhirCoverage.Type = i.convertType()
+	fhirCoverage.Period = i.GetFHIRPeriod()
+	if i.MemberID != "" {
+		fhirCoverage.Identifier = []fhir.Identifier{{Value: i.MemberID}}
+		fhirCoverage.SubscriberId = &fhir.Identifier{Value: i.MemberID}
+	}
+	if i.Relationship != nil {
+		fhirCoverage.Extension = []fhir.Extension{
+			{
+				Url:                  subscriberRelationshipExtensionURL,
+				ValueCodeableConcept: i.Relationship.FHIRCodeableConcept(""),
+			},
+		}
+		if i.Relationship.FHIRCodeableConcept("").MatchesCode(CodeSystemMap["HL7 Relationship Code"], "SELF") {
+			fhirCoverage.Subscriber = i.Patient.FHIRReference()
+		}
+	}
+	if fhirCoverage.Subscriber == nil && i.Patient != nil {
+		fhirCoverage.Extension = append(fhirCoverage.Extension, fhir.Extension{
+			Url:            beneficiaryExtensionURL,
+			ValueReference: i.Patient.FHIRReference(),
+		})
+	}
+
+	// A payer shared with an earlier coverage is converted with that coverage
+	if i.sharedPayerWith != nil {
+		return []interface{}{fhirCoverage}
+	}
+	fhirOrganization := i.payer().FHIRModels()[0].(*fhir.Organization)
+	fhirOrganization.Type = &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "http://hl7.org/fhir/organization-type", Code: "pay", Display: "Payer"},
+		},
+		Text: "Payer",
+	}
+	return []interface{}{fhirOrganization, fhirCoverage}
+}
+
+// FromFHIR sets the insurance provider from the FHIR coverage and its issuer.  It is the inverse of FHIRModels.  The
+// payer may be nil if it isn't available.
+func (i *InsuranceProvider) FromFHIR(fhirCoverage *fhir.Coverage, fhirPayer *fhir.Organization) {
+	i.SetTempID(fhirCoverage.Id)
+	if fhirCoverage.Type != nil {
+		codeSystem := codeSystemName(fhirCoverage.Type.System)
+		i.Codes = CodeMap{codeSystem: []string{fhirCoverage.Type.Code}}
+	}
+	i.setFHIRPeriod(fhirCoverage.Period)
+	if fhirCoverage.SubscriberId != nil {
+		i.MemberID = fhirCoverage.SubscriberId.Value
+	} else if len(fhirCoverage.Identifier) > 0 && fhirCoverage.Identifier[0].System != EntryIdentifierSystem {
+		i.MemberID = fhirCoverage.Identifier[0].Value
+	}
+	for _, extension := range fhirCoverage.Extension {
+		if extension.Url == subscriberRelationshipExtensionURL {
+			i.Relationship = CodeObjectFromFHIR(extension.ValueCodeableConcept)
+		}
+	}
+	if fhirPayer != nil {
+		i.Payer = &Organization{}
+		i.Payer.FromFHIR(fhirPayer)
+		i.Name = fhirPayer.Name
+	}
+}
+
+// payer returns the payer organization.  The payer is optional in HDS, but the coverage issuer is what makes it
+// meaningful, so one is made up from the name.  The made-up payer isn't stored in Payer, but it takes its ID from
+// generatedPayer, so the organization ID is the same every time.
+func (i *InsuranceProvider) payer() *Organization {
+	if i.Payer != nil {
+		return i.Payer
+	}
+	payer := &Organization{Name: i.Name}
+	payer.SetTempID(i.generatedPayer.GetTempID())
+	return payer
+}
+
+// payerID returns the identity of the payer organization, whether it is given, made up, or shared with another
+// insurance provider
+func (i *InsuranceProvider) payerID() *TemporallyIdentified {
+	if i.sharedPayerWith != nil {
+		return i.sharedPayerWith.payerID()
+	}
+	if i.Payer != nil {
+		return &i.Payer.TemporallyIdentified
+	}
+	return &i.generatedPayer
+}
+
+// linkPayers has the insurance providers whose payers have the same name share the first one's payer organization,
+// so that the patient's bundle has one organization per payer rather than one per coverage, which conditional updates
+// (matching on the name) would turn into duplicates.  Payers without names aren't shared.
+func (p *Patient) linkPayers() {
+	payers := make(map[string]*InsuranceProvider)
+	for _, insuranceProvider := range p.InsuranceProviders {
+		insuranceProvider.sharedPayerWith = nil
+		name := insuranceProvider.Name
+		if insuranceProvider.Payer != nil {
+			name = insuranceProvider.Payer.Name
+		}
+		if name == "" {
+			continue
+		}
+		if first, ok := payers[name]; ok {
+			insuranceProvider.sharedPayerWith = first
+		} else {
+			payers[name] = insuranceProvider
+		}
+	}
+}
+
+// convertType picks the Source of Payment Typology code for the coverage type, since FHIR only allows one coding.
+// If there is no payment typology code, the first code (by code system name) is used.
+func (i *InsuranceProvider) convertType() *fhir.Coding {
+	concept := i.Codes.FHIRCodeableConcept("")
+	if len(concept.Coding) == 0 {
+		return nil
+	}
+	for _, coding := range concept.Coding {
+		if coding.System == CodeSystemMap["Source of Payment Typology"] {
+			return &coding
+		}
+	}
+	return &concept.Coding[0]
+}
@@ -0,0 +1,165 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type InsuranceProviderSuite struct {
+	Patient            *Patient
+	InsuranceProviders map[string]*InsuranceProvider
+}
+
+var _ = Suite(&InsuranceProviderSuite{})
+
+func (s *InsuranceProviderSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/insurance_providers.json")
+	util.CheckErr(err)
+
+	s.InsuranceProviders = make(map[string]*InsuranceProvider)
+	err = json.Unmarshal(data, &s.InsuranceProviders)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	for _, insuranceProvider := range s.InsuranceProviders {
+		insuranceProvider.Patient = s.Patient
+	}
+}
+
+func (s *InsuranceProviderSuite) TestSelfSubscriber(c *C) {
+	models := s.InsuranceProviders["medicare"].FHIRModels()
+	c.Assert(models, HasLen, 2)
+
+	c.Assert(models[0], FitsTypeOf, &fhir.Organization{})
+	organization := models[0].(*fhir.Organization)
+	c.Assert(organization.Name, Equals, "Medicare")
+	c.Assert(organization.Type.MatchesCode("http://hl7.org/fhir/organization-type", "pay"), Equals, true)
+	c.Assert(organization.Address, HasLen, 1)
+	c.Assert(organization.Address[0].Use, Equals, "work")
+	c.Assert(organization.Address[0].Line, DeepEquals, []string{"7500 Security Blvd"})
+	c.Assert(organization.Address[0].City, Equals, "Baltimore")
+	c.Assert(organization.Address[0].State, Equals, "MD")
+	c.Assert(organization.Address[0].PostalCode, Equals, "21244")
+	c.Assert(organization.Address[0].Country, Equals, "US")
+	c.Assert(organization.Telecom, HasLen, 1)
+	c.Assert(organization.Telecom[0].System, Equals, "phone")
+	c.Assert(organization.Telecom[0].Value, Equals, "+1-800-633-4227")
+	c.Assert(organization.Telecom[0].Use, Equals, "work")
+	c.Assert(*organization.Telecom[0].Rank, Equals, uint32(1))
+
+	c.Assert(models[1], FitsTypeOf, &fhir.Coverage{})
+	coverage := models[1].(*fhir.Coverage)
+	c.Assert(coverage.Issuer.Reference, Equals, "urn:uuid:"+organization.Id)
+	c.Assert(coverage.Type.System, Equals, "urn:oid:2.16.840.1.113883.3.221.5")
+	c.Assert(coverage.Type.Code, Equals, "1")
+	c.Assert(coverage.Period.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+	c.Assert(coverage.Period.End, DeepEquals, NewUnixTime(1362239100).FHIRDateTime())
+	c.Assert(coverage.Identifier, HasLen, 1)
+	c.Assert(coverage.Identifier[0].Value, Equals, "1234567890A")
+	c.Assert(coverage.SubscriberId.Value, Equals, "1234567890A")
+	c.Assert(coverage.Subscriber, DeepEquals, s.Patient.FHIRReference())
+	// The patient is the subscriber, so there's no need for a beneficiary
+	c.Assert(coverage.Extension, HasLen, 1)
+	c.Assert(coverage.Extension[0].ValueCodeableConcept.MatchesCode("urn:oid:2.16.840.1.113883.1.11.18877", "SELF"), Equals, true)
+}
+
+func (s *InsuranceProviderSuite) TestDependentWithoutPayer(c *C) {
+	models := s.InsuranceProviders["spouseCommercial"].FHIRModels()
+	c.Assert(models, HasLen, 2)
+
+	organization := models[0].(*fhir.Organization)
+	c.Assert(organization.Name, Equals, "Acme Health Plan")
+	c.Assert(organization.Address, IsNil)
+
+	coverage := models[1].(*fhir.Coverage)
+	c.Assert(coverage.Issuer.Reference, Equals, "urn:uuid:"+organization.Id)
+	c.Assert(coverage.Type.Code, Equals, "511")
+	c.Assert(coverage.Period.End, IsNil)
+	c.Assert(coverage.Identifier, IsNil)
+	c.Assert(coverage.SubscriberId, IsNil)
+	c.Assert(coverage.Subscriber, IsNil)
+	c.Assert(coverage.Extension, HasLen, 2)
+	c.Assert(coverage.Extension[0].ValueCodeableConcept.MatchesCode("urn:oid:2.16.840.1.113883.1.11.18877", "SPS"), Equals, true)
+	c.Assert(coverage.Extension[1].Url, Equals, beneficiaryExtensionURL)
+	c.Assert(coverage.Extension[1].ValueReference, DeepEquals, s.Patient.FHIRReference())
+
+	// The generated payer must be stable across conversions, without being added to the HDS data
+	c.Assert(s.InsuranceProviders["spouseCommercial"].FHIRModels()[0].(*fhir.Organization).Id, Equals, organization.Id)
+	c.Assert(s.InsuranceProviders["spouseCommercial"].Payer, IsNil)
+}
+
+func (s *InsuranceProviderSuite) TestTypeWithoutPaymentTypology(c *C) {
+	insuranceProvider := &InsuranceProvider{Entry: Entry{Codes: CodeMap{"SNOMED-CT": []string{"1234"}, "CPT": []string{"abcd"}}}}
+	for i := 0; i < 10; i++ {
+		c.Assert(insuranceProvider.convertType(), DeepEquals, &fhir.Coding{System: "http://www.ama-assn.org/go/cpt", Code: "abcd"})
+	}
+}
+
+func (s *InsuranceProviderSuite) TestConditionalUpdateWithoutMemberID(c *C) {
+	// Two coverages from the same payer without member IDs are told apart by their entry identifiers
+	patient := &Patient{InsuranceProviders: []*InsuranceProvider{
+		{Name: "Acme Health Plan", Entry: Entry{Codes: CodeMap{"SOP": []string{"511"}}, StartTime: NewUnixTime(1262304000)}},
+		{Name: "Acme Health Plan", Entry: Entry{Codes: CodeMap{"SOP": []string{"511"}}, StartTime: NewUnixTime(1320149800)}},
+	}}
+	patient.linkEntries()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	var urls []string
+	for i, entry := range bundle.Entry {
+		if _, ok := entry.Resource.(*fhir.Coverage); ok {
+			identifier := findEntryIdentifier(entry.Resource)
+			c.Assert(identifier, NotNil)
+			assertURL(c, bundle, i, "Coverage?identifier=%s", url.QueryEscape(EntryIdentifierSystem+"|"+identifier.Value))
+			urls = append(urls, entry.Request.Url)
+		}
+	}
+	c.Assert(urls, HasLen, 2)
+	c.Assert(urls[0], Not(Equals), urls[1])
+}
+
+func (s *InsuranceProviderSuite) TestConditionalUpdate(c *C) {
+	patient := &Patient{InsuranceProviders: []*InsuranceProvider{s.InsuranceProviders["medicare"]}}
+	s.InsuranceProviders["medicare"].Patient = patient
+	defer func() { s.InsuranceProviders["medicare"].Patient = s.Patient }()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	c.Assert(bundle.Entry, HasLen, 3)
+	c.Assert(bundle.Entry[1].Request.Method, Equals, "PUT")
+	assertURL(c, bundle, 1, "Organization?name=Medicare&type=http://hl7.org/fhir/organization-type|pay")
+	c.Assert(bundle.Entry[2].Request.Method, Equals, "PUT")
+	issuerRef := url.QueryEscape(bundle.Entry[2].Resource.(*fhir.Coverage).Issuer.Reference)
+	assertURL(c, bundle, 2, "Coverage?identifier=1234567890A&issuer=%s&type=urn:oid:2.16.840.1.113883.3.221.5|1", issuerRef)
+}
+
+func (s *InsuranceProviderSuite) TestSharedPayer(c *C) {
+	// Coverages from the same payer share one organization, so the bundle doesn't update it twice
+	patient := &Patient{InsuranceProviders: []*InsuranceProvider{
+		{Name: "Acme", MemberID: "1", Entry: Entry{Codes: CodeMap{"SOP": []string{"511"}}}},
+		{Name: "Acme", MemberID: "2", Entry: Entry{Codes: CodeMap{"SOP": []string{"511"}}}},
+		{Name: "Medicare", MemberID: "3", Entry: Entry{Codes: CodeMap{"SOP": []string{"1"}}}},
+	}}
+	patient.linkEntries()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	var organizations []int
+	var coverages []*fhir.Coverage
+	for i, entry := range bundle.Entry {
+		switch resource := entry.Resource.(type) {
+		case *fhir.Organization:
+			organizations = append(organizations, i)
+		case *fhir.Coverage:
+			coverages = append(coverages, resource)
+		}
+	}
+	c.Assert(organizations, HasLen, 2)
+	assertURL(c, bundle, organizations[0], "Organization?name=Acme&type=http://hl7.org/fhir/organization-type|pay")
+	assertURL(c, bundle, organizations[1], "Organization?name=Medicare&type=http://hl7.org/fhir/organization-type|pay")
+	c.Assert(coverages, HasLen, 3)
+	c.Assert(coverages[1].Issuer, DeepEquals, coverages[0].Issuer)
+	c.Assert(coverages[2].Issuer, Not(DeepEquals), coverages[0].Issuer)
+}
@@ -0,0 +1,182 @@
+package hdsfhir
+
+import (
+	"reflect"
+	"strconv"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+type LabResult struct {
+	Entry
+	Interpretation     *CodeObject             `json:"interpretation"`
+	ReferenceRange     string                  `json:"referenceRange"`
+	ReferenceRangeHigh *PhysicalQuantityResult `json:"referenceRangeHigh"`
+	ReferenceRangeLow  *PhysicalQuantityResult `json:"referenceRangeLow"`
+	Values             []ResultValue           `json:"values"`
+
+	// report identifies the diagnostic report created when the result is the first in a panel
+	report TemporallyIdentified
+}
+
+func (l *LabResult) FHIRModels() []interface{} {
+	return LabResultPanel{l}.FHIRModels()
+}
+
+// convertObservations creates one observation per result value.  If there are no values, a single observation
+// with no value is created using the lab result's own ID.
+func (l *LabResult) convertObservations() []*fhir.Observation {
+	observations := valueObservations(&l.Entry, l.Values)
+	for _, observation := range observations {
+		observation.Category = observationCategory("laboratory", "Laboratory")
+		if l.Interpretation != nil {
+			observation.Interpretation = l.Interpretation.FHIRCodeableConcept("")
+		}
+		if referenceRange := l.convertReferenceRange(); referenceRange != nil {
+			observation.ReferenceRange = []fhir.ObservationReferenceRangeComponent{*referenceRange}
+		}
+	}
+
+	return observations
+}
+
+// FromFHIR sets the lab result from the FHIR observation.  It is the inverse of convertObservations for a single
+// observation; results with several values come back as separate results, which are grouped into the same panel.
+func (l *LabResult) FromFHIR(observation *fhir.Observation) {
+	l.Values = l.setFHIRObservation(observation)
+	l.Interpretation = CodeObjectFromFHIR(observation.Interpretation)
+	if len(observation.ReferenceRange) > 0 {
+		referenceRange := observation.ReferenceRange[0]
+		l.ReferenceRange = referenceRange.Text
+		l.ReferenceRangeLow = physicalQuantityFromFHIR(referenceRange.Low)
+		l.ReferenceRangeHigh = physicalQuantityFromFHIR(referenceRange.High)
+	}
+}
+
+func (l *LabResult) convertReferenceRange() *fhir.ObservationReferenceRangeComponent {
+	referenceRange := &fhir.ObservationReferenceRangeComponent{Text: l.ReferenceRange}
+	if l.ReferenceRangeLow != nil {
+		referenceRange.Low = l.ReferenceRangeLow.FHIRQuantity()
+	}
+	if l.ReferenceRangeHigh != nil {
+		referenceRange.High = l.ReferenceRangeHigh.FHIRQuantity()
+	}
+	if referenceRange.Text == "" && referenceRange.Low == nil && referenceRange.High == nil {
+		return nil
+	}
+	return referenceRange
+}
+
+// panelKey identifies the lab results that should be reported together: those taken at the same time during the
+// same encounter, such as the members of a lipid panel.  HDS doesn't record the panel a result belongs to, so the
+// results' own codes can't be used; results taken at the same time outside of any encounter are grouped too.  It
+// returns "" for results without codes or times, which can't be told to belong together.
+func (l *LabResult) panelKey() string {
+	if len(l.Codes) == 0 || (l.StartTime == nil && l.Time == nil) {
+		return ""
+	}
+
+	t := l.Time
+	if l.StartTime != nil {
+		t = l.StartTime
+	}
+
+	var encounter string
+	if l.Patient != nil {
+		if reference := l.Patient.MatchingEncounterReference(l.Entry); reference != nil {
+			encounter = reference.Reference
+		}
+	}
+
+	return encounter + "@" + strconv.FormatInt(int64(*t), 10)
+}
+
+// LabResultPanel represents a set of lab results taken at the same time (see GroupLabResults).
+type LabResultPanel []*LabResult
+
+// GroupLabResults groups the lab results into panels, preserving the order in which the results were first seen.
+// Results without codes or times are never grouped.
+func GroupLabResults(results []*LabResult) []LabResultPanel {
+	var panels []LabResultPanel
+	indexes := make(map[string]int)
+	for _, result := range results {
+		key := result.panelKey()
+		if key == "" {
+			panels = append(panels, LabResultPanel{result})
+			continue
+		}
+		if i, ok := indexes[key]; ok {
+			panels[i] = append(panels[i], result)
+		} else {
+			indexes[key] = len(panels)
+			panels = append(panels, LabResultPanel{result})
+		}
+	}
+	return panels
+}
+
+// FHIRModels returns the observations for all results in the panel.  If the panel has more than one observation,
+// a diagnostic report is also created to group them.
+func (p LabResultPanel) FHIRModels() []interface{} {
+	if len(p) == 0 {
+		return nil
+	}
+
+	var observations []*fhir.Observation
+	for _, result := range p {
+		observations = append(observations, result.convertObservations()...)
+	}
+
+	var models []interface{}
+	if len(observations) > 1 {
+		// Create the diagnostic report model with the first result's report ID and slots for results
+		first := p[0]
+		fhirReport := &fhir.DiagnosticReport{}
+		fhirReport.Id = first.report.GetTempID()
+		fhirReport.Status = "final"
+		fhirReport.Category = &fhir.CodeableConcept{
+			Coding: []fhir.Coding{
+				{System: "http://hl7.org/fhir/v2/0074", Code: "LAB", Display: "Laboratory"},
+			},
+			Text: "Laboratory",
+		}
+		fhirReport.Code = p.reportCode()
+		fhirReport.Subject = first.Patient.FHIRReference()
+		fhirReport.Encounter = first.Patient.MatchingEncounterReference(first.Entry)
+		fhirReport.EffectivePeriod = first.GetFHIRPeriod()
+		if first.EndTime != nil {
+			fhirReport.Issued = first.EndTime.FHIRDateTime() // Not perfect, but it's a required field
+		} else if first.StartTime != nil {
+			fhirReport.Issued = first.StartTime.FHIRDateTime()
+		}
+		// TODO: Technically, "performer" is required, but we don't want to make up data when HDS doesn't have it
+		fhirReport.Performer = first.Patient.PerformerReference(first.PerformerID)
+		fhirReport.Result = make([]fhir.Reference, len(observations))
+		for i := range observations {
+			fhirReport.Result[i] = fhir.Reference{Reference: "urn:uuid:" + observations[i].Id}
+		}
+		models = append(models, fhirReport)
+	}
+	for _, observation := range observations {
+		models = append(models, observation)
+	}
+
+	return models
+}
+
+// reportCode returns the code of the panel's diagnostic report: the results' codes if they all have the same codes
+// (e.g., one result with several values), or else a generic lab report code, since HDS doesn't have the panel's code
+func (p LabResultPanel) reportCode() *fhir.CodeableConcept {
+	first := p[0]
+	for _, result := range p[1:] {
+		if !reflect.DeepEqual(result.Codes, first.Codes) {
+			return &fhir.CodeableConcept{
+				Coding: []fhir.Coding{
+					{System: "http://loinc.org", Code: "11502-2", Display: "Laboratory report"},
+				},
+				Text: "Laboratory report",
+			}
+		}
+	}
+	return first.Codes.FHIRCodeableConcept(first.Description)
+}
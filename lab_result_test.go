@@ -0,0 +1,135 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type LabResultSuite struct {
+	Patient   *Patient
+	Results   map[string]*LabResult
+	Encounter *Encounter
+}
+
+var _ = Suite(&LabResultSuite{})
+
+func (s *LabResultSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/results.json")
+	util.CheckErr(err)
+
+	s.Results = make(map[string]*LabResult)
+	err = json.Unmarshal(data, &s.Results)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	s.Encounter = &Encounter{Entry: Entry{StartTime: NewUnixTime(1320148800), EndTime: NewUnixTime(1320152400)}}
+	s.Patient.Encounters = []*Encounter{s.Encounter}
+	for _, result := range s.Results {
+		result.Patient = s.Patient
+	}
+}
+
+func (s *LabResultSuite) TestSingleValueResult(c *C) {
+	models := s.Results["ldl"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Status, Equals, "final")
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "laboratory"), Equals, true)
+	c.Assert(observation.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(observation.Code.Text, Equals, "Laboratory Test, Result: LDL-c")
+	c.Assert(observation.Code.Coding, HasLen, 1)
+	c.Assert(observation.Code.MatchesCode("http://loinc.org", "2089-1"), Equals, true)
+	c.Assert(observation.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(*observation.ValueQuantity.Value, Equals, float64(130))
+	c.Assert(observation.ValueQuantity.Unit, Equals, "mg/dL")
+	c.Assert(observation.Interpretation.MatchesCode("urn:oid:2.16.840.1.113883.1.11.78", "H"), Equals, true)
+	c.Assert(observation.ReferenceRange, HasLen, 1)
+	c.Assert(observation.ReferenceRange[0].Text, Equals, "0-100 mg/dL")
+	c.Assert(*observation.ReferenceRange[0].Low.Value, Equals, float64(0))
+	c.Assert(observation.ReferenceRange[0].Low.Unit, Equals, "mg/dL")
+	c.Assert(*observation.ReferenceRange[0].High.Value, Equals, float64(100))
+	c.Assert(observation.ReferenceRange[0].High.Unit, Equals, "mg/dL")
+	c.Assert(observation.EffectivePeriod.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+	c.Assert(observation.EffectivePeriod.End, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+}
+
+func (s *LabResultSuite) TestMultiValueResult(c *C) {
+	models := s.Results["urinalysis"].FHIRModels()
+	c.Assert(models, HasLen, 3)
+
+	c.Assert(models[0], FitsTypeOf, &fhir.DiagnosticReport{})
+	report := models[0].(*fhir.DiagnosticReport)
+	c.Assert(report.Status, Equals, "final")
+	c.Assert(report.Category.MatchesCode("http://hl7.org/fhir/v2/0074", "LAB"), Equals, true)
+	c.Assert(report.Code.Text, Equals, "Laboratory Test, Result: Urinalysis")
+	c.Assert(report.Code.MatchesCode("http://loinc.org", "24356-8"), Equals, true)
+	c.Assert(report.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(report.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(report.EffectivePeriod.Start, DeepEquals, NewUnixTime(1320150400).FHIRDateTime())
+	c.Assert(report.Issued, DeepEquals, NewUnixTime(1320152400).FHIRDateTime())
+	c.Assert(report.Result, HasLen, 2)
+
+	for i := 1; i < 3; i++ {
+		c.Assert(models[i], FitsTypeOf, &fhir.Observation{})
+		observation := models[i].(*fhir.Observation)
+		c.Assert(observation.Code.MatchesCode("http://loinc.org", "24356-8"), Equals, true)
+		c.Assert(observation.Subject, DeepEquals, s.Patient.FHIRReference())
+		c.Assert(observation.Encounter, DeepEquals, s.Encounter.FHIRReference())
+		c.Assert(observation.ReferenceRange, IsNil)
+		c.Assert(report.Result[i-1].Reference, Equals, "urn:uuid:"+observation.Id)
+	}
+	c.Assert(models[1].(*fhir.Observation).ValueCodeableConcept.MatchesCode("http://snomed.info/sct", "167287002"), Equals, true)
+	c.Assert(*models[2].(*fhir.Observation).ValueQuantity.Value, Equals, float64(1.02))
+}
+
+func (s *LabResultSuite) TestGroupLabResults(c *C) {
+	panels := GroupLabResults([]*LabResult{s.Results["hdl"], s.Results["ldl"], s.Results["hdlRepeat"]})
+	c.Assert(panels, HasLen, 2)
+	c.Assert(panels[0], DeepEquals, LabResultPanel{s.Results["hdl"], s.Results["hdlRepeat"]})
+	c.Assert(panels[1], DeepEquals, LabResultPanel{s.Results["ldl"]})
+
+	models := panels[0].FHIRModels()
+	c.Assert(models, HasLen, 3)
+	c.Assert(models[0], FitsTypeOf, &fhir.DiagnosticReport{})
+	report := models[0].(*fhir.DiagnosticReport)
+	c.Assert(report.Code.MatchesCode("http://loinc.org", "2085-9"), Equals, true)
+	c.Assert(report.Encounter, IsNil)
+	c.Assert(report.Result, HasLen, 2)
+	c.Assert(report.Result[0].Reference, Equals, "urn:uuid:"+models[1].(*fhir.Observation).Id)
+	c.Assert(report.Result[1].Reference, Equals, "urn:uuid:"+models[2].(*fhir.Observation).Id)
+	c.Assert(*models[1].(*fhir.Observation).ValueQuantity.Value, Equals, float64(45))
+	c.Assert(*models[2].(*fhir.Observation).ValueQuantity.Value, Equals, float64(47))
+}
+
+func (s *LabResultSuite) TestGroupPanelMembers(c *C) {
+	// Glucose and creatinine from the same draw have different codes, but are reported together
+	glucose := &LabResult{Entry: Entry{Patient: s.Patient, Codes: CodeMap{"LOINC": []string{"2345-7"}}, StartTime: NewUnixTime(1320149800)}}
+	creatinine := &LabResult{Entry: Entry{Patient: s.Patient, Codes: CodeMap{"LOINC": []string{"2160-0"}}, StartTime: NewUnixTime(1320149800)}}
+	later := &LabResult{Entry: Entry{Patient: s.Patient, Codes: CodeMap{"LOINC": []string{"2339-0"}}, StartTime: NewUnixTime(1320150400)}}
+	panels := GroupLabResults([]*LabResult{glucose, later, creatinine})
+	c.Assert(panels, DeepEquals, []LabResultPanel{{glucose, creatinine}, {later}})
+
+	models := panels[0].FHIRModels()
+	c.Assert(models, HasLen, 3)
+	report := models[0].(*fhir.DiagnosticReport)
+	c.Assert(report.Code.MatchesCode("http://loinc.org", "11502-2"), Equals, true)
+	c.Assert(report.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(report.Result, HasLen, 2)
+	c.Assert(models[1].(*fhir.Observation).Code.MatchesCode("http://loinc.org", "2345-7"), Equals, true)
+	c.Assert(models[2].(*fhir.Observation).Code.MatchesCode("http://loinc.org", "2160-0"), Equals, true)
+}
+
+func (s *LabResultSuite) TestGroupUntimedLabResults(c *C) {
+	// Results without times can't be told to have been taken together, even with the same codes
+	codes := CodeMap{"LOINC": []string{"2085-9"}}
+	first := &LabResult{Entry: Entry{Codes: codes}}
+	second := &LabResult{Entry: Entry{Codes: codes}}
+	panels := GroupLabResults([]*LabResult{first, second})
+	c.Assert(panels, DeepEquals, []LabResultPanel{{first}, {second}})
+}
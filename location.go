@@ -0,0 +1,127 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+// Facility represents the location where an encounter took place
+type Facility struct {
+	TemporallyIdentified
+	Name      string      `json:"name"`
+	StartTime *UnixTime   `json:"start_time"`
+	EndTime   *UnixTime   `json:"end_time"`
+	Code      *CodeObject `json:"code"`
+	Addresses []*Address  `json:"addresses"`
+	Telecoms  []*Telecom  `json:"telecoms"`
+	// sharedWith is an earlier encounter's facility with the same name, whose location this facility shares (see
+	// Patient.linkFacilities)
+	sharedWith *Facility
+}
+
+func (f *Facility) FHIRModels() []interface{} {
+	fhirLocation := &fhir.Location{}
+	fhirLocation.Id = f.locationID().GetTempID()
+	fhirLocation.Name = f.Name
+	fhirLocation.Mode = "instance"
+	if f.Code != nil {
+		fhirLocation.Type = f.Code.FHIRCodeableConcept("")
+	}
+	// FHIR only allows one address per location, so use the first
+	if len(f.Addresses) > 0 {
+		address := f.Addresses[0].FHIRAddress()
+		fhirLocation.Address = &address
+	}
+	for _, telecom := range f.Telecoms {
+		fhirLocation.Telecom = append(fhirLocation.Telecom, telecom.FHIRContactPoint())
+	}
+
+	return []interface{}{fhirLocation}
+}
+
+// FromFHIR sets the facility from the FHIR location.  It is the inverse of FHIRModels, except that the period is
+// left to the encounter, which records it.
+func (f *Facility) FromFHIR(fhirLocation *fhir.Location) {
+	f.SetTempID(fhirLocation.Id)
+	f.Name = fhirLocation.Name
+	f.Code = CodeObjectFromFHIR(fhirLocation.Type)
+	if fhirLocation.Address != nil {
+		f.Addresses = []*Address{AddressFromFHIR(*fhirLocation.Address)}
+	}
+	f.Telecoms = telecomsFromFHIR(fhirLocation.Telecom)
+}
+
+// locationID returns the identity of the facility's location, which belongs to the first encounter at a facility
+// with the same name
+func (f *Facility) locationID() *TemporallyIdentified {
+	if f.sharedWith != nil {
+		return &f.sharedWith.TemporallyIdentified
+	}
+	return &f.TemporallyIdentified
+}
+
+// linkFacilities has the encounters at facilities with the same name share the first one's location, so that the
+// patient's bundle has one location per facility rather than one per encounter, which conditional updates (matching
+// on the name) would turn into duplicates.  Facilities without names aren't shared.
+func (p *Patient) linkFacilities() {
+	facilities := make(map[string]*Facility)
+	for _, encounter := range p.Encounters {
+		facility := encounter.Facility
+		if facility == nil {
+			continue
+		}
+		facility.sharedWith = nil
+		if facility.Name == "" {
+			continue
+		}
+		if first, ok := facilities[facility.Name]; ok {
+			facility.sharedWith = first
+		} else {
+			facilities[facility.Name] = facility
+		}
+	}
+}
+
+// GetFHIRPeriod returns the period during which the patient was at the facility, or nil if it is unknown
+func (f *Facility) GetFHIRPeriod() *fhir.Period {
+	entry := &Entry{StartTime: f.StartTime, EndTime: f.EndTime}
+	return entry.GetFHIRPeriod()
+}
+
+// Transfer represents the kind of location a patient was transferred from or to (e.g., another hospital)
+type Transfer struct {
+	TemporallyIdentified
+	Time  *UnixTime `json:"time"`
+	Codes CodeMap   `json:"codes"`
+}
+
+func (t *Transfer) FHIRModels() []interface{} {
+	fhirLocation := &fhir.Location{}
+	fhirLocation.Id = t.GetTempID()
+	// The codes describe a kind of location rather than a specific one.  HDS has no name for it, so conditional
+	// updates match the location on its entry identifier (see Patient.Convert), which keeps transfers from different
+	// encounters from being merged into one location.
+	fhirLocation.Mode = "kind"
+	fhirLocation.Type = t.Codes.FHIRCodeableConcept("")
+
+	return []interface{}{fhirLocation}
+}
+
+// FromFHIR sets the transfer from the FHIR location.  It is the inverse of FHIRModels, except that the time is left to
+// the encounter, which records it.
+func (t *Transfer) FromFHIR(fhirLocation *fhir.Location) {
+	t.SetTempID(fhirLocation.Id)
+	t.Codes = CodeMapFromFHIR(fhirLocation.Type)
+}
+
+// encounterLocation returns the encounter's reference to the location.  The time of the transfer ends the period at
+// the location the patient was transferred from, and starts the period at the location the patient was transferred
+// to.
+func (t *Transfer) encounterLocation(destination bool) fhir.EncounterLocationComponent {
+	location := fhir.EncounterLocationComponent{Location: t.FHIRReference()}
+	switch {
+	case t.Time == nil:
+	case destination:
+		location.Period = &fhir.Period{Start: t.Time.FHIRDateTime()}
+	default:
+		location.Period = &fhir.Period{End: t.Time.FHIRDateTime()}
+	}
+	return location
+}
@@ -0,0 +1,173 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MatchRule configures the search used to match resources of one type with existing resources on the server, when
+// converting to conditional updates or creates
+type MatchRule struct {
+	// Params lists the search parameters to match on (e.g., "patient", "code", "date").  If it is nil, every
+	// parameter supported for the resource type is used.  A resource missing the data for one of the parameters that
+	// is needed to tell it apart from other resources (e.g., a condition's onset) isn't matched, but only if that
+	// parameter is listed.
+	Params []string `json:"params,omitempty"`
+	// DateTolerance widens the search for dates and periods to find resources whose date (or period start) is within
+	// the tolerance.  If it is 0, dates must match exactly, and periods must include the start.
+	DateTolerance Duration `json:"dateTolerance"`
+	// QualifiedIdentifiers searches for identifiers with their systems (e.g., "system|value"), rather than values alone
+	QualifiedIdentifiers bool `json:"qualifiedIdentifiers"`
+}
+
+// uses indicates if the rule matches on the search parameter
+func (r MatchRule) uses(param string) bool {
+	if r.Params == nil {
+		return true
+	}
+	for _, p := range r.Params {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRuleSet holds the match rule for each resource type (e.g., "Observation").  Resource types with no rule use
+// every supported parameter, with exact dates and unqualified identifiers.
+type MatchRuleSet map[string]MatchRule
+
+// DefaultMatchRules returns the rules used when none are configured.  They match patients on their medical record
+// numbers, practitioners on their NPIs (with the system), and periods within a second of their starts.  Every other
+// supported parameter is used.
+func DefaultMatchRules() MatchRuleSet {
+	second := Duration(time.Second)
+	return MatchRuleSet{
+		"DiagnosticReport":         {DateTolerance: second},
+		"Encounter":                {DateTolerance: second},
+		"MedicationAdministration": {DateTolerance: second},
+		"MedicationStatement":      {DateTolerance: second},
+		"Observation":              {DateTolerance: second},
+		"Patient":                  {Params: []string{"identifier"}},
+		"Practitioner":             {QualifiedIdentifiers: true},
+		"Procedure":                {DateTolerance: second},
+	}
+}
+
+// LoadMatchRules parses a JSON object of match rules keyed by resource type, e.g.:
+//
+//	{"Encounter": {"params": ["patient", "date"], "dateTolerance": "1m"}}
+//
+// The rules start out as the default rules, and only the fields given for each resource type are replaced.
+func LoadMatchRules(data []byte) (MatchRuleSet, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	rules := DefaultMatchRules()
+	for resourceType, message := range raw {
+		rule := rules[resourceType]
+		if err := json.Unmarshal(message, &rule); err != nil {
+			return nil, fmt.Errorf("invalid match rule for %s: %v", resourceType, err)
+		}
+		rules[resourceType] = rule
+	}
+	return rules, nil
+}
+
+// LoadMatchRulesYAML parses match rules written in YAML, with the same structure and field names as the JSON accepted
+// by LoadMatchRules, e.g.:
+//
+//	Encounter:
+//	  params: [patient, date]
+//	  dateTolerance: 1m
+func LoadMatchRulesYAML(data []byte) (MatchRuleSet, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	value, err := jsonValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("match rules must be a mapping of resource types to rules")
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return LoadMatchRules(data)
+}
+
+// jsonValue converts a value decoded from YAML into one that can be marshaled to JSON, since YAML mappings are decoded
+// with interface{} keys
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("match rule keys must be strings: %v", key)
+			}
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[s] = converted
+		}
+		return m, nil
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = converted
+		}
+		return values, nil
+	}
+	return v, nil
+}
+
+// LoadMatchRulesFile reads match rules from a file.  Files ending in ".yaml" or ".yml" are read as YAML (see
+// LoadMatchRulesYAML), and any other file is read as JSON (see LoadMatchRules).
+func LoadMatchRulesFile(path string) (MatchRuleSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadMatchRulesYAML(data)
+	}
+	return LoadMatchRules(data)
+}
+
+// Duration is a time.Duration that is represented in JSON as a string, such as "1s" or "1h30m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations must be strings, such as \"1s\": %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
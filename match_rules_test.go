@@ -0,0 +1,167 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type MatchRulesSuite struct {
+	Patient *Patient
+}
+
+var _ = Suite(&MatchRulesSuite{})
+
+func (s *MatchRulesSuite) SetUpTest(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	err = json.Unmarshal(data, s.Patient)
+	util.CheckErr(err)
+}
+
+func (s *MatchRulesSuite) bundle(rules MatchRuleSet) *fhir.Bundle {
+	return s.Patient.FHIRTransactionBundleWithOptions(BundleOptions{Conditional: ConditionalUpdate, MatchRules: rules})
+}
+
+func (s *MatchRulesSuite) TestLoadMatchRules(c *C) {
+	rules, err := LoadMatchRules([]byte(`{
+		"Encounter": {"params": ["patient", "date"], "dateTolerance": "1m"},
+		"Practitioner": {"qualifiedIdentifiers": false},
+		"Condition": {"dateTolerance": "24h"}
+	}`))
+	c.Assert(err, IsNil)
+	c.Assert(rules["Encounter"], DeepEquals, MatchRule{Params: []string{"patient", "date"}, DateTolerance: Duration(time.Minute)})
+	c.Assert(rules["Practitioner"], DeepEquals, MatchRule{})
+	c.Assert(rules["Condition"], DeepEquals, MatchRule{DateTolerance: Duration(24 * time.Hour)})
+	// Rules that aren't given keep their defaults
+	c.Assert(rules["Patient"], DeepEquals, DefaultMatchRules()["Patient"])
+	c.Assert(rules["Observation"], DeepEquals, DefaultMatchRules()["Observation"])
+}
+
+func (s *MatchRulesSuite) TestLoadInvalidMatchRules(c *C) {
+	_, err := LoadMatchRules([]byte(`{"Encounter": {"dateTolerance": "soon"}}`))
+	c.Assert(err, ErrorMatches, `invalid match rule for Encounter: .*`)
+	_, err = LoadMatchRules([]byte(`{"Encounter": {"dateTolerance": 1000}}`))
+	c.Assert(err, ErrorMatches, `invalid match rule for Encounter: durations must be strings.*`)
+	_, err = LoadMatchRules([]byte(`[]`))
+	c.Assert(err, NotNil)
+}
+
+func (s *MatchRulesSuite) TestLoadMatchRulesFile(c *C) {
+	dir, err := ioutil.TempDir("", "hdsfhir")
+	util.CheckErr(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	util.CheckErr(ioutil.WriteFile(path, []byte(`{"Patient": {"params": ["family", "given", "birthdate"]}}`), 0644))
+	rules, err := LoadMatchRulesFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(rules["Patient"].Params, DeepEquals, []string{"family", "given", "birthdate"})
+
+	_, err = LoadMatchRulesFile(filepath.Join(dir, "missing.json"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MatchRulesSuite) TestLoadMatchRulesYAML(c *C) {
+	rules, err := LoadMatchRulesYAML([]byte(`
+Encounter:
+  params: [patient, date]
+  dateTolerance: 1m
+Practitioner:
+  qualifiedIdentifiers: false
+`))
+	c.Assert(err, IsNil)
+	c.Assert(rules["Encounter"], DeepEquals, MatchRule{Params: []string{"patient", "date"}, DateTolerance: Duration(time.Minute)})
+	c.Assert(rules["Practitioner"], DeepEquals, MatchRule{})
+	c.Assert(rules["Patient"], DeepEquals, DefaultMatchRules()["Patient"])
+
+	_, err = LoadMatchRulesYAML([]byte("Encounter:\n  dateTolerance: soon\n"))
+	c.Assert(err, ErrorMatches, `invalid match rule for Encounter: .*`)
+	_, err = LoadMatchRulesYAML([]byte("- Encounter\n"))
+	c.Assert(err, NotNil)
+	_, err = LoadMatchRulesYAML([]byte("1: {}\n"))
+	c.Assert(err, ErrorMatches, `match rule keys must be strings: 1`)
+}
+
+func (s *MatchRulesSuite) TestLoadMatchRulesYAMLFile(c *C) {
+	dir, err := ioutil.TempDir("", "hdsfhir")
+	util.CheckErr(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"rules.yaml", "rules.yml"} {
+		path := filepath.Join(dir, name)
+		util.CheckErr(ioutil.WriteFile(path, []byte("Patient:\n  params: [family, given, birthdate]\n"), 0644))
+		rules, err := LoadMatchRulesFile(path)
+		c.Assert(err, IsNil)
+		c.Assert(rules["Patient"].Params, DeepEquals, []string{"family", "given", "birthdate"})
+	}
+}
+
+func (s *MatchRulesSuite) TestDurationJSON(c *C) {
+	data, err := json.Marshal(MatchRule{DateTolerance: Duration(90 * time.Second)})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"dateTolerance":"1m30s","qualifiedIdentifiers":false}`)
+}
+
+func (s *MatchRulesSuite) TestDefaultMatchRules(c *C) {
+	// Leaving the match rules out is the same as using the default rules
+	defaults, none := s.bundle(DefaultMatchRules()), s.bundle(nil)
+	c.Assert(defaults.Entry, HasLen, len(none.Entry))
+	for i := range none.Entry {
+		c.Assert(defaults.Entry[i].Request, DeepEquals, none.Entry[i].Request)
+	}
+}
+
+func (s *MatchRulesSuite) TestParams(c *C) {
+	bundle := s.bundle(MatchRuleSet{"Encounter": {Params: []string{"patient", "date"}}})
+	patientRef := bundle.Entry[0].FullUrl
+	assertURL(c, bundle, 1, "Encounter?date=ge%s&date=le%s&patient=%s", ld("2011-11-01T12:00:00"), ld("2011-11-01T12:00:00"), patientRef)
+}
+
+func (s *MatchRulesSuite) TestParamsSkipMissingData(c *C) {
+	// The condition has no onset, but the onset isn't needed to match it
+	s.Patient.Conditions[0].StartTime = nil
+	bundle := s.bundle(MatchRuleSet{"Condition": {Params: []string{"patient", "code"}}})
+	patientRef := bundle.Entry[0].FullUrl
+	assertURL(c, bundle, 5, "Condition?code=http://hl7.org/fhir/sid/icd-10|I50.1,http://hl7.org/fhir/sid/icd-9|428.0,http://snomed.info/sct|10091002&patient=%s", patientRef)
+}
+
+func (s *MatchRulesSuite) TestDateTolerance(c *C) {
+	bundle := s.bundle(MatchRuleSet{"Condition": {DateTolerance: Duration(time.Hour)}})
+	patientRef := bundle.Entry[0].FullUrl
+	assertURL(c, bundle, 5, "Condition?code=http://hl7.org/fhir/sid/icd-10|I50.1,http://hl7.org/fhir/sid/icd-9|428.0,http://snomed.info/sct|10091002&onset=ge%s&onset=le%s&patient=%s", ld("2012-03-01T11:00:00"), ld("2012-03-01T13:00:00"), patientRef)
+}
+
+func (s *MatchRulesSuite) TestPatientDemographics(c *C) {
+	bundle := s.bundle(MatchRuleSet{"Patient": {Params: []string{"family", "given", "birthdate", "gender"}}})
+	birthDate := bundle.Entry[0].Resource.(*fhir.Patient).BirthDate.Time.Format("2006-01-02")
+	assertURL(c, bundle, 0, "Patient?birthdate=%s&family=Peters&gender=male&given=John", birthDate)
+}
+
+func (s *MatchRulesSuite) TestQualifiedIdentifiers(c *C) {
+	practitioner := &fhir.Practitioner{
+		Identifier: []fhir.Identifier{{System: "http://hl7.org/fhir/sid/us-npi", Value: "1234567890"}},
+	}
+	bundle := &fhir.Bundle{
+		Type: "transaction",
+		Entry: []fhir.BundleEntryComponent{{
+			Resource: practitioner,
+			Request:  &fhir.BundleEntryRequestComponent{Method: "POST", Url: "Practitioner"},
+		}},
+	}
+	util.CheckErr(ConvertToConditionalRequests(bundle, BundleOptions{Conditional: ConditionalUpdate}))
+	assertURL(c, bundle, 0, "Practitioner?identifier=http://hl7.org/fhir/sid/us-npi|1234567890")
+
+	bundle.Entry[0].Request = &fhir.BundleEntryRequestComponent{Method: "POST", Url: "Practitioner"}
+	rules := MatchRuleSet{"Practitioner": {QualifiedIdentifiers: false}}
+	util.CheckErr(ConvertToConditionalRequests(bundle, BundleOptions{Conditional: ConditionalUpdate, MatchRules: rules}))
+	assertURL(c, bundle, 0, "Practitioner?identifier=1234567890")
+}
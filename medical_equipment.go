@@ -0,0 +1,65 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type MedicalEquipment struct {
+	Entry
+	Reason              *Entry      `json:"reason"`
+	AnatomicalStructure *CodeObject `json:"anatomicalStructure"`
+	RemovalTime         *UnixTime   `json:"removalTime"`
+	Manufacturer        string      `json:"manufacturer"`
+
+	// device identifies the device, since the entry ID belongs to the use statement
+	device TemporallyIdentified
+}
+
+// FHIRModels returns the device and a statement that the patient used it.  Negated equipment has no models, since a
+// DSTU2 device use statement can't say that a device wasn't used (Patient.Convert reports it).
+func (m *MedicalEquipment) FHIRModels() []interface{} {
+	if m.NegationInd {
+		return nil
+	}
+
+	fhirDevice := &fhir.Device{}
+	fhirDevice.Id = m.device.GetTempID()
+	fhirDevice.Type = m.Codes.FHIRCodeableConcept(m.Description)
+	fhirDevice.Manufacturer = m.Manufacturer
+	fhirDevice.Patient = m.Patient.FHIRReference()
+
+	fhirDeviceUseStatement := &fhir.DeviceUseStatement{}
+	fhirDeviceUseStatement.Id = m.GetTempID()
+	fhirDeviceUseStatement.Device = m.device.FHIRReference()
+	fhirDeviceUseStatement.Subject = m.Patient.FHIRReference()
+	fhirDeviceUseStatement.WhenUsed = m.GetFHIRPeriod()
+	if m.RemovalTime != nil {
+		if fhirDeviceUseStatement.WhenUsed == nil {
+			fhirDeviceUseStatement.WhenUsed = &fhir.Period{}
+		}
+		fhirDeviceUseStatement.WhenUsed.End = m.RemovalTime.FHIRDateTime()
+	}
+	if m.AnatomicalStructure != nil {
+		fhirDeviceUseStatement.BodySiteCodeableConcept = m.AnatomicalStructure.FHIRCodeableConcept("")
+	}
+	if m.Reason != nil && len(m.Reason.Codes) > 0 {
+		cc := m.Reason.Codes.FHIRCodeableConcept("")
+		fhirDeviceUseStatement.Indication = []fhir.CodeableConcept{*cc}
+	}
+
+	return []interface{}{fhirDevice, fhirDeviceUseStatement}
+}
+
+// FromFHIR sets the medical equipment from the FHIR device use statement and the device it refers to.  It is the
+// inverse of FHIRModels.  The device may be nil if it isn't available.
+func (m *MedicalEquipment) FromFHIR(fhirDeviceUseStatement *fhir.DeviceUseStatement, fhirDevice *fhir.Device) {
+	m.SetTempID(fhirDeviceUseStatement.Id)
+	if fhirDevice != nil {
+		m.device.SetTempID(fhirDevice.Id)
+		m.setFHIRCodes(fhirDevice.Type)
+		m.Manufacturer = fhirDevice.Manufacturer
+	}
+	m.setFHIRPeriod(fhirDeviceUseStatement.WhenUsed)
+	m.AnatomicalStructure = CodeObjectFromFHIR(fhirDeviceUseStatement.BodySiteCodeableConcept)
+	if len(fhirDeviceUseStatement.Indication) > 0 {
+		m.Reason = &Entry{Codes: CodeMapFromFHIR(&fhirDeviceUseStatement.Indication[0])}
+	}
+}
@@ -0,0 +1,108 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type MedicalEquipmentSuite struct {
+	Patient          *Patient
+	MedicalEquipment map[string]*MedicalEquipment
+}
+
+var _ = Suite(&MedicalEquipmentSuite{})
+
+func (s *MedicalEquipmentSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/medical_equipment.json")
+	util.CheckErr(err)
+
+	s.MedicalEquipment = make(map[string]*MedicalEquipment)
+	err = json.Unmarshal(data, &s.MedicalEquipment)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	for _, equipment := range s.MedicalEquipment {
+		equipment.Patient = s.Patient
+	}
+}
+
+func (s *MedicalEquipmentSuite) TestMedicalEquipment(c *C) {
+	models := s.MedicalEquipment["compressionStockings"].FHIRModels()
+	c.Assert(models, HasLen, 2)
+
+	c.Assert(models[0], FitsTypeOf, &fhir.Device{})
+	device := models[0].(*fhir.Device)
+	c.Assert(device.Type.Text, Equals, "Device, Applied: Graduated compression stockings (GCS) (Code List: 2.16.840.1.113883.3.117.1.7.1.256)")
+	c.Assert(device.Type.Coding, HasLen, 1)
+	c.Assert(device.Type.MatchesCode("http://snomed.info/sct", "348681001"), Equals, true)
+	c.Assert(device.Patient, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(device.Manufacturer, Equals, "")
+
+	c.Assert(models[1], FitsTypeOf, &fhir.DeviceUseStatement{})
+	statement := models[1].(*fhir.DeviceUseStatement)
+	c.Assert(statement.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(statement.Device.Reference, Equals, "urn:uuid:"+device.Id)
+	c.Assert(statement.WhenUsed.Start, DeepEquals, NewUnixTime(1333272600).FHIRDateTime())
+	c.Assert(statement.WhenUsed.End, DeepEquals, NewUnixTime(1333470600).FHIRDateTime())
+	c.Assert(statement.BodySiteCodeableConcept, IsNil)
+	c.Assert(statement.Indication, IsNil)
+}
+
+func (s *MedicalEquipmentSuite) TestImplantedDevice(c *C) {
+	models := s.MedicalEquipment["pacemaker"].FHIRModels()
+	c.Assert(models, HasLen, 2)
+
+	device := models[0].(*fhir.Device)
+	c.Assert(device.Type.MatchesCode("http://snomed.info/sct", "14106009"), Equals, true)
+	c.Assert(device.Manufacturer, Equals, "Acme Devices")
+
+	statement := models[1].(*fhir.DeviceUseStatement)
+	c.Assert(statement.Device.Reference, Equals, "urn:uuid:"+device.Id)
+	c.Assert(statement.WhenUsed.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+	c.Assert(statement.WhenUsed.End, DeepEquals, NewUnixTime(1362239100).FHIRDateTime())
+	c.Assert(statement.BodySiteCodeableConcept.MatchesCode("http://snomed.info/sct", "80891009"), Equals, true)
+	c.Assert(statement.Indication, HasLen, 1)
+	c.Assert(statement.Indication[0].MatchesCode("http://snomed.info/sct", "44808001"), Equals, true)
+}
+
+func (s *MedicalEquipmentSuite) TestNegatedEquipment(c *C) {
+	patient := &Patient{MedicalEquipment: []*MedicalEquipment{
+		{Entry: Entry{ID: "5697d8b2c1c1b1a2b3000040", Codes: CodeMap{"SNOMED-CT": []string{"14106009"}}, NegationInd: true}},
+	}}
+	patient.linkEntries()
+	c.Assert(patient.MedicalEquipment[0].FHIRModels(), HasLen, 0)
+
+	// The entry is skipped with a warning, rather than converted to a statement that the device was used
+	result, err := patient.Convert(ConversionOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Models, HasLen, 1)
+	c.Assert(result.Diagnostics, HasLen, 1)
+	c.Assert(result.Diagnostics[0].Severity, Equals, SeverityWarning)
+	c.Assert(result.Diagnostics[0].Type, Equals, "not-supported")
+	c.Assert(result.Diagnostics[0].Location(), Equals, "medical_equipment[0]")
+}
+
+func (s *MedicalEquipmentSuite) TestConditionalUpdateSameTypeDevices(c *C) {
+	// A pacemaker and its replacement have the same type, so they must be told apart by their entry identifiers
+	patient := &Patient{MedicalEquipment: []*MedicalEquipment{
+		{Entry: Entry{ID: "5697d8b2c1c1b1a2b3000041", Codes: CodeMap{"SNOMED-CT": []string{"14106009"}}, StartTime: NewUnixTime(1320149800)}},
+		{Entry: Entry{ID: "5697d8b2c1c1b1a2b3000042", Codes: CodeMap{"SNOMED-CT": []string{"14106009"}}, StartTime: NewUnixTime(1362239100)}},
+	}}
+	patient.linkEntries()
+	bundle := patient.FHIRTransactionBundle(true)
+	var urls []string
+	for _, entry := range bundle.Entry {
+		if device, ok := entry.Resource.(*fhir.Device); ok {
+			c.Assert(findEntryIdentifier(device), NotNil)
+			c.Assert(entry.Request.Url, Equals, "Device?identifier="+url.QueryEscape(EntryIdentifierSystem+"|"+findEntryIdentifier(device).Value))
+			urls = append(urls, entry.Request.Url)
+		}
+	}
+	c.Assert(urls, HasLen, 2)
+	c.Assert(urls[0], Not(Equals), urls[1])
+}
@@ -1,9 +1,42 @@
 package hdsfhir
 
-import fhir "github.com/intervention-engine/fhir/models"
+import (
+	"fmt"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+const productFormExtensionURL = "http://github.com/intervention-engine/hdsfhir/StructureDefinition/product-form"
+
+// The QDM datatype OIDs that map to more specific medication resources.  Orders include discharge medications, since
+// those are prescribed at discharge.  Only administrations include the "not done" variant, since orders and dispenses
+// can't be negated; those not done become statements that the medication was not taken (see FHIRModels).
+var (
+	medicationOrderOIDs        = []string{"2.16.840.1.113883.3.560.1.17", "2.16.840.1.113883.3.560.1.199"}
+	medicationDispensedOIDs    = []string{"2.16.840.1.113883.3.560.1.8"}
+	medicationAdministeredOIDs = []string{"2.16.840.1.113883.3.560.1.14", "2.16.840.1.113883.3.560.1.114"}
+)
 
 type Medication struct {
 	Entry
+	Dose                         *Scalar               `json:"dose"`
+	Route                        *CodeObject           `json:"route"`
+	AdministrationTiming         *AdministrationTiming `json:"administrationTiming"`
+	FreeTextSig                  string                `json:"freeTextSig"`
+	PatientInstructions          string                `json:"patientInstructions"`
+	ProductForm                  *CodeObject           `json:"productForm"`
+	DeliveryMethod               *CodeObject           `json:"deliveryMethod"`
+	DoseRestriction              *DoseRestriction      `json:"doseRestriction"`
+	CumulativeMedicationDuration *Scalar               `json:"cumulativeMedicationDuration"`
+	FulfillmentHistory           []*Fulfillment        `json:"fulfillmentHistory"`
+}
+
+// Fulfillment represents a single fill of a medication
+type Fulfillment struct {
+	TemporallyIdentified
+	DispenseDate       *UnixTime `json:"dispenseDate"`
+	QuantityDispensed  *Scalar   `json:"quantityDispensed"`
+	PrescriptionNumber string    `json:"prescriptionNumber"`
 }
 
 func (m *Medication) FHIRModels() []interface{} {
@@ -13,11 +46,60 @@ func (m *Medication) FHIRModels() []interface{} {
 		return m.convertImmunization()
 	}
 
+	switch {
+	case m.hasDatatype(medicationAdministeredOIDs):
+		return m.convertMedicationAdministration()
+	case m.NegationInd:
+		// Orders and dispenses can't be negated, but a statement can indicate the medication was not taken
+		return m.convertMedication()
+	case m.hasDatatype(medicationOrderOIDs) || m.MoodCode == "RQO":
+		return m.convertMedicationOrder()
+	case m.hasDatatype(medicationDispensedOIDs):
+		return m.convertMedicationDispenses()
+	}
+
 	return m.convertMedication()
 }
 
+func (m *Medication) hasDatatype(oids []string) bool {
+	for _, oid := range oids {
+		if m.Oid == oid {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScalars returns an error if any of the medication's scalars couldn't be decoded
+func (m *Medication) checkScalars() error {
+	if err := checkScalar("dose", m.Dose); err != nil {
+		return err
+	}
+	if err := checkScalar("cumulative medication duration", m.CumulativeMedicationDuration); err != nil {
+		return err
+	}
+	if m.AdministrationTiming != nil {
+		if err := checkScalar("administration timing period", m.AdministrationTiming.Period); err != nil {
+			return err
+		}
+	}
+	if m.DoseRestriction != nil {
+		if err := checkScalar("dose restriction numerator", m.DoseRestriction.Numerator); err != nil {
+			return err
+		}
+		if err := checkScalar("dose restriction denominator", m.DoseRestriction.Denominator); err != nil {
+			return err
+		}
+	}
+	for i, fulfillment := range m.FulfillmentHistory {
+		if err := checkScalar(fmt.Sprintf("fulfillment %d quantity dispensed", i), fulfillment.QuantityDispensed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *Medication) convertMedication() []interface{} {
-	// TODO: Use more specific medication resources (MedicationOrder, MedicationAdministration, etc.)
 	fhirMedicationStatement := &fhir.MedicationStatement{}
 	fhirMedicationStatement.Id = m.GetTempID()
 	fhirMedicationStatement.Patient = m.Patient.FHIRReference()
@@ -32,12 +114,345 @@ func (m *Medication) convertMedication() []interface{} {
 	}
 	fhirMedicationStatement.EffectivePeriod = m.GetFHIRPeriod()
 	fhirMedicationStatement.MedicationCodeableConcept = m.Codes.FHIRCodeableConcept(m.Description)
-
-	// Ignoring dosage, route, etc.
+	if dosage := m.convertDosage(); dosage != nil {
+		fhirMedicationStatement.Dosage = []fhir.MedicationStatementDosageComponent{*dosage}
+	}
+	fhirMedicationStatement.Note = m.PatientInstructions
+	fhirMedicationStatement.Extension = m.convertProductForm()
 
 	return []interface{}{fhirMedicationStatement}
 }
 
+func (m *Medication) convertMedicationOrder() []interface{} {
+	fhirMedicationOrder := &fhir.MedicationOrder{}
+	fhirMedicationOrder.Id = m.GetTempID()
+	fhirMedicationOrder.Patient = m.Patient.FHIRReference()
+	fhirMedicationOrder.Status = m.convertMedicationOrderStatus()
+	if m.Time != nil {
+		fhirMedicationOrder.DateWritten = m.Time.FHIRDateTime()
+	} else if m.StartTime != nil {
+		fhirMedicationOrder.DateWritten = m.StartTime.FHIRDateTime()
+	} else if m.EndTime != nil {
+		fhirMedicationOrder.DateWritten = m.EndTime.FHIRDateTime()
+	}
+	if m.EndTime != nil {
+		fhirMedicationOrder.DateEnded = m.EndTime.FHIRDateTime()
+	}
+	fhirMedicationOrder.Prescriber = m.Patient.PerformerReference(m.PerformerID)
+	fhirMedicationOrder.Encounter = m.Patient.MatchingEncounterReference(m.Entry)
+	fhirMedicationOrder.MedicationCodeableConcept = m.Codes.FHIRCodeableConcept(m.Description)
+	if instruction := m.convertDosageInstruction(); instruction != nil {
+		fhirMedicationOrder.DosageInstruction = []fhir.MedicationOrderDosageInstructionComponent{*instruction}
+	}
+	if duration := m.convertCumulativeMedicationDuration(); duration != nil {
+		fhirMedicationOrder.DispenseRequest = &fhir.MedicationOrderDispenseRequestComponent{
+			ExpectedSupplyDuration: duration,
+		}
+	}
+	fhirMedicationOrder.Note = m.PatientInstructions
+	fhirMedicationOrder.Extension = m.convertProductForm()
+
+	// Each fill of the order is a dispense authorized by it
+	models := []interface{}{fhirMedicationOrder}
+	for _, fulfillment := range m.FulfillmentHistory {
+		fhirMedicationDispense := m.convertMedicationDispense(fulfillment)
+		fhirMedicationDispense.AuthorizingPrescription = []fhir.Reference{*m.FHIRReference()}
+		models = append(models, fhirMedicationDispense)
+	}
+
+	return models
+}
+
+// convertMedicationDispenses creates one dispense per fulfillment.  If there is no fulfillment history, a single
+// dispense is created to represent the medication entry itself.
+func (m *Medication) convertMedicationDispenses() []interface{} {
+	if len(m.FulfillmentHistory) == 0 {
+		return []interface{}{m.convertMedicationDispense(nil)}
+	}
+
+	models := make([]interface{}, len(m.FulfillmentHistory))
+	for i, fulfillment := range m.FulfillmentHistory {
+		models[i] = m.convertMedicationDispense(fulfillment)
+	}
+	return models
+}
+
+func (m *Medication) convertMedicationDispense(fulfillment *Fulfillment) *fhir.MedicationDispense {
+	fhirMedicationDispense := &fhir.MedicationDispense{}
+	fhirMedicationDispense.Status = m.convertMedicationDispenseStatus()
+	fhirMedicationDispense.Patient = m.Patient.FHIRReference()
+	fhirMedicationDispense.MedicationCodeableConcept = m.Codes.FHIRCodeableConcept(m.Description)
+	if instruction := m.convertDosageInstruction(); instruction != nil {
+		fhirMedicationDispense.DosageInstruction = []fhir.MedicationDispenseDosageInstructionComponent{
+			fhir.MedicationDispenseDosageInstructionComponent(*instruction),
+		}
+	}
+	fhirMedicationDispense.DaysSupply = m.convertCumulativeMedicationDuration()
+	fhirMedicationDispense.Note = m.PatientInstructions
+	fhirMedicationDispense.Extension = m.convertProductForm()
+
+	if fulfillment == nil {
+		fhirMedicationDispense.Id = m.GetTempID()
+		if m.StartTime != nil {
+			fhirMedicationDispense.WhenHandedOver = m.StartTime.FHIRDateTime()
+		} else if m.Time != nil {
+			fhirMedicationDispense.WhenHandedOver = m.Time.FHIRDateTime()
+		}
+		return fhirMedicationDispense
+	}
+
+	// The entry status describes the medication as a whole, but a recorded fill has already been dispensed
+	fhirMedicationDispense.Id = fulfillment.GetTempID()
+	fhirMedicationDispense.Status = "completed"
+	if fulfillment.DispenseDate != nil {
+		fhirMedicationDispense.WhenHandedOver = fulfillment.DispenseDate.FHIRDateTime()
+	}
+	if fulfillment.QuantityDispensed != nil {
+		fhirMedicationDispense.Quantity = fulfillment.QuantityDispensed.FHIRQuantity()
+	}
+	if fulfillment.PrescriptionNumber != "" {
+		fhirMedicationDispense.Identifier = &fhir.Identifier{Value: fulfillment.PrescriptionNumber}
+	}
+	return fhirMedicationDispense
+}
+
+func (m *Medication) convertMedicationAdministration() []interface{} {
+	fhirMedicationAdministration := &fhir.MedicationAdministration{}
+	fhirMedicationAdministration.Id = m.GetTempID()
+	fhirMedicationAdministration.Status = m.convertAdministrationStatus()
+	fhirMedicationAdministration.Patient = m.Patient.FHIRReference()
+	fhirMedicationAdministration.Practitioner = m.Patient.PerformerReference(m.PerformerID)
+	fhirMedicationAdministration.Encounter = m.Patient.MatchingEncounterReference(m.Entry)
+	if m.NegationInd {
+		t := true
+		fhirMedicationAdministration.WasNotGiven = &t
+	}
+	if m.NegationReason != nil {
+		cc := m.NegationReason.FHIRCodeableConcept("")
+		fhirMedicationAdministration.ReasonNotGiven = []fhir.CodeableConcept{*cc}
+	}
+	fhirMedicationAdministration.EffectiveTimePeriod = m.GetFHIRPeriod()
+	fhirMedicationAdministration.MedicationCodeableConcept = m.Codes.FHIRCodeableConcept(m.Description)
+	if dosage := m.convertDosage(); dosage != nil {
+		// Timing and restrictions don't apply to a single administration
+		fhirMedicationAdministration.Dosage = &fhir.MedicationAdministrationDosageComponent{
+			Text:     dosage.Text,
+			Route:    dosage.Route,
+			Method:   dosage.Method,
+			Quantity: dosage.QuantitySimpleQuantity,
+		}
+	}
+	fhirMedicationAdministration.Note = m.PatientInstructions
+	fhirMedicationAdministration.Extension = m.convertProductForm()
+
+	return []interface{}{fhirMedicationAdministration}
+}
+
+// FromFHIRMedicationStatement sets the medication from the FHIR medication statement.  It is the inverse of
+// convertMedication.
+func (m *Medication) FromFHIRMedicationStatement(fhirMedicationStatement *fhir.MedicationStatement) {
+	m.SetTempID(fhirMedicationStatement.Id)
+	m.setFHIRCodes(fhirMedicationStatement.MedicationCodeableConcept)
+	m.StatusCode = medicationStatusFromFHIR(fhirMedicationStatement.Status)
+	m.NegationInd = fhirMedicationStatement.WasNotTaken != nil && *fhirMedicationStatement.WasNotTaken
+	if len(fhirMedicationStatement.ReasonNotTaken) > 0 {
+		m.NegationReason = CodeObjectFromFHIR(&fhirMedicationStatement.ReasonNotTaken[0])
+	}
+	if fhirMedicationStatement.EffectivePeriod != nil {
+		m.setFHIRPeriod(fhirMedicationStatement.EffectivePeriod)
+	} else if fhirMedicationStatement.EffectiveDateTime != nil {
+		m.StartTime = UnixTimeFromFHIR(fhirMedicationStatement.EffectiveDateTime)
+	}
+	if len(fhirMedicationStatement.Dosage) > 0 {
+		m.setFHIRDosage(&fhirMedicationStatement.Dosage[0])
+	}
+	m.PatientInstructions = fhirMedicationStatement.Note
+	m.setFHIRProductForm(fhirMedicationStatement.Extension)
+}
+
+// FromFHIRMedicationOrder sets the medication from the FHIR medication order, using the QDM datatype for orders.  It
+// is the inverse of convertMedicationOrder, except that the dispenses and prescriber are left to FromFHIRBundle,
+// since they are separate resources.
+func (m *Medication) FromFHIRMedicationOrder(fhirMedicationOrder *fhir.MedicationOrder) {
+	m.SetTempID(fhirMedicationOrder.Id)
+	m.Oid = medicationOrderOIDs[0]
+	m.setFHIRCodes(fhirMedicationOrder.MedicationCodeableConcept)
+	m.StatusCode = medicationOrderStatusFromFHIR(fhirMedicationOrder.Status)
+	m.Time = UnixTimeFromFHIR(fhirMedicationOrder.DateWritten)
+	m.EndTime = UnixTimeFromFHIR(fhirMedicationOrder.DateEnded)
+	if len(fhirMedicationOrder.DosageInstruction) > 0 {
+		m.setFHIRDosageInstruction(&fhirMedicationOrder.DosageInstruction[0])
+	}
+	if fhirMedicationOrder.DispenseRequest != nil {
+		m.CumulativeMedicationDuration = ScalarFromFHIR(fhirMedicationOrder.DispenseRequest.ExpectedSupplyDuration)
+	}
+	m.PatientInstructions = fhirMedicationOrder.Note
+	m.setFHIRProductForm(fhirMedicationOrder.Extension)
+}
+
+// FromFHIRMedicationDispense sets the medication from a FHIR medication dispense that wasn't authorized by an order,
+// using the QDM datatype for dispenses.  It is the inverse of convertMedicationDispenses for a medication without a
+// fulfillment history; the details of the fill itself are kept as the only fulfillment.
+func (m *Medication) FromFHIRMedicationDispense(fhirMedicationDispense *fhir.MedicationDispense) {
+	m.Oid = medicationDispensedOIDs[0]
+	m.setFHIRCodes(fhirMedicationDispense.MedicationCodeableConcept)
+	m.StatusCode = medicationDispenseStatusFromFHIR(fhirMedicationDispense.Status)
+	m.StartTime = UnixTimeFromFHIR(fhirMedicationDispense.WhenHandedOver)
+	if len(fhirMedicationDispense.DosageInstruction) > 0 {
+		instruction := fhir.MedicationOrderDosageInstructionComponent(fhirMedicationDispense.DosageInstruction[0])
+		m.setFHIRDosageInstruction(&instruction)
+	}
+	m.CumulativeMedicationDuration = ScalarFromFHIR(fhirMedicationDispense.DaysSupply)
+	m.PatientInstructions = fhirMedicationDispense.Note
+	m.setFHIRProductForm(fhirMedicationDispense.Extension)
+	if fhirMedicationDispense.Quantity != nil || fhirMedicationDispense.Identifier != nil {
+		// Like convertMedicationDispenses, the dispense ID belongs to the fulfillment if there is one
+		fulfillment := &Fulfillment{}
+		fulfillment.FromFHIR(fhirMedicationDispense)
+		m.FulfillmentHistory = []*Fulfillment{fulfillment}
+	} else {
+		m.SetTempID(fhirMedicationDispense.Id)
+	}
+}
+
+// FromFHIR sets the fulfillment from the FHIR medication dispense.  It is the inverse of convertMedicationDispense
+// for a fulfillment.
+func (f *Fulfillment) FromFHIR(fhirMedicationDispense *fhir.MedicationDispense) {
+	f.SetTempID(fhirMedicationDispense.Id)
+	f.DispenseDate = UnixTimeFromFHIR(fhirMedicationDispense.WhenHandedOver)
+	f.QuantityDispensed = ScalarFromFHIR(fhirMedicationDispense.Quantity)
+	if fhirMedicationDispense.Identifier != nil {
+		f.PrescriptionNumber = fhirMedicationDispense.Identifier.Value
+	}
+}
+
+// FromFHIRMedicationAdministration sets the medication from the FHIR medication administration, using the QDM
+// datatype for administrations.  It is the inverse of convertMedicationAdministration, except that the practitioner
+// is left to FromFHIRBundle, since it is a separate resource.
+func (m *Medication) FromFHIRMedicationAdministration(fhirMedicationAdministration *fhir.MedicationAdministration) {
+	m.SetTempID(fhirMedicationAdministration.Id)
+	m.Oid = medicationAdministeredOIDs[0]
+	m.setFHIRCodes(fhirMedicationAdministration.MedicationCodeableConcept)
+	m.StatusCode = administrationStatusFromFHIR(fhirMedicationAdministration.Status)
+	m.NegationInd = fhirMedicationAdministration.WasNotGiven != nil && *fhirMedicationAdministration.WasNotGiven
+	if len(fhirMedicationAdministration.ReasonNotGiven) > 0 {
+		m.NegationReason = CodeObjectFromFHIR(&fhirMedicationAdministration.ReasonNotGiven[0])
+	}
+	if fhirMedicationAdministration.EffectiveTimePeriod != nil {
+		m.setFHIRPeriod(fhirMedicationAdministration.EffectiveTimePeriod)
+	} else if fhirMedicationAdministration.EffectiveTimeDateTime != nil {
+		m.StartTime = UnixTimeFromFHIR(fhirMedicationAdministration.EffectiveTimeDateTime)
+	}
+	if dosage := fhirMedicationAdministration.Dosage; dosage != nil {
+		m.setFHIRDosage(&fhir.MedicationStatementDosageComponent{
+			Text:                   dosage.Text,
+			Route:                  dosage.Route,
+			Method:                 dosage.Method,
+			QuantitySimpleQuantity: dosage.Quantity,
+		})
+	}
+	m.PatientInstructions = fhirMedicationAdministration.Note
+	m.setFHIRProductForm(fhirMedicationAdministration.Extension)
+}
+
+// convertProductForm returns an extension for the product form, or nil if it is unknown.  DSTU2 only allows the
+// form on a Medication resource, but we don't want to create one just for this.
+func (m *Medication) convertProductForm() []fhir.Extension {
+	if m.ProductForm == nil {
+		return nil
+	}
+	return []fhir.Extension{
+		{
+			Url:                  productFormExtensionURL,
+			ValueCodeableConcept: m.ProductForm.FHIRCodeableConcept(""),
+		},
+	}
+}
+
+// setFHIRProductForm sets the product form from its extension, if there is one.  It is the inverse of
+// convertProductForm.
+func (m *Medication) setFHIRProductForm(extensions []fhir.Extension) {
+	for _, extension := range extensions {
+		if extension.Url == productFormExtensionURL {
+			m.ProductForm = CodeObjectFromFHIR(extension.ValueCodeableConcept)
+		}
+	}
+}
+
+func (m *Medication) convertCumulativeMedicationDuration() *fhir.Quantity {
+	if m.CumulativeMedicationDuration == nil {
+		return nil
+	}
+	return m.CumulativeMedicationDuration.FHIRQuantity()
+}
+
+// convertDosage returns the dosage instructions for the medication, or nil if HDS has none
+func (m *Medication) convertDosage() *fhir.MedicationStatementDosageComponent {
+	dosage := &fhir.MedicationStatementDosageComponent{}
+	dosage.Text = m.FreeTextSig
+	if m.AdministrationTiming != nil {
+		dosage.Timing = m.AdministrationTiming.FHIRTiming()
+	}
+	if m.Route != nil {
+		dosage.Route = m.Route.FHIRCodeableConcept("")
+	}
+	if m.DeliveryMethod != nil {
+		dosage.Method = m.DeliveryMethod.FHIRCodeableConcept("")
+	}
+	if m.Dose != nil {
+		dosage.QuantitySimpleQuantity = m.Dose.FHIRQuantity()
+	}
+	if m.DoseRestriction != nil {
+		dosage.MaxDosePerPeriod = m.DoseRestriction.FHIRRatio()
+	}
+
+	if dosage.Text == "" && dosage.Timing == nil && dosage.Route == nil && dosage.Method == nil &&
+		dosage.QuantitySimpleQuantity == nil && dosage.MaxDosePerPeriod == nil {
+		return nil
+	}
+	return dosage
+}
+
+// setFHIRDosage sets the dose, route, timing, and other dosage instructions from the FHIR dosage.  It is the inverse
+// of convertDosage.
+func (m *Medication) setFHIRDosage(dosage *fhir.MedicationStatementDosageComponent) {
+	m.FreeTextSig = dosage.Text
+	m.AdministrationTiming = AdministrationTimingFromFHIR(dosage.Timing)
+	m.Route = CodeObjectFromFHIR(dosage.Route)
+	m.DeliveryMethod = CodeObjectFromFHIR(dosage.Method)
+	m.Dose = ScalarFromFHIR(dosage.QuantitySimpleQuantity)
+	m.DoseRestriction = DoseRestrictionFromFHIR(dosage.MaxDosePerPeriod)
+}
+
+// convertDosageInstruction returns the dosage instructions for an order or dispense, or nil if HDS has none
+func (m *Medication) convertDosageInstruction() *fhir.MedicationOrderDosageInstructionComponent {
+	dosage := m.convertDosage()
+	if dosage == nil {
+		return nil
+	}
+	return &fhir.MedicationOrderDosageInstructionComponent{
+		Text:               dosage.Text,
+		Timing:             dosage.Timing,
+		Route:              dosage.Route,
+		Method:             dosage.Method,
+		DoseSimpleQuantity: dosage.QuantitySimpleQuantity,
+		MaxDosePerPeriod:   dosage.MaxDosePerPeriod,
+	}
+}
+
+// setFHIRDosageInstruction sets the dosage instructions from an order or dispense.  It is the inverse of
+// convertDosageInstruction.
+func (m *Medication) setFHIRDosageInstruction(instruction *fhir.MedicationOrderDosageInstructionComponent) {
+	m.setFHIRDosage(&fhir.MedicationStatementDosageComponent{
+		Text:                   instruction.Text,
+		Timing:                 instruction.Timing,
+		Route:                  instruction.Route,
+		Method:                 instruction.Method,
+		QuantitySimpleQuantity: instruction.DoseSimpleQuantity,
+		MaxDosePerPeriod:       instruction.MaxDosePerPeriod,
+	})
+}
+
 // convertMedicationStatus maps the status to a code in the required FHIR value set:
 //   http://hl7.org/fhir/DSTU2/valueset-medication-statement-status.html
 func (m *Medication) convertMedicationStatus() string {
@@ -78,10 +493,128 @@ func (m *Medication) convertMedicationStatus() string {
 	return status
 }
 
+// medicationStatusFromFHIR maps the FHIR medication statement status back to an ActStatus.  It is the inverse of
+// convertMedicationStatus.  If the status cannot be mapped, nil is returned.
+func medicationStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "active":
+		return actStatus("active")
+	case "entered-in-error":
+		return actStatus("nullified")
+	case "intended":
+		return actStatus("new")
+	case "completed":
+		return actStatus("completed")
+	}
+	return nil
+}
+
+// convertMedicationOrderStatus maps the status to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-medication-order-status.html
+func (m *Medication) convertMedicationOrderStatus() string {
+	var status string
+	statusConcept := m.StatusCode.FHIRCodeableConcept("")
+	switch {
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "aborted"):
+		status = "stopped"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "active"):
+		status = "active"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "cancelled"):
+		status = "stopped"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "completed"):
+		status = "completed"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "held"):
+		status = "on-hold"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "new"):
+		status = "draft"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "suspended"):
+		status = "on-hold"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "nullified"):
+		status = "entered-in-error"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "obsolete"):
+		status = "entered-in-error"
+	// NOTE: this is not a real ActStatus, but HDS seems to use it
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "ordered"):
+		status = "active"
+	// Without a status, assume the order was placed, since HDS records orders (even ones with the RQO mood code) once
+	// they are written, and a draft is one that hasn't been
+	default:
+		status = "active"
+	}
+
+	return status
+}
+
+// medicationOrderStatusFromFHIR maps the FHIR medication order status back to an ActStatus.  It is the inverse of
+// convertMedicationOrderStatus.  If the status cannot be mapped, nil is returned.
+func medicationOrderStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "stopped":
+		return actStatus("aborted")
+	case "active":
+		return actStatus("active")
+	case "completed":
+		return actStatus("completed")
+	case "on-hold":
+		return actStatus("held")
+	case "draft":
+		return actStatus("new")
+	case "entered-in-error":
+		return actStatus("nullified")
+	}
+	return nil
+}
+
+// convertMedicationDispenseStatus maps the status to a code in the required FHIR value set:
+//   http://hl7.org/fhir/DSTU2/valueset-medication-dispense-status.html
+func (m *Medication) convertMedicationDispenseStatus() string {
+	var status string
+	statusConcept := m.StatusCode.FHIRCodeableConcept("")
+	switch {
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "aborted"):
+		status = "stopped"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "active"):
+		status = "in-progress"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "cancelled"):
+		status = "entered-in-error"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "held"):
+		status = "on-hold"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "suspended"):
+		status = "on-hold"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "nullified"):
+		status = "entered-in-error"
+	case statusConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ActStatus", "obsolete"):
+		status = "entered-in-error"
+	default:
+		// Includes "dispensed", which is not a real ActStatus, but HDS seems to use it
+		status = "completed"
+	}
+
+	return status
+}
+
+// medicationDispenseStatusFromFHIR maps the FHIR medication dispense status back to an ActStatus.  It is the inverse
+// of convertMedicationDispenseStatus.  If the status cannot be mapped, nil is returned.
+func medicationDispenseStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "stopped":
+		return actStatus("aborted")
+	case "in-progress":
+		return actStatus("active")
+	case "entered-in-error":
+		return actStatus("nullified")
+	case "on-hold":
+		return actStatus("held")
+	case "completed":
+		return actStatus("completed")
+	}
+	return nil
+}
+
 func (m *Medication) convertImmunization() []interface{} {
 	fhirImmunization := &fhir.Immunization{}
 	fhirImmunization.Id = m.GetTempID()
-	fhirImmunization.Status = m.convertImmunizationStatus()
+	fhirImmunization.Status = m.convertAdministrationStatus()
 	if m.StartTime != nil {
 		fhirImmunization.Date = m.StartTime.FHIRDateTime()
 	}
@@ -98,14 +631,20 @@ func (m *Medication) convertImmunization() []interface{} {
 		}
 	}
 
-	// Ignoring dosage, route, etc.
+	if m.Route != nil {
+		fhirImmunization.Route = m.Route.FHIRCodeableConcept("")
+	}
+	if m.Dose != nil {
+		fhirImmunization.DoseQuantity = m.Dose.FHIRQuantity()
+	}
 
 	return []interface{}{fhirImmunization}
 }
 
-// convertImmunizationStatus maps the status to a code in the required FHIR value set:
+// convertAdministrationStatus maps the status of an immunization or medication administration to a code in the
+// required FHIR value set:
 //   http://hl7.org/fhir/DSTU2/valueset-medication-admin-status.html
-func (m *Medication) convertImmunizationStatus() string {
+func (m *Medication) convertAdministrationStatus() string {
 	var status string
 	statusConcept := m.StatusCode.FHIRCodeableConcept("")
 	switch {
@@ -138,3 +677,23 @@ func (m *Medication) convertImmunizationStatus() string {
 
 	return status
 }
+
+// administrationStatusFromFHIR maps the FHIR administration status back to an ActStatus.  It is the inverse of
+// convertAdministrationStatus.  If the status cannot be mapped, nil is returned.
+func administrationStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "stopped":
+		return actStatus("aborted")
+	case "in-progress":
+		return actStatus("active")
+	case "entered-in-error":
+		return actStatus("nullified")
+	case "on-hold":
+		return actStatus("held")
+	case "intended":
+		return actStatus("new")
+	case "completed":
+		return actStatus("completed")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package hdsfhir
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/url"
 
 	fhir "github.com/intervention-engine/fhir/models"
 	"github.com/pebbe/util"
@@ -31,31 +32,93 @@ func (s *MedicationSuite) SetUpSuite(c *C) {
 }
 
 func (s *MedicationSuite) TestMedicationOrdered(c *C) {
-	medication := s.Medications["medicationOrdered"].FHIRModels()[0].(*fhir.MedicationStatement)
+	models := s.Medications["medicationOrdered"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.MedicationOrder{})
+	medication := models[0].(*fhir.MedicationOrder)
+	c.Assert(medication.Id, Equals, s.Medications["medicationOrdered"].GetTempID())
 	c.Assert(medication.Patient, DeepEquals, s.Patient.FHIRReference())
-	c.Assert(medication.Status, Equals, "intended")
-	c.Assert(medication.WasNotTaken, IsNil)
-	c.Assert(medication.ReasonNotTaken, IsNil)
-	c.Assert(medication.EffectivePeriod.Start, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
-	c.Assert(medication.EffectivePeriod.End, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
+	c.Assert(medication.Status, Equals, "active")
+	c.Assert(medication.DateWritten, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
+	c.Assert(medication.DateEnded, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
+	c.Assert(medication.Prescriber, IsNil)
 	c.Assert(medication.MedicationCodeableConcept.Text, Equals, "Medication, Order: BH Antidepressant medication (Code List: 2.16.840.1.113883.3.1257.1.972)")
 	c.Assert(medication.MedicationCodeableConcept.Coding, HasLen, 1)
 	c.Assert(medication.MedicationCodeableConcept.MatchesCode("http://www.nlm.nih.gov/research/umls/rxnorm", "1000048"), Equals, true)
+	c.Assert(medication.DosageInstruction, IsNil)
+	c.Assert(medication.DispenseRequest, IsNil)
+}
+
+func (s *MedicationSuite) TestMedicationOrderedWithFulfillments(c *C) {
+	models := s.Medications["medicationOrderedWithFulfillments"].FHIRModels()
+	c.Assert(models, HasLen, 3)
+	c.Assert(models[0], FitsTypeOf, &fhir.MedicationOrder{})
+	order := models[0].(*fhir.MedicationOrder)
+	c.Assert(order.Status, Equals, "active")
+	c.Assert(*order.DispenseRequest.ExpectedSupplyDuration.Value, Equals, float64(60))
+	c.Assert(order.DispenseRequest.ExpectedSupplyDuration.Unit, Equals, "d")
+	c.Assert(order.DosageInstruction, HasLen, 1)
+	c.Assert(*order.DosageInstruction[0].DoseSimpleQuantity.Value, Equals, float64(5))
+	c.Assert(order.DosageInstruction[0].Route.MatchesCode("urn:oid:2.16.840.1.113883.3.26.1.1", "C38288"), Equals, true)
+
+	for i, date := range []int64{1349179200, 1351771200} {
+		c.Assert(models[i+1], FitsTypeOf, &fhir.MedicationDispense{})
+		dispense := models[i+1].(*fhir.MedicationDispense)
+		c.Assert(dispense.Id, Not(Equals), order.Id)
+		c.Assert(dispense.Status, Equals, "completed")
+		c.Assert(dispense.Patient, DeepEquals, s.Patient.FHIRReference())
+		c.Assert(dispense.AuthorizingPrescription, DeepEquals, []fhir.Reference{{Reference: "urn:uuid:" + order.Id}})
+		c.Assert(dispense.MedicationCodeableConcept, DeepEquals, order.MedicationCodeableConcept)
+		c.Assert(dispense.WhenHandedOver, DeepEquals, NewUnixTime(date).FHIRDateTime())
+		c.Assert(*dispense.Quantity.Value, Equals, float64(30))
+		c.Assert(dispense.Identifier.Value, Equals, "RX-1234")
+		c.Assert(*dispense.DaysSupply.Value, Equals, float64(60))
+		c.Assert(dispense.DosageInstruction, HasLen, 1)
+		c.Assert(*dispense.DosageInstruction[0].DoseSimpleQuantity.Value, Equals, float64(5))
+	}
 }
 
 func (s *MedicationSuite) TestMedicationDispensed(c *C) {
-	medication := s.Medications["medicationDispensed"].FHIRModels()[0].(*fhir.MedicationStatement)
+	models := s.Medications["medicationDispensed"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.MedicationDispense{})
+	medication := models[0].(*fhir.MedicationDispense)
+	c.Assert(medication.Id, Equals, s.Medications["medicationDispensed"].GetTempID())
 	c.Assert(medication.Patient, DeepEquals, s.Patient.FHIRReference())
-	c.Assert(medication.Status, Equals, "intended")
-	c.Assert(medication.WasNotTaken, IsNil)
-	c.Assert(medication.ReasonNotTaken, IsNil)
-	c.Assert(medication.EffectivePeriod.Start, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
-	c.Assert(medication.EffectivePeriod.End, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
+	c.Assert(medication.Status, Equals, "completed")
+	c.Assert(medication.AuthorizingPrescription, IsNil)
+	c.Assert(medication.WhenHandedOver, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
 	c.Assert(medication.MedicationCodeableConcept.Text, Equals, "Medication, Dispensed: BH Antidepressant medication (Code List: 2.16.840.1.113883.3.1257.1.972)")
 	c.Assert(medication.MedicationCodeableConcept.Coding, HasLen, 1)
 	c.Assert(medication.MedicationCodeableConcept.MatchesCode("http://www.nlm.nih.gov/research/umls/rxnorm", "1000048"), Equals, true)
 }
 
+func (s *MedicationSuite) TestMedicationAdministered(c *C) {
+	models := s.Medications["medicationAdministered"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.MedicationAdministration{})
+	medication := models[0].(*fhir.MedicationAdministration)
+	c.Assert(medication.Patient, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(medication.Status, Equals, "completed")
+	c.Assert(medication.WasNotGiven, IsNil)
+	c.Assert(medication.ReasonNotGiven, IsNil)
+	c.Assert(medication.EffectiveTimePeriod.Start, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
+	c.Assert(medication.EffectiveTimePeriod.End, DeepEquals, NewUnixTime(1349094600).FHIRDateTime())
+	c.Assert(medication.MedicationCodeableConcept.MatchesCode("http://www.nlm.nih.gov/research/umls/rxnorm", "1659149"), Equals, true)
+	c.Assert(medication.Dosage.Text, Equals, "")
+	c.Assert(*medication.Dosage.Quantity.Value, Equals, float64(1))
+	c.Assert(medication.Dosage.Quantity.Unit, Equals, "g")
+	c.Assert(medication.Dosage.Route.MatchesCode("urn:oid:2.16.840.1.113883.3.26.1.1", "C38276"), Equals, true)
+}
+
+func (s *MedicationSuite) TestMedicationNotAdministered(c *C) {
+	medication := s.Medications["medicationNotAdministered"].FHIRModels()[0].(*fhir.MedicationAdministration)
+	c.Assert(*medication.WasNotGiven, Equals, true)
+	c.Assert(medication.ReasonNotGiven, HasLen, 1)
+	c.Assert(medication.ReasonNotGiven[0].MatchesCode("http://snomed.info/sct", "416098002"), Equals, true)
+	c.Assert(medication.Dosage, IsNil)
+}
+
 func (s *MedicationSuite) TestMedicationNotOrdered(c *C) {
 	medication := s.Medications["medicationNotOrdered"].FHIRModels()[0].(*fhir.MedicationStatement)
 	c.Assert(medication.Patient, DeepEquals, s.Patient.FHIRReference())
@@ -72,6 +135,18 @@ func (s *MedicationSuite) TestMedicationNotOrdered(c *C) {
 	c.Assert(medication.MedicationCodeableConcept.MatchesCode("http://www.nlm.nih.gov/research/umls/rxnorm", "1000048"), Equals, true)
 }
 
+func (s *MedicationSuite) TestNotDoneDatatypes(c *C) {
+	// Orders, discharge medications, and dispenses that were not done are statements that the medication wasn't taken
+	for _, oid := range []string{"2.16.840.1.113883.3.560.1.117", "2.16.840.1.113883.3.560.1.299", "2.16.840.1.113883.3.560.1.108"} {
+		medication := &Medication{Entry: Entry{Patient: s.Patient, Oid: oid, NegationInd: true,
+			Codes: CodeMap{"RxNorm": []string{"1000048"}}}}
+		models := medication.FHIRModels()
+		c.Assert(models, HasLen, 1)
+		c.Assert(models[0], FitsTypeOf, &fhir.MedicationStatement{})
+		c.Assert(*models[0].(*fhir.MedicationStatement).WasNotTaken, Equals, true)
+	}
+}
+
 func (s *MedicationSuite) TestImmunizationAdministered(c *C) {
 	immunization := s.Medications["immunizationAdministered"].FHIRModels()[0].(*fhir.Immunization)
 	c.Assert(immunization.Status, Equals, "completed")
@@ -100,15 +175,77 @@ func (s *MedicationSuite) TestImmunizationNotAdministered(c *C) {
 	c.Assert(immunization.Explanation.ReasonNotGiven[0].MatchesCode("http://snomed.info/sct", "591000119102"), Equals, true)
 }
 
+func (s *MedicationSuite) TestOrderWithoutStatus(c *C) {
+	// An order without a status is assumed to be active, rather than a draft, even when it is requested
+	medication := &Medication{Entry: Entry{Patient: s.Patient, MoodCode: "RQO", Time: NewUnixTime(1349092800)}}
+	order := medication.FHIRModels()[0].(*fhir.MedicationOrder)
+	c.Assert(order.Status, Equals, "active")
+}
+
 func (s *MedicationSuite) TestNullEndTime(c *C) {
-	medication := s.Medications["nullEndTime"].FHIRModels()[0].(*fhir.MedicationStatement)
+	medication := s.Medications["nullEndTime"].FHIRModels()[0].(*fhir.MedicationOrder)
 	c.Assert(medication.Patient, DeepEquals, s.Patient.FHIRReference())
-	c.Assert(medication.Status, Equals, "intended")
-	c.Assert(medication.WasNotTaken, IsNil)
-	c.Assert(medication.ReasonNotTaken, IsNil)
-	c.Assert(medication.EffectivePeriod.Start, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
-	c.Assert(medication.EffectivePeriod.End, IsNil)
+	c.Assert(medication.Status, Equals, "active")
+	c.Assert(medication.DateWritten, DeepEquals, NewUnixTime(1349092800).FHIRDateTime())
+	c.Assert(medication.DateEnded, IsNil)
 	c.Assert(medication.MedicationCodeableConcept.Text, Equals, "Medication, Order: BH Antidepressant medication (Code List: 2.16.840.1.113883.3.1257.1.972)")
 	c.Assert(medication.MedicationCodeableConcept.Coding, HasLen, 1)
 	c.Assert(medication.MedicationCodeableConcept.MatchesCode("http://www.nlm.nih.gov/research/umls/rxnorm", "1000048"), Equals, true)
 }
+
+func (s *MedicationSuite) TestMedicationWithDosage(c *C) {
+	medication := s.Medications["medicationWithDosage"].FHIRModels()[0].(*fhir.MedicationStatement)
+	c.Assert(medication.Status, Equals, "active")
+	c.Assert(medication.Note, Equals, "Do not take with grapefruit juice")
+	c.Assert(medication.Extension, HasLen, 1)
+	c.Assert(medication.Extension[0].Url, Equals, "http://github.com/intervention-engine/hdsfhir/StructureDefinition/product-form")
+	c.Assert(medication.Extension[0].ValueCodeableConcept.MatchesCode("urn:oid:2.16.840.1.113883.3.26.1.1", "C42998"), Equals, true)
+
+	c.Assert(medication.Dosage, HasLen, 1)
+	dosage := medication.Dosage[0]
+	c.Assert(dosage.Text, Equals, "Take one tablet by mouth every 12 hours")
+	c.Assert(*dosage.QuantitySimpleQuantity.Value, Equals, float64(5))
+	c.Assert(dosage.QuantitySimpleQuantity.Unit, Equals, "mg")
+	c.Assert(dosage.Route.MatchesCode("urn:oid:2.16.840.1.113883.3.26.1.1", "C38288"), Equals, true)
+	c.Assert(dosage.Method.MatchesCode("http://snomed.info/sct", "421521009"), Equals, true)
+	c.Assert(*dosage.Timing.Repeat.Frequency, Equals, int32(1))
+	c.Assert(*dosage.Timing.Repeat.Period, Equals, float64(12))
+	c.Assert(dosage.Timing.Repeat.PeriodUnits, Equals, "h")
+	c.Assert(*dosage.MaxDosePerPeriod.Numerator.Value, Equals, float64(10))
+	c.Assert(dosage.MaxDosePerPeriod.Numerator.Unit, Equals, "mg")
+	c.Assert(*dosage.MaxDosePerPeriod.Denominator.Value, Equals, float64(1))
+	c.Assert(dosage.MaxDosePerPeriod.Denominator.Unit, Equals, "d")
+}
+
+func (s *MedicationSuite) TestMedicationWithoutDosage(c *C) {
+	medication := s.Medications["medicationNotOrdered"].FHIRModels()[0].(*fhir.MedicationStatement)
+	c.Assert(medication.Dosage, IsNil)
+	c.Assert(medication.Note, Equals, "")
+	c.Assert(medication.Extension, IsNil)
+}
+
+func (s *MedicationSuite) TestUnmappableTiming(c *C) {
+	timing := &AdministrationTiming{Period: &Scalar{Value: "1", Unit: "fortnight"}}
+	c.Assert(timing.FHIRTiming(), IsNil)
+	timing = &AdministrationTiming{Period: &Scalar{Value: "", Unit: "h"}}
+	c.Assert(timing.FHIRTiming(), IsNil)
+}
+
+func (s *MedicationSuite) TestConditionalUpdate(c *C) {
+	ordered := s.Medications["medicationOrderedWithFulfillments"]
+	administered := s.Medications["medicationAdministered"]
+	patient := &Patient{Medications: []*Medication{ordered, administered}}
+	ordered.Patient, administered.Patient = patient, patient
+	defer func() { ordered.Patient, administered.Patient = s.Patient, s.Patient }()
+
+	bundle := patient.FHIRTransactionBundle(true)
+	c.Assert(bundle.Entry, HasLen, 5)
+	for i := 1; i < len(bundle.Entry); i++ {
+		c.Assert(bundle.Entry[i].Request.Method, Equals, "PUT")
+	}
+	patientRef := url.QueryEscape("urn:uuid:" + patient.GetTempID())
+	assertURL(c, bundle, 1, "MedicationOrder?code=http://www.nlm.nih.gov/research/umls/rxnorm|197361&datewritten=%s&patient=%s", ld("2012-10-01T12:00:00"), patientRef)
+	assertURL(c, bundle, 2, "MedicationDispense?code=http://www.nlm.nih.gov/research/umls/rxnorm|197361&patient=%s&whenhandedover=%s", patientRef, ld("2012-10-02T12:00:00"))
+	assertURL(c, bundle, 3, "MedicationDispense?code=http://www.nlm.nih.gov/research/umls/rxnorm|197361&patient=%s&whenhandedover=%s", patientRef, ld("2012-11-01T12:00:00"))
+	assertURL(c, bundle, 4, "MedicationAdministration?code=http://www.nlm.nih.gov/research/umls/rxnorm|1659149&effectivetime=sa%s&effectivetime=lt%s&patient=%s", ld("2012-10-01T11:59:59"), ld("2012-10-01T12:00:01"), patientRef)
+}
@@ -0,0 +1,23 @@
+package hdsfhir
+
+import "encoding/json"
+
+// ObjectID is the ID of an HDS document.  Depending on how the HDS data was exported, IDs are either plain strings or
+// MongoDB extended JSON objects (e.g., {"$oid": "..."}), so both are accepted.
+type ObjectID string
+
+func (o *ObjectID) UnmarshalJSON(data []byte) (err error) {
+	var id string
+	if err = json.Unmarshal(data, &id); err == nil {
+		*o = ObjectID(id)
+		return
+	}
+
+	oid := &struct {
+		OID string `json:"$oid"`
+	}{}
+	if err = json.Unmarshal(data, oid); err == nil {
+		*o = ObjectID(oid.OID)
+	}
+	return
+}
@@ -0,0 +1,32 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type Organization struct {
+	TemporallyIdentified
+	Name      string     `json:"name"`
+	Addresses []*Address `json:"addresses"`
+	Telecoms  []*Telecom `json:"telecoms"`
+}
+
+func (o *Organization) FHIRModels() []interface{} {
+	fhirOrganization := &fhir.Organization{}
+	fhirOrganization.Id = o.GetTempID()
+	fhirOrganization.Name = o.Name
+	for _, address := range o.Addresses {
+		fhirOrganization.Address = append(fhirOrganization.Address, address.FHIRAddress())
+	}
+	for _, telecom := range o.Telecoms {
+		fhirOrganization.Telecom = append(fhirOrganization.Telecom, telecom.FHIRContactPoint())
+	}
+
+	return []interface{}{fhirOrganization}
+}
+
+// FromFHIR sets the organization from the FHIR organization.  It is the inverse of FHIRModels.
+func (o *Organization) FromFHIR(fhirOrganization *fhir.Organization) {
+	o.SetTempID(fhirOrganization.Id)
+	o.Name = fhirOrganization.Name
+	o.Addresses = addressesFromFHIR(fhirOrganization.Address)
+	o.Telecoms = telecomsFromFHIR(fhirOrganization.Telecom)
+}
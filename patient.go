@@ -10,21 +10,40 @@ import (
 
 type Patient struct {
 	TemporallyIdentified
-	MedicalRecordNumber string          `json:"medical_record_number"`
-	FirstName           string          `json:"first"`
-	LastName            string          `json:"last"`
-	BirthTime           *UnixTime       `json:"birthdate"`
-	Gender              string          `json:"gender"`
-	Encounters          []*Encounter    `json:"encounters"`
-	Conditions          []*Condition    `json:"conditions"`
-	VitalSigns          []*VitalSign    `json:"vital_signs"`
-	Procedures          []*Procedure    `json:"procedures"`
-	Medications         []*Medication   `json:"medications"`
-	Immunizations       []*Immunization `json:"immunizations"`
-	Allergies           []*Allergy      `json:"allergies"`
-}
+	MedicalRecordNumber  string                 `json:"medical_record_number"`
+	FirstName            string                 `json:"first"`
+	LastName             string                 `json:"last"`
+	BirthTime            *UnixTime              `json:"birthdate"`
+	Gender               string                 `json:"gender"`
+	Expired              bool                   `json:"expired"`
+	DeathTime            *UnixTime              `json:"deathdate"`
+	Race                 *DemographicCode       `json:"race"`
+	Ethnicity            *DemographicCode       `json:"ethnicity"`
+	MaritalStatus        *DemographicCode       `json:"marital_status"`
+	Religion             *DemographicCode       `json:"religious_affiliation"`
+	Languages            []LanguageCode         `json:"languages"`
+	Addresses            []*Address             `json:"addresses"`
+	Telecoms             []*Telecom             `json:"telecoms"`
+	Encounters           []*Encounter           `json:"encounters"`
+	Conditions           []*Condition           `json:"conditions"`
+	VitalSigns           []*VitalSign           `json:"vital_signs"`
+	Procedures           []*Procedure           `json:"procedures"`
+	Medications          []*Medication          `json:"medications"`
+	Immunizations        []*Immunization        `json:"immunizations"`
+	Allergies            []*Allergy             `json:"allergies"`
+	Results              []*LabResult           `json:"results"`
+	SocialHistory        []*SocialHistory       `json:"social_history"`
+	CareGoals            []*CareGoal            `json:"care_goals"`
+	MedicalEquipment     []*MedicalEquipment    `json:"medical_equipment"`
+	InsuranceProviders   []*InsuranceProvider   `json:"insurance_providers"`
+	AdvanceDirectives    []*AdvanceDirective    `json:"advance_directives"`
+	FunctionalStatuses   []*FunctionalStatus    `json:"functional_statuses"`
+	Support              []*Support             `json:"support"`
+	ProviderPerformances []*ProviderPerformance `json:"provider_performances"`
 
-// TODO: :care_goals, :medical_equipment, :results, :social_history, :support, :advance_directives, :insurance_providers, :functional_statuses
+	// performers holds the providers referenced by entries but not found in the provider performances
+	performers []*Provider
+}
 
 func (p *Patient) MatchingEncounterReference(entry Entry) *fhir.Reference {
 	for _, encounter := range p.Encounters {
@@ -70,56 +89,246 @@ func (p *Patient) FHIRModel() *fhir.Patient {
 	if p.BirthTime != nil {
 		fhirPatient.BirthDate = p.BirthTime.FHIRDate()
 	}
+	if deathTime := p.convertDeathTime(); deathTime != nil {
+		fhirPatient.DeceasedDateTime = deathTime.FHIRDateTime()
+	} else if p.Expired {
+		t := true
+		fhirPatient.DeceasedBoolean = &t
+	}
+	for _, address := range p.Addresses {
+		fhirPatient.Address = append(fhirPatient.Address, address.FHIRAddress())
+	}
+	for _, telecom := range p.Telecoms {
+		fhirPatient.Telecom = append(fhirPatient.Telecom, telecom.FHIRContactPoint())
+	}
+	if p.MaritalStatus != nil {
+		fhirPatient.MaritalStatus = p.MaritalStatus.FHIRCodeableConcept("HL7 Marital Status")
+	}
+	for _, language := range p.Languages {
+		// Empty (or null) languages have no code to communicate in
+		if language == "" {
+			continue
+		}
+		// HDS doesn't indicate a preferred language, but the first is the most likely candidate
+		preferred := len(fhirPatient.Communication) == 0
+		fhirPatient.Communication = append(fhirPatient.Communication, fhir.PatientCommunicationComponent{
+			Language:  language.FHIRCodeableConcept(),
+			Preferred: &preferred,
+		})
+	}
+	if p.Race != nil {
+		fhirPatient.Extension = append(fhirPatient.Extension, fhir.Extension{
+			Url:                  raceExtensionURL,
+			ValueCodeableConcept: p.Race.FHIRCodeableConcept("CDC Race"),
+		})
+	}
+	if p.Ethnicity != nil {
+		fhirPatient.Extension = append(fhirPatient.Extension, fhir.Extension{
+			Url:                  ethnicityExtensionURL,
+			ValueCodeableConcept: p.Ethnicity.FHIRCodeableConcept("CDC Race"),
+		})
+	}
+	if p.Religion != nil {
+		fhirPatient.Extension = append(fhirPatient.Extension, fhir.Extension{
+			Url:                  religionExtensionURL,
+			ValueCodeableConcept: p.Religion.FHIRCodeableConcept("Religious Affiliation"),
+		})
+	}
+	for _, provider := range p.careProviders() {
+		fhirPatient.CareProvider = append(fhirPatient.CareProvider, *provider.FHIRReference())
+	}
+	for _, support := range p.Support {
+		if contact := support.FHIRPatientContact(); contact != nil {
+			fhirPatient.Contact = append(fhirPatient.Contact, *contact)
+		}
+	}
 	return fhirPatient
 }
 
+// convertDeathTime returns the time of death, preferring the patient's death date over the time of death recorded
+// on the condition that caused it.  If the patient isn't known to have died, nil is returned.
+func (p *Patient) convertDeathTime() *UnixTime {
+	if p.DeathTime != nil {
+		return p.DeathTime
+	}
+	for _, condition := range p.Conditions {
+		if condition.CauseOfDeath && condition.TimeOfDeath != nil {
+			return condition.TimeOfDeath
+		}
+	}
+	return nil
+}
+
+// FromFHIR sets the patient's demographics from the FHIR patient.  It is the inverse of FHIRModel, except that the
+// care providers and contacts are left to FromFHIRBundle, since they require the related resources.
+func (p *Patient) FromFHIR(fhirPatient *fhir.Patient) {
+	p.SetTempID(fhirPatient.Id)
+	for _, identifier := range fhirPatient.Identifier {
+		if identifier.Type != nil && identifier.Type.MatchesCode("http://hl7.org/fhir/v2/0203", "MR") {
+			p.MedicalRecordNumber = identifier.Value
+			break
+		}
+	}
+	if len(fhirPatient.Name) > 0 {
+		if len(fhirPatient.Name[0].Given) > 0 {
+			p.FirstName = fhirPatient.Name[0].Given[0]
+		}
+		if len(fhirPatient.Name[0].Family) > 0 {
+			p.LastName = fhirPatient.Name[0].Family[0]
+		}
+	}
+	switch fhirPatient.Gender {
+	case "male":
+		p.Gender = "M"
+	case "female":
+		p.Gender = "F"
+	default:
+		p.Gender = "UN"
+	}
+	p.BirthTime = UnixTimeFromFHIR(fhirPatient.BirthDate)
+	if fhirPatient.DeceasedDateTime != nil {
+		p.Expired = true
+		p.DeathTime = UnixTimeFromFHIR(fhirPatient.DeceasedDateTime)
+	} else if fhirPatient.DeceasedBoolean != nil {
+		p.Expired = *fhirPatient.DeceasedBoolean
+	}
+	p.Addresses = addressesFromFHIR(fhirPatient.Address)
+	p.Telecoms = telecomsFromFHIR(fhirPatient.Telecom)
+	p.MaritalStatus = DemographicCodeFromFHIR(fhirPatient.MaritalStatus)
+	for _, communication := range fhirPatient.Communication {
+		if language := LanguageCodeFromFHIR(communication.Language); language != "" {
+			// The preferred language is the first one HDS lists
+			if communication.Preferred != nil && *communication.Preferred {
+				p.Languages = append([]LanguageCode{language}, p.Languages...)
+			} else {
+				p.Languages = append(p.Languages, language)
+			}
+		}
+	}
+	for _, extension := range fhirPatient.Extension {
+		switch extension.Url {
+		case raceExtensionURL:
+			p.Race = DemographicCodeFromFHIR(extension.ValueCodeableConcept)
+		case ethnicityExtensionURL:
+			p.Ethnicity = DemographicCodeFromFHIR(extension.ValueCodeableConcept)
+		case religionExtensionURL:
+			p.Religion = DemographicCodeFromFHIR(extension.ValueCodeableConcept)
+		}
+	}
+}
+
+// FHIRModels returns the FHIR models for the patient and all of its entries.  Entries that cannot be converted are
+// logged and skipped; use Convert to find out which entries had problems.
 func (p *Patient) FHIRModels() []interface{} {
-	var models []interface{}
-	models = append(models, p.FHIRModel())
+	// Any error is also reported in the diagnostics
+	result, _ := p.Convert(ConversionOptions{})
+	for _, diagnostic := range result.Diagnostics {
+		if diagnostic.Severity == SeverityError {
+			log.Println("Error:", diagnostic.String())
+		}
+	}
+	return result.Models
+}
+
+// entries returns the entries from every section of the patient record
+func (p *Patient) entries() []*Entry {
+	var entries []*Entry
 	for _, encounter := range p.Encounters {
-		models = append(models, encounter.FHIRModels()...)
+		entries = append(entries, &encounter.Entry)
 	}
 	for _, condition := range p.Conditions {
-		models = append(models, condition.FHIRModels()...)
+		entries = append(entries, &condition.Entry)
 	}
 	for _, observation := range p.VitalSigns {
-		models = append(models, observation.FHIRModels()...)
+		entries = append(entries, &observation.Entry)
 	}
 	for _, procedure := range p.Procedures {
-		models = append(models, procedure.FHIRModels()...)
+		entries = append(entries, &procedure.Entry)
 	}
 	for _, medication := range p.Medications {
-		models = append(models, medication.FHIRModels()...)
+		entries = append(entries, &medication.Entry)
 	}
 	for _, immunization := range p.Immunizations {
-		models = append(models, immunization.FHIRModels()...)
+		entries = append(entries, &immunization.Entry)
 	}
 	for _, allergy := range p.Allergies {
-		models = append(models, allergy.FHIRModels()...)
+		entries = append(entries, &allergy.Entry)
 	}
-
-	return models
+	for _, result := range p.Results {
+		entries = append(entries, &result.Entry)
+	}
+	for _, socialHistory := range p.SocialHistory {
+		entries = append(entries, &socialHistory.Entry)
+	}
+	for _, goal := range p.CareGoals {
+		entries = append(entries, &goal.Entry)
+	}
+	for _, equipment := range p.MedicalEquipment {
+		entries = append(entries, &equipment.Entry)
+	}
+	for _, insuranceProvider := range p.InsuranceProviders {
+		entries = append(entries, &insuranceProvider.Entry)
+	}
+	for _, directive := range p.AdvanceDirectives {
+		entries = append(entries, &directive.Entry)
+	}
+	for _, functionalStatus := range p.FunctionalStatuses {
+		entries = append(entries, &functionalStatus.Entry)
+	}
+	for _, support := range p.Support {
+		entries = append(entries, &support.Entry)
+	}
+	return entries
 }
 
 // FHIRTransactionBundle returns a FHIR bundle representing a transaction to post all patient data to a server
 func (p *Patient) FHIRTransactionBundle(conditionalUpdate bool) *fhir.Bundle {
+	return transactionBundle(p.FHIRModels(), conditionalUpdateOptions(conditionalUpdate), nil)
+}
+
+// FHIRTransactionBundleWithOptions returns a FHIR bundle representing a transaction to post all patient data to a
+// server, using the conditional strategies selected by the options
+func (p *Patient) FHIRTransactionBundleWithOptions(opts BundleOptions) *fhir.Bundle {
+	return transactionBundle(p.FHIRModels(), opts, nil)
+}
+
+// conditionalUpdateOptions returns the bundle options for the conditionalUpdate flag of FHIRTransactionBundle
+func conditionalUpdateOptions(conditionalUpdate bool) BundleOptions {
+	if conditionalUpdate {
+		return BundleOptions{Conditional: ConditionalUpdate}
+	}
+	return BundleOptions{}
+}
+
+// transactionBundle creates a transaction posting the models.  Models with registered server locations (keyed by
+// full URL) update the registered resources instead.  They keep their "urn:uuid" full URLs, so that references to
+// them still resolve within the transaction.  If the options have an unknown strategy, the error is logged and every
+// model is posted unconditionally.
+func transactionBundle(fhirModels []interface{}, opts BundleOptions, registered map[string]ResourceLocation) *fhir.Bundle {
 	bundle := new(fhir.Bundle)
 	bundle.Type = "transaction"
-	fhirModels := p.FHIRModels()
 	bundle.Entry = make([]fhir.BundleEntryComponent, len(fhirModels))
 	for i := range fhirModels {
+		resourceType := reflect.TypeOf(fhirModels[i]).Elem().Name()
 		bundle.Entry[i].FullUrl = "urn:uuid:" + reflect.ValueOf(fhirModels[i]).Elem().FieldByName("Id").String()
 		bundle.Entry[i].Resource = fhirModels[i]
 		bundle.Entry[i].Request = &fhir.BundleEntryRequestComponent{
 			Method: "POST",
-			Url:    reflect.TypeOf(fhirModels[i]).Elem().Name(),
+			Url:    resourceType,
 		}
-	}
-	if conditionalUpdate {
-		if err := ConvertToConditionalUpdates(bundle); err != nil {
-			log.Println("Error:", err.Error())
+		if location, ok := registered[bundle.Entry[i].FullUrl]; ok && location.ResourceType == resourceType {
+			// Update a copy of the model with the server's ID, so the model's own ID can still be used to convert it
+			resource := reflect.New(reflect.TypeOf(fhirModels[i]).Elem())
+			resource.Elem().Set(reflect.ValueOf(fhirModels[i]).Elem())
+			resource.Elem().FieldByName("Id").SetString(location.ID)
+			bundle.Entry[i].Resource = resource.Interface()
+			bundle.Entry[i].Request.Method = "PUT"
+			bundle.Entry[i].Request.Url = location.Reference()
 		}
 	}
+	if err := ConvertToConditionalRequests(bundle, opts); err != nil {
+		log.Println("Error:", err.Error())
+	}
 	return bundle
 }
 
@@ -130,28 +339,60 @@ func (p *Patient) UnmarshalJSON(data []byte) (err error) {
 	p2 := patient{}
 	if err = json.Unmarshal(data, &p2); err == nil {
 		*p = Patient(p2)
-		for _, encounter := range p.Encounters {
-			encounter.Patient = p
-		}
-		for _, condition := range p.Conditions {
-			condition.Patient = p
-		}
-		for _, observation := range p.VitalSigns {
-			observation.Patient = p
-		}
-		for _, procedure := range p.Procedures {
-			procedure.Patient = p
-		}
-		for _, medication := range p.Medications {
-			medication.Patient = p
-		}
-		for _, immunization := range p.Immunizations {
-			immunization.Patient = p
-		}
-		for _, allergy := range p.Allergies {
-			allergy.Patient = p
-		}
-
+		p.linkEntries()
 	}
 	return
 }
+
+// linkEntries sets the patient back-reference of every entry, links the entries' performers to providers, and links
+// the encounters at the same facility and the coverages from the same payer
+func (p *Patient) linkEntries() {
+	for _, encounter := range p.Encounters {
+		encounter.Patient = p
+	}
+	for _, condition := range p.Conditions {
+		condition.Patient = p
+	}
+	for _, observation := range p.VitalSigns {
+		observation.Patient = p
+	}
+	for _, procedure := range p.Procedures {
+		procedure.Patient = p
+	}
+	for _, medication := range p.Medications {
+		medication.Patient = p
+	}
+	for _, immunization := range p.Immunizations {
+		immunization.Patient = p
+	}
+	for _, allergy := range p.Allergies {
+		allergy.Patient = p
+	}
+	for _, result := range p.Results {
+		result.Patient = p
+	}
+	for _, socialHistory := range p.SocialHistory {
+		socialHistory.Patient = p
+	}
+	for _, goal := range p.CareGoals {
+		goal.Patient = p
+	}
+	for _, equipment := range p.MedicalEquipment {
+		equipment.Patient = p
+	}
+	for _, insuranceProvider := range p.InsuranceProviders {
+		insuranceProvider.Patient = p
+	}
+	for _, directive := range p.AdvanceDirectives {
+		directive.Patient = p
+	}
+	for _, functionalStatus := range p.FunctionalStatuses {
+		functionalStatus.Patient = p
+	}
+	for _, support := range p.Support {
+		support.Patient = p
+	}
+	p.linkProviders()
+	p.linkFacilities()
+	p.linkPayers()
+}
@@ -49,6 +49,75 @@ func (s *PatientSuite) TestPatientFHIRModel(c *C) {
 	c.Assert(model.Identifier[0].Value, Equals, "bc8f60f4cbde3d6c28974971b6880793")
 }
 
+func (s *PatientSuite) TestPatientFHIRModelNotDeceased(c *C) {
+	model := s.Patient.FHIRModel()
+	c.Assert(model.DeceasedBoolean, IsNil)
+	c.Assert(model.DeceasedDateTime, IsNil)
+}
+
+func (s *PatientSuite) TestPatientFHIRModelDeathDate(c *C) {
+	s.Patient.Expired = true
+	s.Patient.DeathTime = NewUnixTime(1362325500)
+	model := s.Patient.FHIRModel()
+	c.Assert(model.DeceasedBoolean, IsNil)
+	c.Assert(model.DeceasedDateTime, DeepEquals, NewUnixTime(1362325500).FHIRDateTime())
+}
+
+func (s *PatientSuite) TestPatientFHIRModelExpiredWithoutDeathDate(c *C) {
+	s.Patient.Expired = true
+	model := s.Patient.FHIRModel()
+	c.Assert(*model.DeceasedBoolean, Equals, true)
+	c.Assert(model.DeceasedDateTime, IsNil)
+}
+
+func (s *PatientSuite) TestPatientFHIRModelCauseOfDeathTime(c *C) {
+	s.Patient.Expired = true
+	s.Patient.Conditions[1].CauseOfDeath = true
+	s.Patient.Conditions[1].TimeOfDeath = NewUnixTime(1362325500)
+	model := s.Patient.FHIRModel()
+	c.Assert(model.DeceasedBoolean, IsNil)
+	c.Assert(model.DeceasedDateTime, DeepEquals, NewUnixTime(1362325500).FHIRDateTime())
+}
+
+func (s *PatientSuite) TestPatientFHIRModelDemographics(c *C) {
+	model := s.Patient.FHIRModel()
+	c.Assert(model.Address, HasLen, 1)
+	c.Assert(model.Address[0].Use, Equals, "home")
+	c.Assert(model.Address[0].Line, DeepEquals, []string{"15 Main Street"})
+	c.Assert(model.Address[0].City, Equals, "Bedford")
+	c.Assert(model.Telecom, HasLen, 1)
+	c.Assert(model.Telecom[0].System, Equals, "phone")
+	c.Assert(model.Telecom[0].Value, Equals, "+1-781-555-1234")
+	c.Assert(model.MaritalStatus.Text, Equals, "Married")
+	c.Assert(model.MaritalStatus.MatchesCode("http://hl7.org/fhir/ValueSet/v3-MaritalStatus", "M"), Equals, true)
+	c.Assert(model.Communication, HasLen, 2)
+	c.Assert(model.Communication[0].Language.MatchesCode("urn:ietf:bcp:47", "en-US"), Equals, true)
+	c.Assert(*model.Communication[0].Preferred, Equals, true)
+	c.Assert(model.Communication[1].Language.MatchesCode("urn:ietf:bcp:47", "es"), Equals, true)
+	c.Assert(*model.Communication[1].Preferred, Equals, false)
+
+	c.Assert(model.Extension, HasLen, 3)
+	c.Assert(model.Extension[0].Url, Equals, "http://hl7.org/fhir/StructureDefinition/us-core-race")
+	c.Assert(model.Extension[0].ValueCodeableConcept.Text, Equals, "White")
+	c.Assert(model.Extension[0].ValueCodeableConcept.MatchesCode("urn:oid:2.16.840.1.113883.6.238", "2106-3"), Equals, true)
+	c.Assert(model.Extension[1].Url, Equals, "http://hl7.org/fhir/StructureDefinition/us-core-ethnicity")
+	c.Assert(model.Extension[1].ValueCodeableConcept.MatchesCode("urn:oid:2.16.840.1.113883.6.238", "2186-5"), Equals, true)
+	c.Assert(model.Extension[2].Url, Equals, "http://hl7.org/fhir/StructureDefinition/us-core-religion")
+	c.Assert(model.Extension[2].ValueCodeableConcept.MatchesCode("http://hl7.org/fhir/ValueSet/v3-ReligiousAffiliation", "1013"), Equals, true)
+}
+
+func (s *PatientSuite) TestEmptyLanguages(c *C) {
+	var languages []LanguageCode
+	util.CheckErr(json.Unmarshal([]byte(`[null, "", {"code": ""}, "es"]`), &languages))
+	s.Patient.Languages = languages
+
+	// Only the languages with codes are communicated, and the first of them is preferred
+	model := s.Patient.FHIRModel()
+	c.Assert(model.Communication, HasLen, 1)
+	c.Assert(model.Communication[0].Language.MatchesCode("urn:ietf:bcp:47", "es"), Equals, true)
+	c.Assert(*model.Communication[0].Preferred, Equals, true)
+}
+
 func (s *PatientSuite) TestPatientFHIRModelWithNoMRN(c *C) {
 	s.Patient.MedicalRecordNumber = ""
 	model := s.Patient.FHIRModel()
@@ -62,7 +131,7 @@ func (s *PatientSuite) TestPatientFHIRModelWithNoMRN(c *C) {
 func (s *PatientSuite) TestFHIRModels(c *C) {
 	models := s.Patient.FHIRModels()
 	patient := s.Patient.FHIRModel()
-	c.Assert(models, HasLen, 21)
+	c.Assert(models, HasLen, 23)
 
 	typeMap := make(map[string]int)
 	for i := range models {
@@ -82,12 +151,14 @@ func (s *PatientSuite) TestFHIRModels(c *C) {
 	}
 
 	// Test the resource type counts
-	c.Assert(typeMap, HasLen, 9)
+	c.Assert(typeMap, HasLen, 11)
 	c.Assert(typeMap["Condition"], Equals, 5)
 	c.Assert(typeMap["DiagnosticReport"], Equals, 1)
+	c.Assert(typeMap["Device"], Equals, 1)
+	c.Assert(typeMap["DeviceUseStatement"], Equals, 1)
 	c.Assert(typeMap["Encounter"], Equals, 4)
 	c.Assert(typeMap["Immunization"], Equals, 2)
-	c.Assert(typeMap["MedicationStatement"], Equals, 1)
+	c.Assert(typeMap["MedicationOrder"], Equals, 1)
 	c.Assert(typeMap["Observation"], Equals, 4)
 	c.Assert(typeMap["Patient"], Equals, 1)
 	c.Assert(typeMap["Procedure"], Equals, 2)
@@ -104,7 +175,7 @@ func (s *PatientSuite) TestFHIRModelReferences(c *C) {
 
 func (s *PatientSuite) TestFHIRTransactionBundle(c *C) {
 	bundle := s.Patient.FHIRTransactionBundle(false)
-	c.Assert(bundle.Entry, HasLen, 21)
+	c.Assert(bundle.Entry, HasLen, 23)
 	c.Assert(bundle.Entry[0].Resource, FitsTypeOf, &fhir.Patient{})
 	patientID := bundle.Entry[0].Resource.(*fhir.Patient).Id
 	patientRef := "urn:uuid:" + patientID
@@ -128,15 +199,21 @@ func (s *PatientSuite) TestFHIRTransactionBundle(c *C) {
 		case *fhir.DiagnosticReport:
 			c.Assert(t.Subject.Reference, Equals, patientRef)
 			c.Assert(bundle.Entry[i].Request.Url, Equals, "DiagnosticReport")
-		case *fhir.MedicationStatement:
+		case *fhir.MedicationOrder:
 			c.Assert(t.Patient.Reference, Equals, patientRef)
-			c.Assert(bundle.Entry[i].Request.Url, Equals, "MedicationStatement")
+			c.Assert(bundle.Entry[i].Request.Url, Equals, "MedicationOrder")
 		case *fhir.Immunization:
 			c.Assert(t.Patient.Reference, Equals, patientRef)
 			c.Assert(bundle.Entry[i].Request.Url, Equals, "Immunization")
 		case *fhir.AllergyIntolerance:
 			c.Assert(t.Patient.Reference, Equals, patientRef)
 			c.Assert(bundle.Entry[i].Request.Url, Equals, "AllergyIntolerance")
+		case *fhir.Device:
+			c.Assert(t.Patient.Reference, Equals, patientRef)
+			c.Assert(bundle.Entry[i].Request.Url, Equals, "Device")
+		case *fhir.DeviceUseStatement:
+			c.Assert(t.Subject.Reference, Equals, patientRef)
+			c.Assert(bundle.Entry[i].Request.Url, Equals, "DeviceUseStatement")
 		default:
 			c.Fail()
 		}
@@ -145,7 +222,7 @@ func (s *PatientSuite) TestFHIRTransactionBundle(c *C) {
 
 func (s *PatientSuite) TestFHIRTransactionBundleConditionalUpdate(c *C) {
 	bundle := s.Patient.FHIRTransactionBundle(true)
-	c.Assert(bundle.Entry, HasLen, 21)
+	c.Assert(bundle.Entry, HasLen, 23)
 	c.Assert(bundle.Entry[0].Resource, FitsTypeOf, &fhir.Patient{})
 	patientID := bundle.Entry[0].Resource.(*fhir.Patient).Id
 	patientRef := url.QueryEscape("urn:uuid:" + patientID)
@@ -169,10 +246,74 @@ func (s *PatientSuite) TestFHIRTransactionBundleConditionalUpdate(c *C) {
 	assertURL(c, bundle, 14, "Observation?code=http://snomed.info/sct|116783008&date=sa%s&date=lt%s&patient=%s&value-concept=http://snomed.info/sct|433571000124104", ld("2011-11-01T12:16:39"), ld("2011-11-01T12:16:41"), patientRef)
 	assertURL(c, bundle, 15, "Observation?code=http://snomed.info/sct|116783008&date=sa%s&date=lt%s&patient=%s&value-concept=http://snomed.info/sct|433491000124102", ld("2011-11-01T12:16:39"), ld("2011-11-01T12:16:41"), patientRef)
 	assertURL(c, bundle, 16, "Procedure?code=http://hl7.org/fhir/sid/icd-10|0210093,http://hl7.org/fhir/sid/icd-9|36.10,http://snomed.info/sct|10190003&date=sa%s&date=lt%s&patient=%s", ld("2013-03-02T15:44:59"), ld("2013-03-02T15:45:01"), patientRef)
-	assertURL(c, bundle, 17, "MedicationStatement?code=http://www.nlm.nih.gov/research/umls/rxnorm|1000048&effectivedate=sa%s&effectivedate=lt%s&patient=%s", ld("2012-10-01T11:59:59"), ld("2012-10-01T12:00:01"), patientRef)
+	assertURL(c, bundle, 17, "MedicationOrder?code=http://www.nlm.nih.gov/research/umls/rxnorm|1000048&datewritten=%s&patient=%s", ld("2012-10-01T12:00:00"), patientRef)
 	assertURL(c, bundle, 18, "Immunization?date=%s&patient=%s&vaccine-code=http://www2a.cdc.gov/vaccines/iis/iisstandards/vaccines.asp%%3Frpt%%3Dcvx|33", ld("2011-08-15T12:00:00"), patientRef)
 	assertURL(c, bundle, 19, "Immunization?date=%s&patient=%s&vaccine-code=http://www2a.cdc.gov/vaccines/iis/iisstandards/vaccines.asp%%3Frpt%%3Dcvx|03", ld("2010-01-11T00:08:28"), patientRef)
 	assertURL(c, bundle, 20, "AllergyIntolerance?substance=http://www2a.cdc.gov/vaccines/iis/iisstandards/vaccines.asp%%3Frpt%%3Dcvx|111&onset=%s&patient=%s", ld("2012-01-01T05:42:00"), patientRef)
+	deviceIdentifier := url.QueryEscape(EntryIdentifierSystem + "|" + findEntryIdentifier(bundle.Entry[21].Resource).Value)
+	assertURL(c, bundle, 21, "Device?identifier=%s", deviceIdentifier)
+	deviceRef := url.QueryEscape("urn:uuid:" + bundle.Entry[21].Resource.(*fhir.Device).Id)
+	assertURL(c, bundle, 22, "DeviceUseStatement?device=%s&patient=%s", deviceRef, patientRef)
+}
+
+func (s *PatientSuite) TestFHIRTransactionBundleConditionalCreate(c *C) {
+	bundle := s.Patient.FHIRTransactionBundleWithOptions(BundleOptions{Conditional: ConditionalCreate})
+	c.Assert(bundle.Entry, HasLen, 23)
+	patientRef := url.QueryEscape("urn:uuid:" + bundle.Entry[0].Resource.(*fhir.Patient).Id)
+	for i := range bundle.Entry {
+		c.Assert(bundle.Entry[i].Request.Method, Equals, "POST")
+		c.Assert(strings.Contains(bundle.Entry[i].Request.Url, "?"), Equals, false)
+		c.Assert(bundle.Entry[i].Request.IfNoneExist, Not(Equals), "")
+	}
+	c.Assert(bundle.Entry[0].Request.IfNoneExist, Equals, "identifier=bc8f60f4cbde3d6c28974971b6880793")
+	assertIfNoneExist(c, bundle, 6, "Condition?code=http://snomed.info/sct|981000124106&onset=%s&patient=%s", ld("2012-03-01T12:05:00"), patientRef)
+}
+
+func (s *PatientSuite) TestFHIRTransactionBundleStrategyByType(c *C) {
+	bundle := s.Patient.FHIRTransactionBundleWithOptions(BundleOptions{
+		Conditional: ConditionalUpdate,
+		ConditionalByType: map[string]ConditionalStrategy{
+			"Observation": ConditionalCreate,
+			"Device":      Unconditional,
+		},
+	})
+	for _, entry := range bundle.Entry {
+		switch entry.Resource.(type) {
+		case *fhir.Observation:
+			c.Assert(entry.Request.Method, Equals, "POST")
+			c.Assert(entry.Request.Url, Equals, "Observation")
+			c.Assert(entry.Request.IfNoneExist, Not(Equals), "")
+		case *fhir.Device:
+			c.Assert(entry.Request.Method, Equals, "POST")
+			c.Assert(entry.Request.Url, Equals, "Device")
+			c.Assert(entry.Request.IfNoneExist, Equals, "")
+		default:
+			c.Assert(entry.Request.Method, Equals, "PUT")
+			c.Assert(entry.Request.IfNoneExist, Equals, "")
+		}
+	}
+}
+
+func (s *PatientSuite) TestUnknownConditionalStrategy(c *C) {
+	bundle := s.Patient.FHIRTransactionBundle(false)
+	err := ConvertToConditionalRequests(bundle, BundleOptions{Conditional: "upsert"})
+	c.Assert(err, ErrorMatches, `unknown conditional strategy "upsert"`)
+
+	// The strategies are checked before any entry is converted, so the bundle isn't left half-converted
+	opts := BundleOptions{
+		Conditional:       ConditionalUpdate,
+		ConditionalByType: map[string]ConditionalStrategy{"Observation": ConditionalCreate, "Device": "upsert"},
+	}
+	err = ConvertToConditionalRequests(bundle, opts)
+	c.Assert(err, ErrorMatches, `unknown conditional strategy "upsert" for Device`)
+	for _, entry := range bundle.Entry {
+		c.Assert(entry.Request.Method, Equals, "POST")
+		c.Assert(entry.Request.IfNoneExist, Equals, "")
+	}
+	bundle = s.Patient.FHIRTransactionBundleWithOptions(opts)
+	for _, entry := range bundle.Entry {
+		c.Assert(entry.Request.Method, Equals, "POST")
+	}
 }
 
 // ld takes in a string utc date and converts to a string date in the local timezone
@@ -198,6 +339,17 @@ func assertURL(c *C, b *fhir.Bundle, i int, u string, args ...interface{}) {
 	c.Assert(obtVals, DeepEquals, expVals)
 }
 
+func assertIfNoneExist(c *C, b *fhir.Bundle, i int, u string, args ...interface{}) {
+	u = fmt.Sprintf(u, args...)
+	obtVals, err := url.ParseQuery(b.Entry[i].Request.IfNoneExist)
+	util.CheckErr(err)
+	expSplit := strings.SplitN(u, "?", 2)
+	expVals, err := url.ParseQuery(expSplit[1])
+	util.CheckErr(err)
+	c.Assert(b.Entry[i].Request.Url, Equals, expSplit[0])
+	c.Assert(obtVals, DeepEquals, expVals)
+}
+
 func (s *PatientSuite) TestFHIRBundleReferences(c *C) {
 	s.doTestFHIRBundleReferences(c, false)
 	s.doTestFHIRBundleReferences(c, true)
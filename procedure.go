@@ -6,6 +6,9 @@ type Procedure struct {
 	Entry
 	AnatomicalTarget *CodeObject   `json:"anatomical_target"`
 	Values           []ResultValue `json:"values"`
+
+	// report identifies the diagnostic report created for the values
+	report TemporallyIdentified
 }
 
 func (p *Procedure) FHIRModels() []interface{} {
@@ -62,13 +65,16 @@ func (p *Procedure) convertProcedure() []interface{} {
 	}
 	fhirProcedure.PerformedPeriod = p.GetFHIRPeriod()
 	fhirProcedure.Encounter = p.Patient.MatchingEncounterReference(p.Entry)
+	performer := p.Patient.PerformerReference(p.PerformerID)
+	if performer != nil {
+		fhirProcedure.Performer = []fhir.ProcedurePerformerComponent{{Actor: performer}}
+	}
 
 	models := []interface{}{fhirProcedure}
 	if len(p.Values) > 0 {
 		// Create the diagnostic report model with its own ID and slots for results
-		internalReportID := &TemporallyIdentified{}
 		fhirReport := &fhir.DiagnosticReport{}
-		fhirReport.Id = internalReportID.GetTempID()
+		fhirReport.Id = p.report.GetTempID()
 		fhirReport.Status = "final"
 		fhirReport.Code = &fhir.CodeableConcept{
 			Coding: []fhir.Coding{
@@ -86,12 +92,13 @@ func (p *Procedure) convertProcedure() []interface{} {
 		if p.EndTime != nil {
 			fhirReport.Issued = p.EndTime.FHIRDateTime() // Not perfect, but it's a required field
 		}
-		// TODO: Technically, "performer" is required, but we don't want to make up data
+		// TODO: Technically, "performer" is required, but we don't want to make up data when HDS doesn't have it
+		fhirReport.Performer = performer
 		fhirReport.Result = make([]fhir.Reference, len(p.Values))
 		models = append(models, fhirReport)
 
 		// Link the procedure to the report
-		fhirProcedure.Report = []fhir.Reference{*internalReportID.FHIRReference()}
+		fhirProcedure.Report = []fhir.Reference{*p.report.FHIRReference()}
 
 		// Create the observation values
 		for i := range p.Values {
@@ -110,6 +117,27 @@ func (p *Procedure) convertProcedure() []interface{} {
 	return models
 }
 
+// FromFHIRProcedure sets the procedure from the FHIR procedure.  It is the inverse of convertProcedure, except that
+// the report values and performer are left to FromFHIRBundle, since they require the related resources.
+func (p *Procedure) FromFHIRProcedure(fhirProcedure *fhir.Procedure) {
+	p.SetTempID(fhirProcedure.Id)
+	p.setFHIRCodes(fhirProcedure.Code)
+	p.StatusCode = procedureStatusFromFHIR(fhirProcedure.Status)
+	p.NegationInd = fhirProcedure.NotPerformed != nil && *fhirProcedure.NotPerformed
+	if len(fhirProcedure.ReasonNotPerformed) > 0 {
+		p.NegationReason = CodeObjectFromFHIR(&fhirProcedure.ReasonNotPerformed[0])
+	}
+	if len(fhirProcedure.BodySite) > 0 {
+		p.AnatomicalTarget = CodeObjectFromFHIR(&fhirProcedure.BodySite[0])
+	}
+	if fhirProcedure.PerformedPeriod != nil {
+		p.setFHIRPeriod(fhirProcedure.PerformedPeriod)
+	} else if fhirProcedure.PerformedDateTime != nil {
+		p.StartTime = UnixTimeFromFHIR(fhirProcedure.PerformedDateTime)
+		p.EndTime = p.StartTime
+	}
+}
+
 // convertProcedureStatus maps the status to a code in the required FHIR value set:
 //   http://hl7.org/fhir/DSTU2/valueset-procedure-status.html
 func (p *Procedure) convertProcedureStatus() string {
@@ -131,6 +159,22 @@ func (p *Procedure) convertProcedureStatus() string {
 	return status
 }
 
+// procedureStatusFromFHIR maps the FHIR procedure status back to an ActStatus.  It is the inverse of
+// convertProcedureStatus.  If the status cannot be mapped, nil is returned.
+func procedureStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "aborted":
+		return actStatus("aborted")
+	case "in-progress":
+		return actStatus("active")
+	case "entered-in-error":
+		return actStatus("obsolete")
+	case "completed":
+		return actStatus("completed")
+	}
+	return nil
+}
+
 func (p *Procedure) convertProcedureRequest() []interface{} {
 	fhirProcedureRequest := &fhir.ProcedureRequest{}
 	fhirProcedureRequest.Id = p.GetTempID()
@@ -153,6 +197,19 @@ func (p *Procedure) convertProcedureRequest() []interface{} {
 	return []interface{}{fhirProcedureRequest}
 }
 
+// FromFHIRProcedureRequest sets the procedure from the FHIR procedure request.  It is the inverse of
+// convertProcedureRequest.  The procedure is always given the request mood, so that it converts back to a request.
+func (p *Procedure) FromFHIRProcedureRequest(fhirProcedureRequest *fhir.ProcedureRequest) {
+	p.SetTempID(fhirProcedureRequest.Id)
+	p.setFHIRCodes(fhirProcedureRequest.Code)
+	p.MoodCode = "RQO"
+	p.StatusCode = procedureRequestStatusFromFHIR(fhirProcedureRequest.Status)
+	if len(fhirProcedureRequest.BodySite) > 0 {
+		p.AnatomicalTarget = CodeObjectFromFHIR(&fhirProcedureRequest.BodySite[0])
+	}
+	p.Time = UnixTimeFromFHIR(fhirProcedureRequest.OrderedOn)
+}
+
 // convertProcedureRequestStatus maps the status to a code in the required FHIR value set:
 //   http://hl7.org/fhir/DSTU2/valueset-procedure-request-status.html
 func (p *Procedure) convertProcedureRequestStatus() string {
@@ -178,3 +235,17 @@ func (p *Procedure) convertProcedureRequestStatus() string {
 
 	return status
 }
+
+// procedureRequestStatusFromFHIR maps the FHIR procedure request status back to an ActStatus.  It is the inverse of
+// convertProcedureRequestStatus.  Statuses that HDS has no equivalent for are treated as orders.
+func procedureRequestStatusFromFHIR(status string) CodeMap {
+	switch status {
+	case "rejected", "aborted":
+		return actStatus("cancelled")
+	case "suspended":
+		return actStatus("suspended")
+	case "proposed":
+		return actStatus("recommended")
+	}
+	return actStatus("ordered")
+}
@@ -0,0 +1,200 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type Provider struct {
+	TemporallyIdentified
+	ID           ObjectID      `json:"_id"`
+	Title        string        `json:"title"`
+	GivenName    string        `json:"given_name"`
+	FamilyName   string        `json:"family_name"`
+	Specialty    string        `json:"specialty"`
+	NPI          string        `json:"npi"`
+	Addresses    []*Address    `json:"addresses"`
+	Telecoms     []*Telecom    `json:"telecoms"`
+	Organization *Organization `json:"organization"`
+}
+
+func (p *Provider) FHIRModels() []interface{} {
+	var models []interface{}
+
+	fhirPractitioner := &fhir.Practitioner{}
+	fhirPractitioner.Id = p.GetTempID()
+	if p.NPI != "" {
+		fhirPractitioner.Identifier = []fhir.Identifier{
+			{
+				Type: &fhir.CodeableConcept{
+					Coding: []fhir.Coding{
+						{System: "http://hl7.org/fhir/v2/0203", Code: "NPI", Display: "National provider identifier"},
+					},
+					Text: "National provider identifier",
+				},
+				System: "http://hl7.org/fhir/sid/us-npi",
+				Value:  p.NPI,
+			},
+		}
+	}
+	if p.GivenName != "" || p.FamilyName != "" {
+		fhirPractitioner.Name = &fhir.HumanName{}
+		if p.GivenName != "" {
+			fhirPractitioner.Name.Given = []string{p.GivenName}
+		}
+		if p.FamilyName != "" {
+			fhirPractitioner.Name.Family = []string{p.FamilyName}
+		}
+		if p.Title != "" {
+			fhirPractitioner.Name.Prefix = []string{p.Title}
+		}
+	}
+	for _, address := range p.Addresses {
+		fhirPractitioner.Address = append(fhirPractitioner.Address, address.FHIRAddress())
+	}
+	for _, telecom := range p.Telecoms {
+		fhirPractitioner.Telecom = append(fhirPractitioner.Telecom, telecom.FHIRContactPoint())
+	}
+
+	if p.Specialty != "" || p.Organization != nil {
+		role := fhir.PractitionerPractitionerRoleComponent{}
+		if p.Specialty != "" {
+			role.Specialty = []fhir.CodeableConcept{
+				{
+					Coding: []fhir.Coding{
+						{System: "http://nucc.org/provider-taxonomy", Code: p.Specialty},
+					},
+				},
+			}
+		}
+		if p.Organization != nil {
+			models = append(models, p.Organization.FHIRModels()...)
+			role.ManagingOrganization = p.Organization.FHIRReference()
+		}
+		fhirPractitioner.PractitionerRole = []fhir.PractitionerPractitionerRoleComponent{role}
+	}
+
+	return append(models, fhirPractitioner)
+}
+
+// FromFHIR sets the provider from the FHIR practitioner, using the practitioner's ID as the HDS ID.  It is the
+// inverse of FHIRModels, except that the organization is left to FromFHIRBundle, since it is a separate resource.
+func (p *Provider) FromFHIR(fhirPractitioner *fhir.Practitioner) {
+	p.SetTempID(fhirPractitioner.Id)
+	p.ID = ObjectID(fhirPractitioner.Id)
+	for _, identifier := range fhirPractitioner.Identifier {
+		if identifier.System == "http://hl7.org/fhir/sid/us-npi" {
+			p.NPI = identifier.Value
+		}
+	}
+	if name := fhirPractitioner.Name; name != nil {
+		if len(name.Given) > 0 {
+			p.GivenName = name.Given[0]
+		}
+		if len(name.Family) > 0 {
+			p.FamilyName = name.Family[0]
+		}
+		if len(name.Prefix) > 0 {
+			p.Title = name.Prefix[0]
+		}
+	}
+	p.Addresses = addressesFromFHIR(fhirPractitioner.Address)
+	p.Telecoms = telecomsFromFHIR(fhirPractitioner.Telecom)
+	for _, role := range fhirPractitioner.PractitionerRole {
+		for _, specialty := range role.Specialty {
+			if len(specialty.Coding) > 0 {
+				p.Specialty = specialty.Coding[0].Code
+			}
+		}
+	}
+}
+
+type ProviderPerformance struct {
+	StartDate  *UnixTime `json:"start_date"`
+	EndDate    *UnixTime `json:"end_date"`
+	ProviderID ObjectID  `json:"provider_id"`
+	// Provider is only present if the HDS export embedded the provider.  Otherwise, a provider will be created with
+	// just the provider ID.
+	Provider *Provider `json:"provider"`
+}
+
+func (pp *ProviderPerformance) provider() *Provider {
+	if pp.Provider == nil {
+		pp.Provider = &Provider{ID: pp.ProviderID}
+	} else if pp.Provider.ID == "" {
+		pp.Provider.ID = pp.ProviderID
+	}
+	return pp.Provider
+}
+
+// PerformerReference returns a reference to the practitioner representing the HDS provider with the given ID.  If
+// the ID is empty, or the provider isn't one of the patient's providers, nil is returned.
+func (p *Patient) PerformerReference(id ObjectID) *fhir.Reference {
+	if provider := p.findProvider(id); provider != nil {
+		return provider.FHIRReference()
+	}
+	return nil
+}
+
+// Providers returns all of the providers that performed care for the patient, followed by any other providers that
+// are referenced as entry performers.  Each provider ID is only returned once, so providers that performed care more
+// than once result in a single practitioner.
+func (p *Patient) Providers() []*Provider {
+	return append(p.careProviders(), p.performers...)
+}
+
+// careProviders returns the providers of the patient's provider performances, leaving out any provider with the same
+// ID as an earlier one
+func (p *Patient) careProviders() []*Provider {
+	var providers []*Provider
+	seen := make(map[ObjectID]bool)
+	for _, performance := range p.ProviderPerformances {
+		provider := performance.provider()
+		if provider.ID == "" || !seen[provider.ID] {
+			seen[provider.ID] = true
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// linkProviders makes sure there is a provider for every performer ID, so that all references to the same provider
+// share the same practitioner.  Performers that aren't in the provider performances get providers with just their
+// IDs, which are kept when the patient is linked again, so their temporary IDs don't change.  (Their practitioners
+// are matched by their entry identifiers, which hold the IDs, in conditional updates.)  It is called before the
+// patient is converted, so that converting it doesn't change the providers.
+func (p *Patient) linkProviders() {
+	linked := make(map[ObjectID]bool)
+	for _, provider := range p.careProviders() {
+		linked[provider.ID] = true
+	}
+	previous := make(map[ObjectID]*Provider)
+	for _, provider := range p.performers {
+		previous[provider.ID] = provider
+	}
+
+	var performers []*Provider
+	for _, entry := range p.entries() {
+		id := entry.PerformerID
+		if id == "" || linked[id] {
+			continue
+		}
+		linked[id] = true
+		provider := previous[id]
+		if provider == nil {
+			provider = &Provider{ID: id}
+		}
+		performers = append(performers, provider)
+	}
+	p.performers = performers
+}
+
+// findProvider finds the provider with the given ID among the patient's providers, or returns nil if there is none
+func (p *Patient) findProvider(id ObjectID) *Provider {
+	if id == "" {
+		return nil
+	}
+	for _, provider := range p.Providers() {
+		if provider.ID == id {
+			return provider
+		}
+	}
+	return nil
+}
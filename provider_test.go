@@ -0,0 +1,189 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type ProviderSuite struct {
+	Patient *Patient
+}
+
+var _ = Suite(&ProviderSuite{})
+
+func (s *ProviderSuite) SetUpTest(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/providers.json")
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	err = json.Unmarshal(data, s.Patient)
+	util.CheckErr(err)
+}
+
+func (s *ProviderSuite) TestObjectIDs(c *C) {
+	c.Assert(s.Patient.ProviderPerformances[0].ProviderID, Equals, ObjectID("5697d8b2c1c1b1a2b3000010"))
+	c.Assert(s.Patient.Encounters[0].ID, Equals, ObjectID("5697d8b2c1c1b1a2b3000002"))
+	c.Assert(s.Patient.Encounters[0].PerformerID, Equals, ObjectID("5697d8b2c1c1b1a2b3000010"))
+	c.Assert(s.Patient.Procedures[0].ID, Equals, ObjectID("5697d8b2c1c1b1a2b3000003"))
+	c.Assert(s.Patient.Procedures[0].PerformerID, Equals, ObjectID("5697d8b2c1c1b1a2b3000011"))
+	c.Assert(s.Patient.Results[1].PerformerID, Equals, ObjectID(""))
+}
+
+func (s *ProviderSuite) TestProviderFHIRModels(c *C) {
+	models := s.Patient.ProviderPerformances[0].Provider.FHIRModels()
+	c.Assert(models, HasLen, 2)
+
+	c.Assert(models[0], FitsTypeOf, &fhir.Organization{})
+	organization := models[0].(*fhir.Organization)
+	c.Assert(organization.Name, Equals, "Princeton-Plainsboro Teaching Hospital")
+
+	c.Assert(models[1], FitsTypeOf, &fhir.Practitioner{})
+	practitioner := models[1].(*fhir.Practitioner)
+	c.Assert(practitioner.Id, Equals, s.Patient.ProviderPerformances[0].Provider.GetTempID())
+	c.Assert(practitioner.Identifier, HasLen, 1)
+	c.Assert(practitioner.Identifier[0].System, Equals, "http://hl7.org/fhir/sid/us-npi")
+	c.Assert(practitioner.Identifier[0].Value, Equals, "1234567893")
+	c.Assert(practitioner.Name.Prefix, DeepEquals, []string{"Dr."})
+	c.Assert(practitioner.Name.Given, DeepEquals, []string{"Gregory"})
+	c.Assert(practitioner.Name.Family, DeepEquals, []string{"House"})
+	c.Assert(practitioner.Address, HasLen, 1)
+	c.Assert(practitioner.Address[0].City, Equals, "Princeton")
+	c.Assert(practitioner.Address[0].Use, Equals, "work")
+	c.Assert(practitioner.Telecom, HasLen, 1)
+	c.Assert(practitioner.Telecom[0].System, Equals, "phone")
+	c.Assert(practitioner.Telecom[0].Value, Equals, "+1-609-555-0100")
+	c.Assert(practitioner.PractitionerRole, HasLen, 1)
+	role := practitioner.PractitionerRole[0]
+	c.Assert(role.ManagingOrganization, DeepEquals, &fhir.Reference{Reference: "urn:uuid:" + organization.Id})
+	c.Assert(role.Specialty, HasLen, 1)
+	c.Assert(role.Specialty[0].MatchesCode("http://nucc.org/provider-taxonomy", "207R00000X"), Equals, true)
+}
+
+func (s *ProviderSuite) TestUnknownProviderFHIRModels(c *C) {
+	models := (&Provider{ID: "5697d8b2c1c1b1a2b3000011"}).FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Practitioner{})
+	practitioner := models[0].(*fhir.Practitioner)
+	c.Assert(practitioner.Identifier, IsNil)
+	c.Assert(practitioner.Name, IsNil)
+	c.Assert(practitioner.PractitionerRole, IsNil)
+}
+
+func (s *ProviderSuite) TestProviders(c *C) {
+	providers := s.Patient.Providers()
+	c.Assert(providers, HasLen, 2)
+	c.Assert(providers[0], Equals, s.Patient.ProviderPerformances[0].Provider)
+	c.Assert(providers[1].ID, Equals, ObjectID("5697d8b2c1c1b1a2b3000011"))
+
+	// The same provider should be returned on subsequent calls so that IDs are stable
+	c.Assert(s.Patient.Providers(), DeepEquals, providers)
+	c.Assert(s.Patient.PerformerReference("5697d8b2c1c1b1a2b3000011"), DeepEquals, providers[1].FHIRReference())
+	c.Assert(s.Patient.PerformerReference(""), IsNil)
+}
+
+func (s *ProviderSuite) TestPerformerReferences(c *C) {
+	house := s.Patient.ProviderPerformances[0].Provider.FHIRReference()
+	other := s.Patient.PerformerReference("5697d8b2c1c1b1a2b3000011")
+
+	patient := s.Patient.FHIRModel()
+	c.Assert(patient.CareProvider, DeepEquals, []fhir.Reference{*house})
+
+	encounter := s.Patient.Encounters[0].FHIRModels()[0].(*fhir.Encounter)
+	c.Assert(encounter.Participant, HasLen, 1)
+	c.Assert(encounter.Participant[0].Individual, DeepEquals, house)
+
+	procedureModels := s.Patient.Procedures[0].FHIRModels()
+	c.Assert(procedureModels, HasLen, 3)
+	procedure := procedureModels[0].(*fhir.Procedure)
+	c.Assert(procedure.Performer, HasLen, 1)
+	c.Assert(procedure.Performer[0].Actor, DeepEquals, other)
+	c.Assert(procedureModels[1].(*fhir.DiagnosticReport).Performer, DeepEquals, other)
+
+	panels := GroupLabResults(s.Patient.Results)
+	c.Assert(panels, HasLen, 1)
+	c.Assert(panels[0].FHIRModels()[0].(*fhir.DiagnosticReport).Performer, DeepEquals, other)
+}
+
+func (s *ProviderSuite) TestFHIRModels(c *C) {
+	models := s.Patient.FHIRModels()
+	c.Assert(models, HasLen, 11)
+	c.Assert(models[0], FitsTypeOf, &fhir.Patient{})
+	c.Assert(models[1], FitsTypeOf, &fhir.Organization{})
+	c.Assert(models[2], FitsTypeOf, &fhir.Practitioner{})
+	c.Assert(models[3], FitsTypeOf, &fhir.Practitioner{})
+	c.Assert(models[4], FitsTypeOf, &fhir.Encounter{})
+
+	for _, ref := range getAllReferences(models) {
+		c.Assert(isReferenceValid(ref, models), Equals, true)
+	}
+}
+
+func (s *ProviderSuite) TestConditionalUpdates(c *C) {
+	bundle := s.Patient.FHIRTransactionBundle(true)
+	c.Assert(bundle.Entry[2].Request.Method, Equals, "PUT")
+	c.Assert(bundle.Entry[2].Request.Url, Equals, "Practitioner?"+url.Values{
+		"identifier": []string{"http://hl7.org/fhir/sid/us-npi|1234567893"},
+	}.Encode())
+
+	// With neither an NPI nor a name, the practitioner is matched by its entry identifier (the HDS provider ID)
+	c.Assert(bundle.Entry[3].Request.Method, Equals, "PUT")
+	c.Assert(bundle.Entry[3].Request.Url, Equals, "Practitioner?"+url.Values{
+		"identifier": []string{EntryIdentifierSystem + "|5697d8b2c1c1b1a2b3000011"},
+	}.Encode())
+}
+
+func (s *ProviderSuite) TestDuplicateProviderPerformances(c *C) {
+	// A second performance by the same provider, without the embedded provider
+	s.Patient.ProviderPerformances = append(s.Patient.ProviderPerformances, &ProviderPerformance{ProviderID: "5697d8b2c1c1b1a2b3000010"})
+
+	c.Assert(s.Patient.Providers(), HasLen, 2)
+	c.Assert(s.Patient.FHIRModel().CareProvider, DeepEquals, []fhir.Reference{*s.Patient.ProviderPerformances[0].Provider.FHIRReference()})
+
+	practitioners := 0
+	for _, model := range s.Patient.FHIRModels() {
+		if _, ok := model.(*fhir.Practitioner); ok {
+			practitioners++
+		}
+	}
+	c.Assert(practitioners, Equals, 2)
+}
+
+func (s *ProviderSuite) TestConvertTwice(c *C) {
+	first, err := s.Patient.Convert(ConversionOptions{})
+	util.CheckErr(err)
+	second, err := s.Patient.Convert(ConversionOptions{})
+	util.CheckErr(err)
+	c.Assert(second.Models, DeepEquals, first.Models)
+	c.Assert(s.Patient.Providers(), HasLen, 2)
+
+	// Performers that are no longer referenced are dropped the next time the patient is converted
+	for _, entry := range s.Patient.entries() {
+		if entry.PerformerID == "5697d8b2c1c1b1a2b3000011" {
+			entry.PerformerID = ""
+		}
+	}
+	third, err := s.Patient.Convert(ConversionOptions{})
+	util.CheckErr(err)
+	c.Assert(third.Models, HasLen, len(first.Models)-1)
+	c.Assert(s.Patient.Providers(), HasLen, 1)
+}
+
+func (s *ProviderSuite) TestUnknownPerformer(c *C) {
+	// Performers are linked to providers when the patient is converted, not when their references are converted
+	s.Patient.Encounters[0].PerformerID = "5697d8b2c1c1b1a2b3000012"
+	c.Assert(s.Patient.PerformerReference("5697d8b2c1c1b1a2b3000012"), IsNil)
+	c.Assert(s.Patient.Providers(), HasLen, 2)
+
+	result, err := s.Patient.Convert(ConversionOptions{})
+	util.CheckErr(err)
+	c.Assert(s.Patient.Providers(), HasLen, 3)
+	c.Assert(s.Patient.PerformerReference("5697d8b2c1c1b1a2b3000012"), NotNil)
+	for _, ref := range getAllReferences(result.Models) {
+		c.Assert(isReferenceValid(ref, result.Models), Equals, true)
+	}
+}
@@ -11,6 +11,9 @@ type ResultValue struct {
 	TemporallyIdentified
 	Physical *PhysicalQuantityResult
 	Coded    *CodedResult
+	// err holds the problem decoding the value, if any.  Rather than failing to decode the whole patient, the entry
+	// with the value is reported when the patient is converted.
+	err error
 }
 
 func (v *ResultValue) FHIRModels() []interface{} {
@@ -18,43 +21,126 @@ func (v *ResultValue) FHIRModels() []interface{} {
 	observation.Id = v.GetTempID()
 	observation.Status = "final"
 	if v.Physical != nil {
-		if val, err := strconv.ParseFloat(v.Physical.Scalar, 64); err == nil {
-			observation.ValueQuantity = &fhir.Quantity{Unit: v.Physical.Unit, Value: &val}
+		if quantity := v.Physical.FHIRQuantity(); quantity != nil {
+			observation.ValueQuantity = quantity
 		} else {
 			observation.ValueString = v.Physical.Scalar
 		}
-	} else {
+	} else if v.Coded != nil {
 		observation.ValueCodeableConcept = v.Coded.Codes.FHIRCodeableConcept(v.Coded.Description)
 	}
 
 	return []interface{}{observation}
 }
 
-func (v *ResultValue) UnmarshalJSON(data []byte) (err error) {
+// FromFHIR sets the value from the FHIR observation's value.  It is the inverse of FHIRModels.  It returns false if
+// the observation has no value.
+func (v *ResultValue) FromFHIR(observation *fhir.Observation) bool {
+	v.SetTempID(observation.Id)
+	return v.setFHIRValue(observation.ValueQuantity, observation.ValueCodeableConcept, observation.ValueString)
+}
+
+// setFHIRValue sets the value from whichever of the FHIR values is present, returning false if none are
+func (v *ResultValue) setFHIRValue(quantity *fhir.Quantity, concept *fhir.CodeableConcept, str string) bool {
+	switch {
+	case quantity != nil:
+		v.Physical = &PhysicalQuantityResult{Unit: quantity.Unit}
+		if quantity.Value != nil {
+			v.Physical.Scalar = strconv.FormatFloat(*quantity.Value, 'f', -1, 64)
+		}
+	case concept != nil:
+		v.Coded = &CodedResult{Codes: CodeMapFromFHIR(concept), Description: concept.Text}
+	case str != "":
+		v.Physical = &PhysicalQuantityResult{Scalar: str}
+	default:
+		return false
+	}
+	return true
+}
+
+func (v *ResultValue) UnmarshalJSON(data []byte) error {
 	// check if we have a coded or physical result value
 	type ValueType struct {
 		Type string `json:"_type"`
 	}
 	t := &ValueType{}
-	json.Unmarshal(data, t)
+	if v.err = json.Unmarshal(data, t); v.err != nil {
+		return nil
+	}
 
 	switch t.Type {
 	case "CodedResultValue":
 		local := &CodedResult{}
-		json.Unmarshal(data, local)
+		v.err = json.Unmarshal(data, local)
 		v.Coded = local
-	case "PhysicalQuantityResultValue":
-		local := &PhysicalQuantityResult{}
-		json.Unmarshal(data, local)
-		v.Physical = local
 	default:
 		local := &PhysicalQuantityResult{}
-		json.Unmarshal(data, local)
+		v.err = json.Unmarshal(data, local)
 		v.Physical = local
 	}
 
 	return nil
+}
 
+// valueObservations creates one observation per result value, setting the code, subject, encounter, and effective
+// period from the entry.  If there are no values, a single observation with no value is created using the entry's
+// own ID.
+func valueObservations(e *Entry, values []ResultValue) []*fhir.Observation {
+	var observations []*fhir.Observation
+	if len(values) == 0 {
+		observation := &fhir.Observation{}
+		observation.Id = e.GetTempID()
+		observation.Status = "final"
+		observations = append(observations, observation)
+	}
+	for i := range values {
+		observations = append(observations, values[i].FHIRModels()[0].(*fhir.Observation))
+	}
+
+	for _, observation := range observations {
+		observation.Code = e.Codes.FHIRCodeableConcept(e.Description)
+		observation.Subject = e.Patient.FHIRReference()
+		observation.Encounter = e.Patient.MatchingEncounterReference(*e)
+		observation.EffectivePeriod = e.GetFHIRPeriod()
+	}
+
+	return observations
+}
+
+// setFHIRObservation sets the entry's codes and times from the observation and returns the observation's value, if
+// it has one.  It is the inverse of valueObservations for a single observation.
+func (e *Entry) setFHIRObservation(observation *fhir.Observation) []ResultValue {
+	e.setFHIRCodes(observation.Code)
+	if observation.EffectivePeriod != nil {
+		e.setFHIRPeriod(observation.EffectivePeriod)
+	} else if observation.EffectiveDateTime != nil {
+		e.StartTime = UnixTimeFromFHIR(observation.EffectiveDateTime)
+		e.EndTime = e.StartTime
+	}
+
+	// Like valueObservations, the observation ID belongs to the value if there is one, and to the entry otherwise
+	values := make([]ResultValue, 1)
+	if values[0].FromFHIR(observation) {
+		return values
+	}
+	e.SetTempID(observation.Id)
+	return nil
+}
+
+// hasObservationCategory indicates if the observation has the given code in the FHIR observation category value set
+func hasObservationCategory(observation *fhir.Observation, code string) bool {
+	return observation.Category != nil && observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", code)
+}
+
+// observationCategory returns a concept in the "example" FHIR observation category value set:
+//   http://hl7.org/fhir/DSTU2/valueset-observation-category.html
+func observationCategory(code, display string) *fhir.CodeableConcept {
+	return &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "http://hl7.org/fhir/observation-category", Code: code, Display: display},
+		},
+		Text: display,
+	}
 }
 
 // Result Types
@@ -63,6 +149,40 @@ type PhysicalQuantityResult struct {
 	Scalar string `json:"scalar"`
 }
 
+// UnmarshalJSON accepts the scalar as either a string or a number, since HDS exports both (see Scalar)
+func (p *PhysicalQuantityResult) UnmarshalJSON(data []byte) (err error) {
+	raw := &struct {
+		Unit   string          `json:"unit"`
+		Scalar json.RawMessage `json:"scalar"`
+	}{}
+	if err = json.Unmarshal(data, raw); err != nil {
+		return
+	}
+
+	p.Unit = raw.Unit
+	p.Scalar, err = decodeScalar(raw.Scalar)
+	return
+}
+
+// FHIRQuantity returns the result as a FHIR quantity, or nil if the scalar is not numeric
+func (p *PhysicalQuantityResult) FHIRQuantity() *fhir.Quantity {
+	val, err := strconv.ParseFloat(p.Scalar, 64)
+	if err != nil {
+		return nil
+	}
+	return &fhir.Quantity{Unit: p.Unit, Value: &val}
+}
+
+// physicalQuantityFromFHIR returns the quantity as a physical quantity result, or nil if there is no quantity
+func physicalQuantityFromFHIR(quantity *fhir.Quantity) *PhysicalQuantityResult {
+	if quantity == nil {
+		return nil
+	}
+	v := &ResultValue{}
+	v.setFHIRValue(quantity, nil, "")
+	return v.Physical
+}
+
 type CodedResult struct {
 	Codes       CodeMap `json:"codes"`
 	Description string  `json:"description"`
@@ -0,0 +1,93 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type SocialHistory struct {
+	Entry
+	Values []ResultValue `json:"values"`
+}
+
+// smokingStatusCodes contains the SNOMED-CT codes in the ONC smoking status value set:
+//   https://phinvads.cdc.gov/vads/ViewValueSet.action?oid=2.16.840.1.113883.11.20.9.38
+var smokingStatusCodes = []string{
+	"449868002",       // Current every day smoker
+	"428041000124106", // Current some day smoker
+	"8517006",         // Former smoker
+	"266919005",       // Never smoker
+	"77176002",        // Smoker, current status unknown
+	"266927001",       // Unknown if ever smoked
+	"428071000124103", // Heavy tobacco smoker
+	"428061000124105", // Light tobacco smoker
+}
+
+func (s *SocialHistory) FHIRModels() []interface{} {
+	var observations []*fhir.Observation
+	switch {
+	case s.isSmokingStatusValue():
+		// The entry code is the smoking status itself, so it becomes the value of a 72166-2 observation
+		observation := &fhir.Observation{}
+		observation.Id = s.GetTempID()
+		observation.Status = "final"
+		observation.Code = smokingStatusConcept()
+		observation.ValueCodeableConcept = s.Codes.FHIRCodeableConcept(s.Description)
+		observation.Subject = s.Patient.FHIRReference()
+		observation.Encounter = s.Patient.MatchingEncounterReference(s.Entry)
+		observation.EffectivePeriod = s.GetFHIRPeriod()
+		observations = append(observations, observation)
+	default:
+		observations = valueObservations(&s.Entry, s.Values)
+	}
+
+	models := make([]interface{}, len(observations))
+	for i, observation := range observations {
+		observation.Category = observationCategory("social-history", "Social History")
+		// A negated entry records that the observation wasn't made (e.g., the smoking status wasn't assessed), which
+		// is what the "cancelled" status means: "the measurement was not started or not completed".  Functional
+		// statuses are negated the same way.
+		if s.NegationInd {
+			observation.Status = "cancelled"
+		}
+		models[i] = observation
+	}
+
+	return models
+}
+
+// FromFHIR sets the social history from the FHIR observation.  It is the inverse of FHIRModels.
+func (s *SocialHistory) FromFHIR(observation *fhir.Observation) {
+	s.Values = s.setFHIRObservation(observation)
+	s.NegationInd = observation.Status == "cancelled"
+	if observation.Code != nil && observation.Code.MatchesCode("http://loinc.org", "72166-2") &&
+		len(s.Values) == 1 && s.Values[0].Coded != nil {
+
+		// The smoking status is the entry code, rather than the value of a smoking status question
+		smokingStatus := &SocialHistory{Entry: Entry{Codes: s.Values[0].Coded.Codes}}
+		if smokingStatus.isSmokingStatusValue() {
+			s.SetTempID(observation.Id)
+			s.Codes = s.Values[0].Coded.Codes
+			s.Description = s.Values[0].Coded.Description
+			s.Values = nil
+		}
+	}
+}
+
+// isSmokingStatusValue indicates if the entry is coded using one of the smoking status values (as opposed to the
+// 72166-2 smoking status question, which is handled like any other coded observation)
+func (s *SocialHistory) isSmokingStatusValue() bool {
+	concept := s.Codes.FHIRCodeableConcept("")
+	for _, code := range smokingStatusCodes {
+		if concept.MatchesCode("http://snomed.info/sct", code) {
+			return true
+		}
+	}
+	return false
+}
+
+func smokingStatusConcept() *fhir.CodeableConcept {
+	return &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "http://loinc.org", Code: "72166-2", Display: "Tobacco smoking status NHIS"},
+		},
+		Text: "Tobacco smoking status NHIS",
+	}
+}
@@ -0,0 +1,84 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type SocialHistorySuite struct {
+	Patient       *Patient
+	SocialHistory map[string]*SocialHistory
+	Encounter     *Encounter
+}
+
+var _ = Suite(&SocialHistorySuite{})
+
+func (s *SocialHistorySuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/social_history.json")
+	util.CheckErr(err)
+
+	s.SocialHistory = make(map[string]*SocialHistory)
+	err = json.Unmarshal(data, &s.SocialHistory)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	s.Encounter = &Encounter{Entry: Entry{StartTime: NewUnixTime(1320148800), EndTime: NewUnixTime(1320152400)}}
+	s.Patient.Encounters = []*Encounter{s.Encounter}
+	for _, socialHistory := range s.SocialHistory {
+		socialHistory.Patient = s.Patient
+	}
+}
+
+func (s *SocialHistorySuite) TestSmokingStatus(c *C) {
+	models := s.SocialHistory["currentSmoker"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Status, Equals, "final")
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "social-history"), Equals, true)
+	c.Assert(observation.Code.Coding, HasLen, 1)
+	c.Assert(observation.Code.MatchesCode("http://loinc.org", "72166-2"), Equals, true)
+	c.Assert(observation.ValueCodeableConcept.Text, Equals, "Patient Characteristic: Current Every Day Smoker")
+	c.Assert(observation.ValueCodeableConcept.MatchesCode("http://snomed.info/sct", "449868002"), Equals, true)
+	c.Assert(observation.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(observation.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(observation.EffectivePeriod.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+	c.Assert(observation.EffectivePeriod.End, IsNil)
+}
+
+func (s *SocialHistorySuite) TestNegatedSmokingStatus(c *C) {
+	models := s.SocialHistory["notSmoker"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Status, Equals, "cancelled")
+	c.Assert(observation.Code.MatchesCode("http://loinc.org", "72166-2"), Equals, true)
+	c.Assert(observation.ValueCodeableConcept.MatchesCode("http://snomed.info/sct", "77176002"), Equals, true)
+
+	// The negation survives converting back
+	restored := &SocialHistory{}
+	restored.FromFHIR(observation)
+	c.Assert(restored.NegationInd, Equals, true)
+	c.Assert(restored.Codes, DeepEquals, s.SocialHistory["notSmoker"].Codes)
+}
+
+func (s *SocialHistorySuite) TestGenericSocialHistory(c *C) {
+	models := s.SocialHistory["alcoholUse"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	observation := models[0].(*fhir.Observation)
+	c.Assert(observation.Status, Equals, "final")
+	c.Assert(observation.Category.MatchesCode("http://hl7.org/fhir/observation-category", "social-history"), Equals, true)
+	c.Assert(observation.Code.Text, Equals, "Alcoholic drinks per day")
+	c.Assert(observation.Code.MatchesCode("http://loinc.org", "74013-4"), Equals, true)
+	c.Assert(*observation.ValueQuantity.Value, Equals, float64(2))
+	c.Assert(observation.ValueQuantity.Unit, Equals, "{drinks}/d")
+	c.Assert(observation.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(observation.Encounter, IsNil)
+}
@@ -0,0 +1,155 @@
+package hdsfhir
+
+import fhir "github.com/intervention-engine/fhir/models"
+
+type Support struct {
+	Entry
+	// Type is one of "Guardian", "Next of Kin", "Caregiver", or "Emergency Contact"
+	Type         string     `json:"type"`
+	Relationship string     `json:"relationship"`
+	Title        string     `json:"title"`
+	GivenName    string     `json:"given_name"`
+	FamilyName   string     `json:"family_name"`
+	Addresses    []*Address `json:"addresses"`
+	Telecoms     []*Telecom `json:"telecoms"`
+}
+
+func (s *Support) FHIRModels() []interface{} {
+	fhirRelatedPerson := &fhir.RelatedPerson{}
+	fhirRelatedPerson.Id = s.GetTempID()
+	fhirRelatedPerson.Patient = s.Patient.FHIRReference()
+	fhirRelatedPerson.Relationship = s.convertRelationship()
+	fhirRelatedPerson.Name = s.convertName()
+	fhirRelatedPerson.Telecom = s.convertTelecoms()
+	fhirRelatedPerson.Address = s.convertAddresses()
+	fhirRelatedPerson.Period = s.GetFHIRPeriod()
+
+	return []interface{}{fhirRelatedPerson}
+}
+
+// FHIRPatientContact returns the support as a contact for the patient resource, or nil if it isn't an emergency
+// contact.
+func (s *Support) FHIRPatientContact() *fhir.PatientContactComponent {
+	if s.Type != "Emergency Contact" {
+		return nil
+	}
+
+	contact := &fhir.PatientContactComponent{}
+	contact.Relationship = []fhir.CodeableConcept{
+		{
+			Coding: []fhir.Coding{
+				{System: "http://hl7.org/fhir/patient-contact-relationship", Code: "emergency", Display: "Emergency"},
+			},
+			Text: "Emergency",
+		},
+	}
+	if relationship := s.convertRelationship(); relationship != nil {
+		contact.Relationship = append(contact.Relationship, *relationship)
+	}
+	contact.Name = s.convertName()
+	contact.Telecom = s.convertTelecoms()
+	if len(s.Addresses) > 0 {
+		address := s.Addresses[0].FHIRAddress()
+		contact.Address = &address
+	}
+	contact.Period = s.GetFHIRPeriod()
+
+	return contact
+}
+
+// FromFHIR sets the support from the FHIR related person.  It is the inverse of FHIRModels.  The type of support isn't
+// recorded, except for emergency contacts, which FromFHIRPatientContact identifies.
+func (s *Support) FromFHIR(fhirRelatedPerson *fhir.RelatedPerson) {
+	s.SetTempID(fhirRelatedPerson.Id)
+	s.setFHIRRelationship(fhirRelatedPerson.Relationship)
+	s.setFHIRName(fhirRelatedPerson.Name)
+	s.Telecoms = telecomsFromFHIR(fhirRelatedPerson.Telecom)
+	s.Addresses = addressesFromFHIR(fhirRelatedPerson.Address)
+	s.setFHIRPeriod(fhirRelatedPerson.Period)
+}
+
+// FromFHIRPatientContact sets the support from a contact on the patient resource.  It is the inverse of
+// FHIRPatientContact, so if the contact is an emergency contact, so is the support.
+func (s *Support) FromFHIRPatientContact(contact *fhir.PatientContactComponent) {
+	for i := range contact.Relationship {
+		relationship := &contact.Relationship[i]
+		if relationship.MatchesCode("http://hl7.org/fhir/patient-contact-relationship", "emergency") {
+			s.Type = "Emergency Contact"
+		} else {
+			s.setFHIRRelationship(relationship)
+		}
+	}
+	s.setFHIRName(contact.Name)
+	s.Telecoms = telecomsFromFHIR(contact.Telecom)
+	if contact.Address != nil {
+		s.Addresses = []*Address{AddressFromFHIR(*contact.Address)}
+	}
+	s.setFHIRPeriod(contact.Period)
+}
+
+// convertRelationship uses the HL7 Relationship Code from the codes, if present, with the HDS relationship as the
+// text.  If there are no codes and no relationship, nil is returned.
+func (s *Support) convertRelationship() *fhir.CodeableConcept {
+	if len(s.Codes) == 0 && s.Relationship == "" {
+		return nil
+	}
+	return s.Codes.FHIRCodeableConcept(s.Relationship)
+}
+
+// setFHIRRelationship sets the codes and relationship from the concept.  It is the inverse of convertRelationship.
+func (s *Support) setFHIRRelationship(relationship *fhir.CodeableConcept) {
+	if relationship == nil {
+		return
+	}
+	s.Codes = CodeMapFromFHIR(relationship)
+	s.Relationship = relationship.Text
+}
+
+func (s *Support) convertName() *fhir.HumanName {
+	if s.GivenName == "" && s.FamilyName == "" {
+		return nil
+	}
+	name := &fhir.HumanName{}
+	if s.GivenName != "" {
+		name.Given = []string{s.GivenName}
+	}
+	if s.FamilyName != "" {
+		name.Family = []string{s.FamilyName}
+	}
+	if s.Title != "" {
+		name.Prefix = []string{s.Title}
+	}
+	return name
+}
+
+// setFHIRName sets the names from the human name.  It is the inverse of convertName.
+func (s *Support) setFHIRName(name *fhir.HumanName) {
+	if name == nil {
+		return
+	}
+	if len(name.Given) > 0 {
+		s.GivenName = name.Given[0]
+	}
+	if len(name.Family) > 0 {
+		s.FamilyName = name.Family[0]
+	}
+	if len(name.Prefix) > 0 {
+		s.Title = name.Prefix[0]
+	}
+}
+
+func (s *Support) convertAddresses() []fhir.Address {
+	var addresses []fhir.Address
+	for _, address := range s.Addresses {
+		addresses = append(addresses, address.FHIRAddress())
+	}
+	return addresses
+}
+
+func (s *Support) convertTelecoms() []fhir.ContactPoint {
+	var telecoms []fhir.ContactPoint
+	for _, telecom := range s.Telecoms {
+		telecoms = append(telecoms, telecom.FHIRContactPoint())
+	}
+	return telecoms
+}
@@ -0,0 +1,86 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type SupportSuite struct {
+	Patient *Patient
+	Support map[string]*Support
+}
+
+var _ = Suite(&SupportSuite{})
+
+func (s *SupportSuite) SetUpSuite(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/support.json")
+	util.CheckErr(err)
+
+	s.Support = make(map[string]*Support)
+	err = json.Unmarshal(data, &s.Support)
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	for _, support := range s.Support {
+		support.Patient = s.Patient
+	}
+}
+
+func (s *SupportSuite) TestEmergencyContact(c *C) {
+	models := s.Support["emergencyContact"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.RelatedPerson{})
+
+	person := models[0].(*fhir.RelatedPerson)
+	c.Assert(person.Patient, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(person.Relationship.Text, Equals, "Wife")
+	c.Assert(person.Relationship.MatchesCode("urn:oid:2.16.840.1.113883.1.11.18877", "WIFE"), Equals, true)
+	c.Assert(person.Name.Given, DeepEquals, []string{"Jane"})
+	c.Assert(person.Name.Family, DeepEquals, []string{"Peters"})
+	c.Assert(person.Name.Prefix, DeepEquals, []string{"Mrs."})
+	c.Assert(person.Address, HasLen, 1)
+	c.Assert(person.Address[0].Use, Equals, "home")
+	c.Assert(person.Address[0].Line, DeepEquals, []string{"15 Main Street", "Apt 2"})
+	c.Assert(person.Address[0].PostalCode, Equals, "01730")
+	c.Assert(person.Telecom, HasLen, 2)
+	c.Assert(person.Telecom[0].System, Equals, "phone")
+	c.Assert(person.Telecom[0].Use, Equals, "mobile")
+	c.Assert(person.Telecom[0].Value, Equals, "+1-781-555-1212")
+	c.Assert(person.Telecom[1].System, Equals, "email")
+	c.Assert(person.Telecom[1].Value, Equals, "jane@example.com")
+	c.Assert(person.Telecom[1].Rank, IsNil)
+	c.Assert(person.Period.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
+
+	contact := s.Support["emergencyContact"].FHIRPatientContact()
+	c.Assert(contact.Relationship, HasLen, 2)
+	c.Assert(contact.Relationship[0].MatchesCode("http://hl7.org/fhir/patient-contact-relationship", "emergency"), Equals, true)
+	c.Assert(contact.Relationship[1].MatchesCode("urn:oid:2.16.840.1.113883.1.11.18877", "WIFE"), Equals, true)
+	c.Assert(contact.Name, DeepEquals, person.Name)
+	c.Assert(contact.Telecom, DeepEquals, person.Telecom)
+	c.Assert(*contact.Address, DeepEquals, person.Address[0])
+}
+
+func (s *SupportSuite) TestCaregiver(c *C) {
+	person := s.Support["caregiver"].FHIRModels()[0].(*fhir.RelatedPerson)
+	c.Assert(person.Patient, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(person.Relationship.Text, Equals, "Neighbor")
+	c.Assert(person.Relationship.Coding, HasLen, 0)
+	c.Assert(person.Name.Given, DeepEquals, []string{"Sam"})
+	c.Assert(person.Name.Prefix, IsNil)
+	c.Assert(person.Address, IsNil)
+	c.Assert(person.Telecom, IsNil)
+	c.Assert(person.Period, IsNil)
+
+	c.Assert(s.Support["caregiver"].FHIRPatientContact(), IsNil)
+}
+
+func (s *SupportSuite) TestPatientContacts(c *C) {
+	patient := &Patient{Support: []*Support{s.Support["caregiver"], s.Support["emergencyContact"]}}
+	model := patient.FHIRModel()
+	c.Assert(model.Contact, HasLen, 1)
+	c.Assert(model.Contact[0].Name.Given, DeepEquals, []string{"Jane"})
+}
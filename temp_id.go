@@ -4,21 +4,30 @@ import (
 	"sync"
 
 	fhir "github.com/intervention-engine/fhir/models"
-	"github.com/satori/go.uuid"
 )
 
 type TemporallyIdentified struct {
-	once   sync.Once `json:"-"`
-	tempID string    `json:"-"`
+	mu     sync.Mutex `json:"-"`
+	tempID string     `json:"-"`
 }
 
+// GetTempID returns the ID, generating a random one the first time if no ID has been set
 func (t *TemporallyIdentified) GetTempID() string {
-	t.once.Do(func() {
-		t.tempID = uuid.NewV4().String()
-	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tempID == "" {
+		t.tempID = RandomIDs{}.NewID("")
+	}
 	return t.tempID
 }
 
+// SetTempID replaces the ID, so that it can be assigned by an ID strategy rather than generated randomly
+func (t *TemporallyIdentified) SetTempID(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tempID = id
+}
+
 func (t *TemporallyIdentified) FHIRReference() *fhir.Reference {
 	return &fhir.Reference{Reference: "urn:uuid:" + t.GetTempID()}
 }
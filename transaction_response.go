@@ -0,0 +1,189 @@
+package hdsfhir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	fhir "github.com/intervention-engine/fhir/models"
+)
+
+// ResourceLocation identifies a resource (and optionally, a version of it) on a FHIR server
+type ResourceLocation struct {
+	ResourceType string
+	ID           string
+	VersionID    string
+}
+
+// ParseResourceLocation parses an absolute or relative resource location, such as "Patient/123/_history/1" or
+// "http://example.org/fhir/Patient/123"
+func ParseResourceLocation(location string) (ResourceLocation, error) {
+	path := location
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	var l ResourceLocation
+	if n := len(parts); n >= 4 && parts[n-2] == "_history" {
+		l = ResourceLocation{ResourceType: parts[n-4], ID: parts[n-3], VersionID: parts[n-1]}
+	} else if n >= 2 {
+		l = ResourceLocation{ResourceType: parts[n-2], ID: parts[n-1]}
+	}
+	if l.ResourceType == "" || l.ID == "" || strings.Contains(l.ResourceType, ":") {
+		return ResourceLocation{}, fmt.Errorf("invalid resource location %q", location)
+	}
+	return l, nil
+}
+
+// Reference returns the relative reference to the resource (without the version), e.g., "Patient/123"
+func (l ResourceLocation) Reference() string {
+	return l.ResourceType + "/" + l.ID
+}
+
+func (l ResourceLocation) String() string {
+	if l.VersionID == "" {
+		return l.Reference()
+	}
+	return l.Reference() + "/_history/" + l.VersionID
+}
+
+// ResponseLocation returns the location of the resource created or updated by a transaction-response entry.  If the
+// location has no version, the version is taken from the ETag.
+func ResponseLocation(entry *fhir.BundleEntryComponent) (ResourceLocation, error) {
+	if entry.Response == nil {
+		return ResourceLocation{}, errors.New("no response")
+	}
+	if !strings.HasPrefix(entry.Response.Status, "2") {
+		return ResourceLocation{}, fmt.Errorf("unexpected status %q", entry.Response.Status)
+	}
+	location := entry.Response.Location
+	if location == "" {
+		location = entry.FullUrl
+	}
+	if location == "" {
+		return ResourceLocation{}, errors.New("no location")
+	}
+	l, err := ParseResourceLocation(location)
+	if err == nil && l.VersionID == "" {
+		// e.g., W/"3"
+		l.VersionID = strings.Trim(strings.TrimPrefix(entry.Response.Etag, "W/"), `"`)
+	}
+	return l, err
+}
+
+// TransactionResponseLocations pairs each entry of the transaction bundle with the corresponding entry of the
+// server's transaction-response bundle, and returns the server location of each resource keyed by the full URL of
+// its request entry (e.g., "urn:uuid:...")
+func TransactionResponseLocations(request, response *fhir.Bundle) (map[string]ResourceLocation, error) {
+	if response.Type != "transaction-response" {
+		return nil, fmt.Errorf("expected a transaction-response bundle, got %q", response.Type)
+	}
+	if len(response.Entry) != len(request.Entry) {
+		return nil, fmt.Errorf("expected %d entries in the transaction response, got %d", len(request.Entry), len(response.Entry))
+	}
+
+	locations := make(map[string]ResourceLocation)
+	for i := range response.Entry {
+		location, err := ResponseLocation(&response.Entry[i])
+		if err != nil {
+			return nil, fmt.Errorf("transaction response entry %d: %v", i, err)
+		}
+		if request.Entry[i].FullUrl != "" {
+			locations[request.Entry[i].FullUrl] = location
+		}
+	}
+	return locations, nil
+}
+
+// EntryKey identifies an HDS entry by its section (using the JSON name, e.g., "vital_signs") and its position in the
+// section.  The patient itself has an empty section, and its providers are in the "providers" section, in the order
+// returned by Patient.Providers.
+type EntryKey struct {
+	Section string
+	Index   int
+}
+
+// EntryLocations holds the server locations of the resources converted from an HDS entry
+type EntryLocations struct {
+	// ID is the HDS ID of the entry, if it has one
+	ID ObjectID
+	// Resource is the location of the resource with the entry's own ID, if it was uploaded.  A diastolic blood
+	// pressure that was converted as part of the systolic blood pressure's observation has that observation's location.
+	Resource *ResourceLocation
+	// Related holds the locations of the other resources converted from the entry, such as a procedure's diagnostic
+	// report and result observations, or a provider's organization
+	Related []ResourceLocation
+}
+
+// EntryLocationMap maps HDS entries to the server locations of the resources converted from them
+type EntryLocationMap map[EntryKey]*EntryLocations
+
+// ByID returns the locations for the entry with the given HDS ID, or nil if there is none
+func (m EntryLocationMap) ByID(id ObjectID) *EntryLocations {
+	if id == "" {
+		return nil
+	}
+	for _, locations := range m {
+		if locations.ID == id {
+			return locations
+		}
+	}
+	return nil
+}
+
+// MapTransactionResponse links the resources the server created or updated for the transaction bundle converted
+// from the patient back to the patient's entries.  The patient's temporary IDs must not have changed since the
+// bundle was converted.
+func (p *Patient) MapTransactionResponse(request, response *fhir.Bundle) (EntryLocationMap, error) {
+	locations, err := TransactionResponseLocations(request, response)
+	if err != nil {
+		return nil, err
+	}
+	return p.MapLocations(locations), nil
+}
+
+// MapLocations links server locations, keyed by the full URLs of the resources converted from the patient (e.g.,
+// "urn:uuid:..."), back to the patient's entries.  This is useful when the patient was uploaded in several
+// transactions.  Entries with no uploaded resources are left out.
+func (p *Patient) MapLocations(locations map[string]ResourceLocation) EntryLocationMap {
+	m := make(EntryLocationMap)
+	for _, resource := range p.identifiedResources() {
+		location, ok := locations["urn:uuid:"+resource.id.GetTempID()]
+		if !ok {
+			continue
+		}
+		entryLocations, ok := m[resource.key]
+		if !ok {
+			entryLocations = &EntryLocations{ID: resource.hdsID}
+			m[resource.key] = entryLocations
+		}
+		if resource.path == "" {
+			entryLocations.Resource = &location
+		} else {
+			entryLocations.Related = append(entryLocations.Related, location)
+		}
+	}
+	p.mapPairedVitalSigns(m)
+	return m
+}
+
+// mapPairedVitalSigns gives each diastolic vital sign that was paired with a systolic one (see GroupVitalSigns) the
+// location of their blood pressure panel, since the panel only has the systolic vital sign's ID
+func (p *Patient) mapPairedVitalSigns(m EntryLocationMap) {
+	indexes := make(map[*VitalSign]int)
+	for i, vitalSign := range p.VitalSigns {
+		indexes[vitalSign] = i
+	}
+	for _, panel := range GroupVitalSigns(p.VitalSigns) {
+		if len(panel) != 2 {
+			continue
+		}
+		systolic, ok := m[EntryKey{Section: "vital_signs", Index: indexes[panel[0]]}]
+		if !ok || systolic.Resource == nil {
+			continue
+		}
+		location := *systolic.Resource
+		m[EntryKey{Section: "vital_signs", Index: indexes[panel[1]]}] = &EntryLocations{ID: panel[1].ID, Resource: &location}
+	}
+}
@@ -0,0 +1,158 @@
+package hdsfhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+type TransactionResponseSuite struct {
+	Patient *Patient
+	Request *fhir.Bundle
+}
+
+var _ = Suite(&TransactionResponseSuite{})
+
+func (s *TransactionResponseSuite) SetUpTest(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/john_peters.json")
+	util.CheckErr(err)
+
+	s.Patient = &Patient{}
+	err = json.Unmarshal(data, s.Patient)
+	util.CheckErr(err)
+	s.Request = s.Patient.FHIRTransactionBundle(false)
+}
+
+// response returns the transaction response a server would send if it created each resource with a sequential ID
+func (s *TransactionResponseSuite) response() *fhir.Bundle {
+	response := &fhir.Bundle{Type: "transaction-response"}
+	for i, entry := range s.Request.Entry {
+		resourceType := reflect.TypeOf(entry.Resource).Elem().Name()
+		response.Entry = append(response.Entry, fhir.BundleEntryComponent{
+			Response: &fhir.BundleEntryResponseComponent{
+				Status:   "201 Created",
+				Location: fmt.Sprintf("http://example.org/fhir/%s/%d/_history/1", resourceType, i+1),
+			},
+		})
+	}
+	return response
+}
+
+func (s *TransactionResponseSuite) TestMapTransactionResponse(c *C) {
+	s.Patient.Conditions[2].ID = "5697d8b2c1c1b1a2b3000021"
+	m, err := s.Patient.MapTransactionResponse(s.Request, s.response())
+	c.Assert(err, IsNil)
+
+	patient := m[EntryKey{}]
+	c.Assert(patient, NotNil)
+	c.Assert(*patient.Resource, Equals, ResourceLocation{ResourceType: "Patient", ID: "1", VersionID: "1"})
+	c.Assert(patient.Related, HasLen, 0)
+
+	condition := m[EntryKey{Section: "conditions", Index: 2}]
+	c.Assert(condition.ID, Equals, ObjectID("5697d8b2c1c1b1a2b3000021"))
+	c.Assert(condition.Resource.ResourceType, Equals, "Condition")
+	c.Assert(m.ByID(s.Patient.Conditions[2].ID), Equals, condition)
+
+	// The procedure with results also has a diagnostic report and an observation per result
+	procedure := m[EntryKey{Section: "procedures", Index: 0}]
+	c.Assert(procedure.Resource.ResourceType, Equals, "Procedure")
+	c.Assert(procedure.Related, HasLen, 1+len(s.Patient.Procedures[0].Values))
+	c.Assert(procedure.Related[0].ResourceType, Equals, "DiagnosticReport")
+	c.Assert(procedure.Related[1].ResourceType, Equals, "Observation")
+
+	equipment := m[EntryKey{Section: "medical_equipment", Index: 0}]
+	c.Assert(equipment.Resource.ResourceType, Equals, "DeviceUseStatement")
+	c.Assert(equipment.Related, HasLen, 1)
+	c.Assert(equipment.Related[0].ResourceType, Equals, "Device")
+
+	// Every resource is accounted for exactly once
+	seen := make(map[ResourceLocation]bool)
+	for _, locations := range m {
+		all := locations.Related
+		if locations.Resource != nil {
+			all = append(all, *locations.Resource)
+		}
+		for _, location := range all {
+			c.Assert(seen[location], Equals, false)
+			seen[location] = true
+		}
+	}
+	c.Assert(seen, HasLen, len(s.Request.Entry))
+}
+
+func (s *TransactionResponseSuite) TestMapPairedVitalSigns(c *C) {
+	data, err := ioutil.ReadFile("./fixtures/vital_signs.json")
+	util.CheckErr(err)
+	var vitalSigns map[string]*VitalSign
+	util.CheckErr(json.Unmarshal(data, &vitalSigns))
+	vitalSigns["systolic"].ID = "5697d8b2c1c1b1a2b3000030"
+	vitalSigns["diastolic"].ID = "5697d8b2c1c1b1a2b3000031"
+	s.Patient.VitalSigns = append(s.Patient.VitalSigns, vitalSigns["systolic"], vitalSigns["diastolic"])
+	s.Patient.linkEntries()
+	s.Request = s.Patient.FHIRTransactionBundle(false)
+
+	m, err := s.Patient.MapTransactionResponse(s.Request, s.response())
+	c.Assert(err, IsNil)
+	systolic, diastolic := m.ByID("5697d8b2c1c1b1a2b3000030"), m.ByID("5697d8b2c1c1b1a2b3000031")
+	c.Assert(systolic, NotNil)
+	c.Assert(diastolic, NotNil)
+	c.Assert(diastolic.Resource, NotNil)
+	c.Assert(*diastolic.Resource, Equals, *systolic.Resource)
+	c.Assert(diastolic.Resource.ResourceType, Equals, "Observation")
+}
+
+func (s *TransactionResponseSuite) TestMapLocationsSkipsMissingResources(c *C) {
+	m := s.Patient.MapLocations(map[string]ResourceLocation{
+		s.Request.Entry[0].FullUrl: {ResourceType: "Patient", ID: "1"},
+	})
+	c.Assert(m, HasLen, 1)
+	c.Assert(m.ByID(s.Patient.Conditions[0].ID), IsNil)
+}
+
+func (s *TransactionResponseSuite) TestMismatchedResponse(c *C) {
+	response := s.response()
+	response.Entry = response.Entry[1:]
+	_, err := s.Patient.MapTransactionResponse(s.Request, response)
+	c.Assert(err, ErrorMatches, "expected 23 entries in the transaction response, got 22")
+
+	response = s.response()
+	response.Type = "batch-response"
+	_, err = s.Patient.MapTransactionResponse(s.Request, response)
+	c.Assert(err, NotNil)
+
+	response = s.response()
+	response.Entry[3].Response.Status = "400 Bad Request"
+	_, err = s.Patient.MapTransactionResponse(s.Request, response)
+	c.Assert(err, ErrorMatches, `transaction response entry 3: unexpected status "400 Bad Request"`)
+}
+
+func (s *TransactionResponseSuite) TestResponseLocationFromETag(c *C) {
+	location, err := ResponseLocation(&fhir.BundleEntryComponent{
+		Response: &fhir.BundleEntryResponseComponent{Status: "200 OK", Location: "Condition/abc", Etag: `W/"3"`},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(location, Equals, ResourceLocation{ResourceType: "Condition", ID: "abc", VersionID: "3"})
+}
+
+func (s *TransactionResponseSuite) TestParseResourceLocation(c *C) {
+	location, err := ParseResourceLocation("http://example.org/fhir/Patient/123/_history/2")
+	c.Assert(err, IsNil)
+	c.Assert(location, Equals, ResourceLocation{ResourceType: "Patient", ID: "123", VersionID: "2"})
+	c.Assert(location.Reference(), Equals, "Patient/123")
+	c.Assert(location.String(), Equals, "Patient/123/_history/2")
+
+	location, err = ParseResourceLocation("Condition/abc")
+	c.Assert(err, IsNil)
+	c.Assert(location, Equals, ResourceLocation{ResourceType: "Condition", ID: "abc"})
+	c.Assert(location.String(), Equals, "Condition/abc")
+
+	_, err = ParseResourceLocation("urn:uuid:4cf474dd-d6fb-4c23-adc5-9eb178cb2d2e")
+	c.Assert(err, NotNil)
+	_, err = ParseResourceLocation("")
+	c.Assert(err, NotNil)
+}
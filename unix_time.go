@@ -24,3 +24,11 @@ func (t *UnixTime) FHIRDateTime() *fhir.FHIRDateTime {
 func (t *UnixTime) FHIRDate() *fhir.FHIRDateTime {
 	return &fhir.FHIRDateTime{Time: t.Time(), Precision: fhir.Date}
 }
+
+// UnixTimeFromFHIR returns the FHIR date/time as a UnixTime, or nil if it is nil
+func UnixTimeFromFHIR(dateTime *fhir.FHIRDateTime) *UnixTime {
+	if dateTime == nil {
+		return nil
+	}
+	return NewUnixTime(dateTime.Time.Unix())
+}
@@ -0,0 +1,173 @@
+package upload
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/hdsfhir"
+)
+
+// transactionEntry is a bundle entry in its generic JSON form, so that its references can be found and rewritten
+// without knowing the resource type
+type transactionEntry struct {
+	fullURL string
+	json    map[string]interface{}
+	// references holds the full URLs of the other entries the entry refers to
+	references []string
+}
+
+func newTransactionEntries(bundle *fhir.Bundle) ([]*transactionEntry, error) {
+	entries := make([]*transactionEntry, len(bundle.Entry))
+	for i := range bundle.Entry {
+		data, err := json.Marshal(&bundle.Entry[i])
+		if err != nil {
+			return nil, err
+		}
+		entry := &transactionEntry{fullURL: bundle.Entry[i].FullUrl}
+		if err := json.Unmarshal(data, &entry.json); err != nil {
+			return nil, err
+		}
+		entry.rewriteReferences(func(ref string) string {
+			entry.references = append(entry.references, ref)
+			return ref
+		})
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// rewriteReferences replaces each "urn:uuid" reference in the entry's resource and request (including the search
+// parameters of conditional requests) with the result of the function
+func (e *transactionEntry) rewriteReferences(fn func(ref string) string) {
+	for key, value := range e.json {
+		switch key {
+		case "resource":
+			e.json[key] = rewriteJSONReferences(value, fn)
+		case "request":
+			if request, ok := value.(map[string]interface{}); ok {
+				if u, ok := request["url"].(string); ok {
+					if i := strings.Index(u, "?"); i >= 0 {
+						request["url"] = u[:i+1] + rewriteQueryReferences(u[i+1:], fn)
+					}
+				}
+				if query, ok := request["ifNoneExist"].(string); ok {
+					request["ifNoneExist"] = rewriteQueryReferences(query, fn)
+				}
+			}
+		}
+	}
+}
+
+func rewriteJSONReferences(value interface{}, fn func(ref string) string) interface{} {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		for key, v := range t {
+			if ref, ok := v.(string); ok && key == "reference" && strings.HasPrefix(ref, "urn:uuid:") {
+				t[key] = fn(ref)
+			} else {
+				t[key] = rewriteJSONReferences(v, fn)
+			}
+		}
+	case []interface{}:
+		for i, v := range t {
+			t[i] = rewriteJSONReferences(v, fn)
+		}
+	}
+	return value
+}
+
+func rewriteQueryReferences(query string, fn func(ref string) string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+	changed := false
+	for _, vs := range values {
+		for i, v := range vs {
+			if strings.HasPrefix(v, "urn:uuid:") {
+				vs[i] = fn(v)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return query
+	}
+	return values.Encode()
+}
+
+// transaction is a set of entries to post together
+type transaction []*transactionEntry
+
+// body returns the JSON transaction bundle for the entries, with the references to resources created by earlier
+// transactions replaced by references to the server's resources
+func (t transaction) body(locations map[string]hdsfhir.ResourceLocation) []byte {
+	entries := make([]interface{}, len(t))
+	for i, entry := range t {
+		entry.rewriteReferences(func(ref string) string {
+			if location, ok := locations[ref]; ok {
+				return location.Reference()
+			}
+			return ref
+		})
+		entries[i] = entry.json
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         "transaction",
+		"entry":        entries,
+	})
+	return data
+}
+
+// splitTransaction splits the entries into transactions of at most maxEntries entries (unless maxEntries is 0).  A
+// transaction only ends where none of its entries refer to entries after it, so references to later entries can
+// still be resolved within the transaction.  If there is no such place within maxEntries, the transaction ends at
+// the first place possible.
+func splitTransaction(entries []*transactionEntry, maxEntries int) []transaction {
+	indexes := make(map[string]int)
+	for i, entry := range entries {
+		if entry.fullURL != "" {
+			indexes[entry.fullURL] = i
+		}
+	}
+	// reach holds the last entry each entry refers to (or itself)
+	reach := make([]int, len(entries))
+	for i, entry := range entries {
+		reach[i] = i
+		for _, ref := range entry.references {
+			if j, ok := indexes[ref]; ok && j > reach[i] {
+				reach[i] = j
+			}
+		}
+	}
+
+	var transactions []transaction
+	for start := 0; start < len(entries); {
+		end := len(entries)
+		if maxEntries > 0 {
+			best, furthest := 0, start
+			for i := start; i < len(entries); i++ {
+				if reach[i] > furthest {
+					furthest = reach[i]
+				}
+				if furthest == i {
+					// The transaction can end after entry i
+					if best == 0 || i+1-start <= maxEntries {
+						best = i + 1
+					}
+					if i+1-start >= maxEntries {
+						break
+					}
+				}
+			}
+			end = best
+		}
+		transactions = append(transactions, transaction(entries[start:end]))
+		start = end
+	}
+	return transactions
+}
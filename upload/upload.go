@@ -0,0 +1,203 @@
+// Package upload posts the transaction bundles converted by hdsfhir to a FHIR server.
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/hdsfhir"
+)
+
+// Defaults used by NewClient
+const (
+	DefaultMaxEntries = 500
+	DefaultMaxRetries = 3
+	DefaultBackoff    = time.Second
+)
+
+// Client uploads transaction bundles to a FHIR server
+type Client struct {
+	// BaseURL is the FHIR server's base URL, which transactions are posted to
+	BaseURL string
+	// HTTPClient is used to make the requests.  If it isn't set, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// MaxEntries is the most entries to post in a single transaction.  Larger bundles are split into several
+	// transactions.  If it is 0, bundles are never split.
+	MaxEntries int
+	// MaxRetries is the number of times a transaction is retried after a transient failure (a network error, or a
+	// 408, 429, 500, 502, 503, or 504 response)
+	MaxRetries int
+	// Backoff is how long to wait before the first retry.  The wait doubles with each retry, unless the server asks
+	// for a longer one using the Retry-After header.
+	Backoff time.Duration
+}
+
+// NewClient returns a client for the FHIR server at the base URL, using the default limits
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		MaxEntries: DefaultMaxEntries,
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// UploadResult holds the outcome of an upload
+type UploadResult struct {
+	// Responses holds the transaction-response bundle for each transaction that was posted, in order
+	Responses []*fhir.Bundle
+	// Locations maps the full URL of each uploaded entry (e.g., "urn:uuid:...") to the resource's location on the
+	// server.  Patient.MapLocations links them back to the HDS entries.
+	Locations map[string]hdsfhir.ResourceLocation
+}
+
+// Upload posts the transaction bundle to the server, splitting it into several transactions if it has more than
+// MaxEntries entries.  Each transaction only ends between entries that don't refer ahead to each other, and the
+// "urn:uuid" references to resources created by earlier transactions are replaced with references to the server's
+// resources, so every reference can be resolved.  A transaction may be larger than MaxEntries if there is no such
+// place to split it.
+//
+// Since each transaction is atomic but the transactions aren't, an error part way through leaves the resources from
+// earlier transactions on the server.  The result returned with the error describes them.  Note that retrying a
+// transaction whose response was lost may create duplicates, unless the bundle uses conditional updates.
+func (c *Client) Upload(bundle *fhir.Bundle) (*UploadResult, error) {
+	if bundle.Type != "transaction" {
+		return nil, fmt.Errorf("expected a transaction bundle, got %q", bundle.Type)
+	}
+	entries, err := newTransactionEntries(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UploadResult{Locations: make(map[string]hdsfhir.ResourceLocation)}
+	for _, transaction := range splitTransaction(entries, c.MaxEntries) {
+		response, err := c.post(transaction.body(result.Locations))
+		if err != nil {
+			return result, err
+		}
+		if len(response.Entry) != len(transaction) {
+			return result, fmt.Errorf("expected %d entries in the transaction response, got %d", len(transaction), len(response.Entry))
+		}
+		result.Responses = append(result.Responses, response)
+
+		for i, entry := range transaction {
+			location, err := hdsfhir.ResponseLocation(&response.Entry[i])
+			if err != nil {
+				return result, fmt.Errorf("transaction response entry %d: %v", i, err)
+			}
+			if entry.fullURL != "" {
+				result.Locations[entry.fullURL] = location
+			}
+		}
+	}
+	return result, nil
+}
+
+// StatusError is returned when the server rejects a transaction
+type StatusError struct {
+	StatusCode int
+	// Outcome is the operation outcome the server returned, if any
+	Outcome *fhir.OperationOutcome
+	Body    string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("server responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Outcome != nil {
+		var diagnostics []string
+		for _, issue := range e.Outcome.Issue {
+			if issue.Diagnostics != "" {
+				diagnostics = append(diagnostics, issue.Diagnostics)
+			}
+		}
+		if len(diagnostics) > 0 {
+			msg += ": " + strings.Join(diagnostics, "; ")
+		}
+	}
+	return msg
+}
+
+// transient indicates if the request might succeed if it's retried
+func (e *StatusError) transient() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, 429, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// post posts the transaction, retrying transient failures, and returns the transaction-response bundle
+func (c *Client) post(body []byte) (*fhir.Bundle, error) {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		response, retryAfter, err := c.postOnce(body)
+		if err == nil {
+			return response, nil
+		}
+		if statusErr, ok := err.(*StatusError); (ok && !statusErr.transient()) || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// postOnce posts the transaction and returns the response, along with the wait the server asked for (if any) when
+// the request fails
+func (c *Client) postOnce(body []byte) (*fhir.Bundle, time.Duration, error) {
+	req, err := http.NewRequest("POST", c.BaseURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json+fhir")
+	req.Header.Set("Accept", "application/json+fhir")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Body: string(data)}
+		outcome := &fhir.OperationOutcome{}
+		if json.Unmarshal(data, outcome) == nil && len(outcome.Issue) > 0 {
+			statusErr.Outcome = outcome
+		}
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, retryAfter, statusErr
+	}
+
+	response := &fhir.Bundle{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, 0, fmt.Errorf("couldn't parse the transaction response: %v", err)
+	}
+	if response.Type != "transaction-response" {
+		return nil, 0, fmt.Errorf("expected a transaction-response bundle, got %q", response.Type)
+	}
+	return response, 0, nil
+}
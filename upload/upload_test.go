@@ -0,0 +1,253 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fhir "github.com/intervention-engine/fhir/models"
+	"github.com/intervention-engine/hdsfhir"
+	"github.com/pebbe/util"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type UploadSuite struct {
+	Server *httptest.Server
+	// Transactions holds the entries of each transaction the server received
+	Transactions [][]map[string]interface{}
+	// Failures is the number of requests to fail (with FailureStatus) before succeeding
+	Failures      int
+	FailureStatus int
+	Requests      int
+	Patient       *hdsfhir.Patient
+	Bundle        *fhir.Bundle
+}
+
+var _ = Suite(&UploadSuite{})
+
+func (s *UploadSuite) SetUpTest(c *C) {
+	s.Transactions = nil
+	s.Failures = 0
+	s.FailureStatus = http.StatusServiceUnavailable
+	s.Requests = 0
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	data, err := ioutil.ReadFile("../fixtures/john_peters.json")
+	util.CheckErr(err)
+	s.Patient = &hdsfhir.Patient{}
+	util.CheckErr(json.Unmarshal(data, s.Patient))
+	s.Bundle = s.Patient.FHIRTransactionBundle(false)
+}
+
+func (s *UploadSuite) TearDownTest(c *C) {
+	s.Server.Close()
+}
+
+// serve stands in for a FHIR server, creating every resource in the transaction with a sequential ID
+func (s *UploadSuite) serve(w http.ResponseWriter, r *http.Request) {
+	s.Requests++
+	if s.Failures > 0 {
+		s.Failures--
+		w.WriteHeader(s.FailureStatus)
+		fmt.Fprint(w, `{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "transient", "diagnostics": "Try again"}]}`)
+		return
+	}
+
+	var bundle struct {
+		Type  string
+		Entry []map[string]interface{}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil || bundle.Type != "transaction" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.Transactions = append(s.Transactions, bundle.Entry)
+
+	response := &fhir.Bundle{Type: "transaction-response"}
+	for _, entry := range bundle.Entry {
+		resourceType := entry["resource"].(map[string]interface{})["resourceType"].(string)
+		id := len(s.Transactions)*1000 + len(response.Entry)
+		response.Entry = append(response.Entry, fhir.BundleEntryComponent{
+			Response: &fhir.BundleEntryResponseComponent{
+				Status:   "201 Created",
+				Location: fmt.Sprintf("%s/%s/%d/_history/1", s.Server.URL, resourceType, id),
+			},
+		})
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *UploadSuite) client() *Client {
+	client := NewClient(s.Server.URL)
+	client.Backoff = time.Millisecond
+	return client
+}
+
+func (s *UploadSuite) TestUpload(c *C) {
+	result, err := s.client().Upload(s.Bundle)
+	c.Assert(err, IsNil)
+	c.Assert(s.Transactions, HasLen, 1)
+	c.Assert(s.Transactions[0], HasLen, len(s.Bundle.Entry))
+	c.Assert(result.Responses, HasLen, 1)
+	c.Assert(result.Locations, HasLen, len(s.Bundle.Entry))
+
+	for i, entry := range s.Bundle.Entry {
+		location := result.Locations[entry.FullUrl]
+		c.Assert(location.ResourceType, Equals, reflect.TypeOf(entry.Resource).Elem().Name())
+		c.Assert(location.ID, Equals, fmt.Sprintf("%d", 1000+i))
+		c.Assert(location.VersionID, Equals, "1")
+	}
+}
+
+func (s *UploadSuite) TestSplitUpload(c *C) {
+	client := s.client()
+	client.MaxEntries = 4
+	result, err := client.Upload(s.Bundle)
+	c.Assert(err, IsNil)
+	c.Assert(result.Locations, HasLen, len(s.Bundle.Entry))
+	c.Assert(len(s.Transactions) > 1, Equals, true)
+	c.Assert(result.Responses, HasLen, len(s.Transactions))
+
+	// Every urn:uuid reference is to an entry in the same transaction; the rest are to resources already created
+	total := 0
+	created := make(map[string]bool)
+	for _, entries := range s.Transactions {
+		total += len(entries)
+		fullURLs := make(map[string]bool)
+		for _, entry := range entries {
+			fullURLs[entry["fullUrl"].(string)] = true
+		}
+		for _, entry := range entries {
+			for _, ref := range references(entry["resource"]) {
+				if strings.HasPrefix(ref, "urn:uuid:") {
+					c.Assert(fullURLs[ref], Equals, true, Commentf("unresolvable reference %s", ref))
+				} else {
+					c.Assert(created[ref], Equals, true, Commentf("reference %s to uncreated resource", ref))
+				}
+			}
+		}
+		for fullURL := range fullURLs {
+			created[result.Locations[fullURL].Reference()] = true
+		}
+	}
+	c.Assert(total, Equals, len(s.Bundle.Entry))
+
+	// The locations from all of the transactions link back to the patient's entries
+	m := s.Patient.MapLocations(result.Locations)
+	c.Assert(*m[hdsfhir.EntryKey{}].Resource, Equals, result.Locations[s.Bundle.Entry[0].FullUrl])
+	c.Assert(m[hdsfhir.EntryKey{Section: "conditions", Index: 0}].Resource.ResourceType, Equals, "Condition")
+}
+
+func (s *UploadSuite) TestSplitConditionalUpload(c *C) {
+	util.CheckErr(hdsfhir.ConvertToConditionalUpdates(s.Bundle))
+	client := s.client()
+	client.MaxEntries = 1
+	result, err := client.Upload(s.Bundle)
+	c.Assert(err, IsNil)
+
+	// The conditional updates after the first transaction search for the created patient
+	patient := result.Locations[s.Bundle.Entry[0].FullUrl].Reference()
+	request := s.Transactions[1][0]["request"].(map[string]interface{})
+	c.Assert(request["method"], Equals, "PUT")
+	u, err := url.Parse(request["url"].(string))
+	c.Assert(err, IsNil)
+	c.Assert(u.Query().Get("patient"), Equals, patient)
+}
+
+func (s *UploadSuite) TestRetry(c *C) {
+	s.Failures = 2
+	result, err := s.client().Upload(s.Bundle)
+	c.Assert(err, IsNil)
+	c.Assert(s.Requests, Equals, 3)
+	c.Assert(result.Locations, HasLen, len(s.Bundle.Entry))
+}
+
+func (s *UploadSuite) TestRetriesExhausted(c *C) {
+	s.Failures = 10
+	client := s.client()
+	client.MaxRetries = 2
+	result, err := client.Upload(s.Bundle)
+	c.Assert(err, FitsTypeOf, &StatusError{})
+	c.Assert(err.(*StatusError).StatusCode, Equals, http.StatusServiceUnavailable)
+	c.Assert(err, ErrorMatches, "server responded 503 Service Unavailable: Try again")
+	c.Assert(s.Requests, Equals, 3)
+	c.Assert(result.Locations, HasLen, 0)
+}
+
+func (s *UploadSuite) TestNoRetryForBadRequest(c *C) {
+	s.Failures = 1
+	s.FailureStatus = http.StatusBadRequest
+	_, err := s.client().Upload(s.Bundle)
+	c.Assert(err, FitsTypeOf, &StatusError{})
+	c.Assert(err.(*StatusError).Outcome.Issue, HasLen, 1)
+	c.Assert(s.Requests, Equals, 1)
+}
+
+func (s *UploadSuite) TestNetworkError(c *C) {
+	s.Server.Close()
+	client := s.client()
+	client.MaxRetries = 1
+	_, err := client.Upload(s.Bundle)
+	c.Assert(err, NotNil)
+	c.Assert(s.Requests, Equals, 0)
+}
+
+func (s *UploadSuite) TestNotTransaction(c *C) {
+	s.Bundle.Type = "collection"
+	_, err := s.client().Upload(s.Bundle)
+	c.Assert(err, NotNil)
+	c.Assert(s.Requests, Equals, 0)
+}
+
+func (s *UploadSuite) TestSplitTransaction(c *C) {
+	// Entry 1 refers ahead to entry 3, so the entries between them stay together
+	entries := []*transactionEntry{
+		{fullURL: "urn:uuid:0"},
+		{fullURL: "urn:uuid:1", references: []string{"urn:uuid:0", "urn:uuid:3"}},
+		{fullURL: "urn:uuid:2"},
+		{fullURL: "urn:uuid:3"},
+		{fullURL: "urn:uuid:4", references: []string{"urn:uuid:1"}},
+	}
+	c.Assert(transactionSizes(splitTransaction(entries, 0)), DeepEquals, []int{5})
+	c.Assert(transactionSizes(splitTransaction(entries, 1)), DeepEquals, []int{1, 3, 1})
+	c.Assert(transactionSizes(splitTransaction(entries, 2)), DeepEquals, []int{1, 3, 1})
+	c.Assert(transactionSizes(splitTransaction(entries, 4)), DeepEquals, []int{4, 1})
+	c.Assert(transactionSizes(splitTransaction(entries, 5)), DeepEquals, []int{5})
+}
+
+func transactionSizes(transactions []transaction) []int {
+	var sizes []int
+	for _, t := range transactions {
+		sizes = append(sizes, len(t))
+	}
+	return sizes
+}
+
+// references returns all of the references in the JSON resource
+func references(value interface{}) []string {
+	var refs []string
+	switch t := value.(type) {
+	case map[string]interface{}:
+		for key, v := range t {
+			if ref, ok := v.(string); ok && key == "reference" {
+				refs = append(refs, ref)
+			} else {
+				refs = append(refs, references(v)...)
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			refs = append(refs, references(v)...)
+		}
+	}
+	return refs
+}
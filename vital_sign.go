@@ -2,30 +2,211 @@ package hdsfhir
 
 import fhir "github.com/intervention-engine/fhir/models"
 
+// LOINC codes used to recognize blood pressures, which HDS records either as a single entry with two values or as
+// separate systolic and diastolic entries
+const (
+	bloodPressurePanelCode = "55284-4"
+	systolicCode           = "8480-6"
+	diastolicCode          = "8462-4"
+)
+
 type VitalSign struct {
 	Entry
-	Description    string        `json:"description"`
 	Interpretation *CodeObject   `json:"interpretation"`
 	Values         []ResultValue `json:"values"`
 }
 
 func (v *VitalSign) FHIRModels() []interface{} {
-	var fhirObservation *fhir.Observation
-	switch {
-	default:
-		fhirObservation = &fhir.Observation{}
-	case len(v.Values) == 1:
-		fhirObservation = v.Values[0].FHIRModels()[0].(*fhir.Observation)
-	case len(v.Values) > 1:
-		panic("FHIR Observations cannot have more than one value")
-	}
-	fhirObservation.Code = v.Codes.FHIRCodeableConcept(v.Description)
-	fhirObservation.Encounter = v.Patient.MatchingEncounterReference(v.Entry)
-	fhirObservation.EffectivePeriod = v.GetFHIRPeriod()
+	if systolic, diastolic := v.bloodPressureValues(); systolic != nil {
+		return []interface{}{bloodPressureObservation(&v.Entry, v.Interpretation, systolic, diastolic)}
+	}
+	if len(v.Values) > 1 {
+		return v.convertMultipleValues()
+	}
+
+	fhirObservation := valueObservations(&v.Entry, v.Values)[0]
+	fhirObservation.Category = observationCategory("vital-signs", "Vital Signs")
 	if v.Interpretation != nil {
 		fhirObservation.Interpretation = v.Interpretation.FHIRCodeableConcept("")
 	}
-	fhirObservation.Subject = v.Patient.FHIRReference()
 
 	return []interface{}{fhirObservation}
 }
+
+// FromFHIR sets the vital sign from the FHIR observation.  It is the inverse of FHIRModels, except that the members
+// of an observation with multiple values are left to FromFHIRBundle, since they are separate resources.
+func (v *VitalSign) FromFHIR(observation *fhir.Observation) {
+	v.Values = v.setFHIRObservation(observation)
+	v.Interpretation = CodeObjectFromFHIR(observation.Interpretation)
+	if len(observation.Component) > 0 {
+		// Blood pressure panels carry the systolic and diastolic values as components, whose codes are kept in the
+		// order of the values (see bloodPressureValues)
+		v.SetTempID(observation.Id)
+		v.Values = make([]ResultValue, len(observation.Component))
+		if v.Codes == nil {
+			v.Codes = CodeMap{}
+		}
+		for i, component := range observation.Component {
+			v.Values[i].setFHIRValue(component.ValueQuantity, component.ValueCodeableConcept, component.ValueString)
+			if component.Code == nil {
+				continue
+			}
+			for _, coding := range component.Code.Coding {
+				if coding.System == "http://loinc.org" {
+					v.Codes["LOINC"] = append(v.Codes["LOINC"], coding.Code)
+				}
+			}
+		}
+	}
+}
+
+// convertMultipleValues creates a parent observation that has each value's observation as a member, since FHIR
+// observations cannot have more than one value
+func (v *VitalSign) convertMultipleValues() []interface{} {
+	parent := valueObservations(&v.Entry, nil)[0]
+	parent.Category = observationCategory("vital-signs", "Vital Signs")
+	members := valueObservations(&v.Entry, v.Values)
+
+	models := []interface{}{parent}
+	for _, member := range members {
+		member.Category = observationCategory("vital-signs", "Vital Signs")
+		if v.Interpretation != nil {
+			member.Interpretation = v.Interpretation.FHIRCodeableConcept("")
+		}
+		parent.Related = append(parent.Related, fhir.ObservationRelatedComponent{
+			Type:   "has-member",
+			Target: &fhir.Reference{Reference: "urn:uuid:" + member.Id},
+		})
+		models = append(models, member)
+	}
+
+	return models
+}
+
+// bloodPressureValues returns the systolic and diastolic values of a vital sign with two values.  HDS values aren't
+// coded, so they can only be told apart when the entry is coded with both the systolic and diastolic LOINC codes,
+// which are taken to be in the same order as the values.  If they aren't, both values are nil, since the values can't
+// be paired (e.g., an entry that is only coded as a blood pressure panel).
+func (v *VitalSign) bloodPressureValues() (systolic, diastolic *ResultValue) {
+	if len(v.Values) != 2 {
+		return nil, nil
+	}
+	var codes []string
+	for _, code := range v.Codes["LOINC"] {
+		if code == systolicCode || code == diastolicCode {
+			codes = append(codes, code)
+		}
+	}
+	switch {
+	case len(codes) != 2 || codes[0] == codes[1]:
+		return nil, nil
+	case codes[0] == systolicCode:
+		return &v.Values[0], &v.Values[1]
+	}
+	return &v.Values[1], &v.Values[0]
+}
+
+// bloodPressureObservation creates a blood pressure panel observation with systolic and diastolic components.  The
+// observation uses the entry's own ID.  The interpretation applies to the whole panel, since DSTU2 components can't
+// have their own.
+func bloodPressureObservation(e *Entry, interpretation *CodeObject, systolic, diastolic *ResultValue) *fhir.Observation {
+	observation := valueObservations(e, nil)[0]
+	observation.Code = &fhir.CodeableConcept{
+		Coding: []fhir.Coding{
+			{System: "http://loinc.org", Code: bloodPressurePanelCode, Display: "Blood pressure panel with all children optional"},
+		},
+		Text: "Blood pressure panel with all children optional",
+	}
+	observation.Category = observationCategory("vital-signs", "Vital Signs")
+	if interpretation != nil {
+		observation.Interpretation = interpretation.FHIRCodeableConcept("")
+	}
+	observation.Component = []fhir.ObservationComponentComponent{
+		bloodPressureComponent(systolicCode, "Systolic blood pressure", systolic),
+		bloodPressureComponent(diastolicCode, "Diastolic blood pressure", diastolic),
+	}
+
+	return observation
+}
+
+func bloodPressureComponent(code, display string, value *ResultValue) fhir.ObservationComponentComponent {
+	valueObservation := value.FHIRModels()[0].(*fhir.Observation)
+	return fhir.ObservationComponentComponent{
+		Code: &fhir.CodeableConcept{
+			Coding: []fhir.Coding{
+				{System: "http://loinc.org", Code: code, Display: display},
+			},
+			Text: display,
+		},
+		ValueQuantity:        valueObservation.ValueQuantity,
+		ValueCodeableConcept: valueObservation.ValueCodeableConcept,
+		ValueString:          valueObservation.ValueString,
+	}
+}
+
+// effectiveTime returns the time used to decide if separate vital signs were taken together
+func (v *VitalSign) effectiveTime() *UnixTime {
+	if v.StartTime != nil {
+		return v.StartTime
+	}
+	return v.Time
+}
+
+// hasSingleValueCode indicates if the vital sign has exactly one value and is coded with the given LOINC code
+func (v *VitalSign) hasSingleValueCode(code string) bool {
+	return len(v.Values) == 1 && v.Codes.FHIRCodeableConcept("").MatchesCode("http://loinc.org", code)
+}
+
+// VitalSignPanel represents vital signs that are reported together.  Currently, only separate systolic and
+// diastolic vital signs taken at the same time are grouped.
+type VitalSignPanel []*VitalSign
+
+// GroupVitalSigns pairs separate systolic and diastolic vital signs taken at the same time, so that they can be
+// converted to a single blood pressure panel.  All other vital signs are in panels of their own.  Panels are ordered
+// by the first vital sign in each.
+func GroupVitalSigns(vitalSigns []*VitalSign) []VitalSignPanel {
+	paired := make(map[*VitalSign]*VitalSign)
+	used := make(map[*VitalSign]bool)
+	for _, systolic := range vitalSigns {
+		if !systolic.hasSingleValueCode(systolicCode) || systolic.effectiveTime() == nil {
+			continue
+		}
+		for _, diastolic := range vitalSigns {
+			if !used[diastolic] && diastolic.hasSingleValueCode(diastolicCode) && diastolic.effectiveTime() != nil &&
+				*diastolic.effectiveTime() == *systolic.effectiveTime() {
+
+				paired[systolic] = diastolic
+				used[diastolic] = true
+				break
+			}
+		}
+	}
+
+	var panels []VitalSignPanel
+	for _, vitalSign := range vitalSigns {
+		if diastolic, ok := paired[vitalSign]; ok {
+			panels = append(panels, VitalSignPanel{vitalSign, diastolic})
+		} else if !used[vitalSign] {
+			panels = append(panels, VitalSignPanel{vitalSign})
+		}
+	}
+	return panels
+}
+
+// FHIRModels returns a blood pressure panel observation for paired systolic and diastolic vital signs, using the
+// systolic vital sign's ID and the first interpretation given.  Otherwise, it returns the models for each vital sign.
+func (p VitalSignPanel) FHIRModels() []interface{} {
+	if len(p) == 2 {
+		interpretation := p[0].Interpretation
+		if interpretation == nil {
+			interpretation = p[1].Interpretation
+		}
+		return []interface{}{bloodPressureObservation(&p[0].Entry, interpretation, &p[0].Values[0], &p[1].Values[0])}
+	}
+
+	var models []interface{}
+	for _, vitalSign := range p {
+		models = append(models, vitalSign.FHIRModels()...)
+	}
+	return models
+}
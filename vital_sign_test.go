@@ -40,6 +40,7 @@ func (s *VitalSignSuite) TestFHIRModels(c *C) {
 
 	data := models[0].(*fhir.Observation)
 	c.Assert(data.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(data.Category.MatchesCode("http://hl7.org/fhir/observation-category", "vital-signs"), Equals, true)
 	c.Assert(data.Code.Text, Equals, "Laboratory Test, Result: HbA1c Laboratory Test")
 	c.Assert(data.Code.Coding, HasLen, 1)
 	c.Assert(data.Code.MatchesCode("http://loinc.org", "17856-6"), Equals, true)
@@ -52,3 +53,98 @@ func (s *VitalSignSuite) TestFHIRModels(c *C) {
 	c.Assert(data.EffectivePeriod.Start, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
 	c.Assert(data.EffectivePeriod.End, DeepEquals, NewUnixTime(1320149800).FHIRDateTime())
 }
+
+func (s *VitalSignSuite) TestBloodPressure(c *C) {
+	models := s.VitalSigns["bloodPressure"].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0], FitsTypeOf, &fhir.Observation{})
+
+	data := models[0].(*fhir.Observation)
+	c.Assert(data.Id, Equals, s.VitalSigns["bloodPressure"].GetTempID())
+	c.Assert(data.Status, Equals, "final")
+	c.Assert(data.Subject, DeepEquals, s.Patient.FHIRReference())
+	c.Assert(data.Encounter, DeepEquals, s.Encounter.FHIRReference())
+	c.Assert(data.Category.MatchesCode("http://hl7.org/fhir/observation-category", "vital-signs"), Equals, true)
+	c.Assert(data.Code.MatchesCode("http://loinc.org", "55284-4"), Equals, true)
+	c.Assert(data.ValueQuantity, IsNil)
+	c.Assert(data.Component, HasLen, 2)
+	c.Assert(data.Component[0].Code.MatchesCode("http://loinc.org", "8480-6"), Equals, true)
+	c.Assert(*data.Component[0].ValueQuantity.Value, Equals, float64(120))
+	c.Assert(data.Component[0].ValueQuantity.Unit, Equals, "mm[Hg]")
+	c.Assert(data.Component[1].Code.MatchesCode("http://loinc.org", "8462-4"), Equals, true)
+	c.Assert(*data.Component[1].ValueQuantity.Value, Equals, float64(80))
+	c.Assert(data.Interpretation.MatchesCode("urn:oid:2.16.840.1.113883.1.11.78", "H"), Equals, true)
+}
+
+func (s *VitalSignSuite) TestBloodPressureFromFHIR(c *C) {
+	observation := s.VitalSigns["bloodPressure"].FHIRModels()[0].(*fhir.Observation)
+	restored := &VitalSign{Entry: Entry{Patient: s.Patient}}
+	restored.FromFHIR(observation)
+	c.Assert(restored.Codes["LOINC"], DeepEquals, []string{"55284-4", "8480-6", "8462-4"})
+	c.Assert(restored.Values[0].Physical.Scalar, Equals, "120")
+
+	// The component codes are kept, so the values are paired again
+	models := restored.FHIRModels()
+	c.Assert(models, HasLen, 1)
+	c.Assert(models[0].(*fhir.Observation).Component, DeepEquals, observation.Component)
+}
+
+func (s *VitalSignSuite) TestUncodedBloodPressureValues(c *C) {
+	// Without the systolic and diastolic codes, there's no telling which value is which, so they aren't paired
+	models := s.VitalSigns["uncodedBloodPressure"].FHIRModels()
+	c.Assert(models, HasLen, 3)
+	parent := models[0].(*fhir.Observation)
+	c.Assert(parent.Component, IsNil)
+	c.Assert(parent.Related, HasLen, 2)
+	c.Assert(*models[1].(*fhir.Observation).ValueQuantity.Value, Equals, float64(120))
+	c.Assert(*models[2].(*fhir.Observation).ValueQuantity.Value, Equals, float64(80))
+}
+
+func (s *VitalSignSuite) TestMultipleValues(c *C) {
+	models := s.VitalSigns["heartRates"].FHIRModels()
+	c.Assert(models, HasLen, 3)
+
+	parent := models[0].(*fhir.Observation)
+	c.Assert(parent.Id, Equals, s.VitalSigns["heartRates"].GetTempID())
+	c.Assert(parent.Code.MatchesCode("http://loinc.org", "8867-4"), Equals, true)
+	c.Assert(parent.ValueQuantity, IsNil)
+	c.Assert(parent.Related, HasLen, 2)
+	for i, value := range []float64{72, 75} {
+		member := models[i+1].(*fhir.Observation)
+		c.Assert(parent.Related[i].Type, Equals, "has-member")
+		c.Assert(parent.Related[i].Target.Reference, Equals, "urn:uuid:"+member.Id)
+		c.Assert(member.Code.MatchesCode("http://loinc.org", "8867-4"), Equals, true)
+		c.Assert(member.Subject, DeepEquals, s.Patient.FHIRReference())
+		c.Assert(*member.ValueQuantity.Value, Equals, value)
+	}
+}
+
+func (s *VitalSignSuite) TestGroupVitalSigns(c *C) {
+	panels := GroupVitalSigns([]*VitalSign{s.VitalSigns["diastolic"], s.VitalSigns["hba1c"], s.VitalSigns["laterSystolic"], s.VitalSigns["systolic"]})
+	c.Assert(panels, HasLen, 3)
+	c.Assert(panels[0], DeepEquals, VitalSignPanel{s.VitalSigns["hba1c"]})
+	c.Assert(panels[1], DeepEquals, VitalSignPanel{s.VitalSigns["laterSystolic"]})
+	c.Assert(panels[2], DeepEquals, VitalSignPanel{s.VitalSigns["systolic"], s.VitalSigns["diastolic"]})
+
+	models := panels[2].FHIRModels()
+	c.Assert(models, HasLen, 1)
+	data := models[0].(*fhir.Observation)
+	c.Assert(data.Id, Equals, s.VitalSigns["systolic"].GetTempID())
+	c.Assert(data.Code.MatchesCode("http://loinc.org", "55284-4"), Equals, true)
+	c.Assert(*data.Component[0].ValueQuantity.Value, Equals, float64(132))
+	c.Assert(*data.Component[1].ValueQuantity.Value, Equals, float64(84))
+	c.Assert(data.Interpretation, IsNil)
+}
+
+func (s *VitalSignSuite) TestPanelInterpretation(c *C) {
+	// Either vital sign's interpretation applies to the panel, preferring the systolic one
+	systolic := &VitalSign{Entry: Entry{Patient: s.Patient}, Values: s.VitalSigns["systolic"].Values}
+	diastolic := &VitalSign{Entry: Entry{Patient: s.Patient}, Values: s.VitalSigns["diastolic"].Values}
+	diastolic.Interpretation = &CodeObject{CodeSystem: "HL7 Observation Interpretation", Code: "L"}
+	data := VitalSignPanel{systolic, diastolic}.FHIRModels()[0].(*fhir.Observation)
+	c.Assert(data.Interpretation.MatchesCode("urn:oid:2.16.840.1.113883.1.11.78", "L"), Equals, true)
+
+	systolic.Interpretation = &CodeObject{CodeSystem: "HL7 Observation Interpretation", Code: "H"}
+	data = VitalSignPanel{systolic, diastolic}.FHIRModels()[0].(*fhir.Observation)
+	c.Assert(data.Interpretation.MatchesCode("urn:oid:2.16.840.1.113883.1.11.78", "H"), Equals, true)
+}